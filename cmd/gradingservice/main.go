@@ -2,14 +2,33 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
+	"go-distributed/grades"
 	"go-distributed/log"
 	"go-distributed/registry"
 	"go-distributed/service"
 	stlog "log"
+	"net/http"
 )
 
 func main() {
+	// 存储、初始数据、评分策略和认证的配置
+	var cfg config
+	flag.StringVar(&cfg.store, "store", "./grades.journal", "成绩数据的日志文件路径，为空时只保存在内存中")
+	flag.StringVar(&cfg.fixtures, "fixtures", "", "初始学生数据的 JSON 文件，为空时使用内置的示例数据；只在存储为空时使用")
+	flag.StringVar(&cfg.policy, "policy", "", "评分策略的 JSON 文件，为空时使用不加权的平均分和默认的等级划分")
+	flag.StringVar(&cfg.tokens, "tokens", "", "API 令牌的 JSON 文件，指定后启用认证")
+	flag.StringVar(&cfg.sessionSecret, "session-secret", "", "签发和校验会话令牌的密钥，指定后启用认证")
+	fallbackLog := flag.String("log", "http://localhost:4000", "注册中心中没有日志服务时使用的日志服务地址")
+	flag.Parse()
+
+	srv, store, err := newServer(cfg)
+	if err != nil {
+		stlog.Fatalln(err)
+	}
+	defer store.Close()
+
 	// 将变量host和port分别设置为"localhost"和"6000"
 	host, port := "localhost", "6000"
 	// 使用host和port变量创建serviceAddress字符串
@@ -23,18 +42,91 @@ func main() {
 		HeartBeatURL:     serviceAddress + "/heartbeat",
 	}
 	// 使用给定参数启动服务，并存储上下文和错误值
-	ctx, err := service.Start(context.Background(), host, port, log.RegisterHandlers, r)
+	ctx, err := service.Start(context.Background(), host, port, func() { srv.RegisterHandlers(http.DefaultServeMux) }, r)
 	// 如果启动服务时出现错误，则记录错误
 	if err != nil {
 		stlog.Fatalln(err)
 	}
 	if logProvider, err := registry.GetProvider(registry.LogService); err == nil {
 		fmt.Printf("logging service found at : %v\n", logProvider)
-		log.SetClientLogger(logProvider, r.ServiceName)
 	}
+	// 日志发送到注册中心当前发现的日志服务，日志服务稍后才启动或发生故障转移时也能找到
+	startLogging(*fallbackLog, serviceAddress, registry.GetProvider)
+	// 允许在运行时通过 /loglevel 查看和修改本实例的最低日志级别
+	http.Handle("/loglevel", logLevelHandler(srv, log.DefaultClient()))
 	// 等待上下文完成(即服务关闭)
 	<-ctx.Done()
+	// 发送尚未发送的日志
+	log.CloseClientLogger()
 
-	// 打印指示日志服务正在关闭的消息
+	// 打印指示成绩服务正在关闭的消息
 	fmt.Println("Shutting down grading service")
 }
+
+// startLogging 把本实例的日志发送到 discover 发现的日志服务，没有发现日志服务时发送到 fallback
+func startLogging(fallback, instance string, discover func(registry.ServiceName) (string, error)) {
+	log.SetClientLoggerWithConfig(fallback, registry.GradingService, log.ClientConfig{
+		Instance: instance,
+		Resolve: func() (string, error) {
+			if url, err := discover(registry.LogService); err == nil {
+				return url, nil
+			}
+			return fallback, nil
+		},
+	})
+}
+
+// logLevelHandler 返回查看和修改日志客户端 c 的最低日志级别的处理器，启用认证时只有管理员可以访问
+func logLevelHandler(srv *grades.Server, c *log.Client) http.Handler {
+	return srv.Restrict(c.LevelHandler(), grades.RoleAdmin)
+}
+
+// config 是成绩服务的存储、初始数据、评分策略和认证的配置，对应同名的命令行参数
+type config struct {
+	store         string
+	fixtures      string
+	policy        string
+	tokens        string
+	sessionSecret string
+}
+
+// newServer 按 cfg 打开存储、加载评分策略和令牌，创建成绩服务实例。返回的存储需要在退出前关闭。
+func newServer(cfg config) (*grades.Server, grades.Store, error) {
+	seed := grades.MockStudents()
+	if cfg.fixtures != "" {
+		var err error
+		if seed, err = grades.LoadFixtures(cfg.fixtures); err != nil {
+			return nil, nil, err
+		}
+	}
+	var store grades.Store = grades.NewMemoryStore(seed)
+	if cfg.store != "" {
+		fs, err := grades.OpenFileStore(cfg.store, seed)
+		if err != nil {
+			return nil, nil, err
+		}
+		store = fs
+	}
+	srv := grades.NewServer(store)
+
+	if cfg.policy != "" {
+		policy, err := grades.LoadPolicy(cfg.policy)
+		if err != nil {
+			store.Close()
+			return nil, nil, err
+		}
+		srv.SetPolicy(policy)
+	}
+
+	if cfg.tokens != "" || cfg.sessionSecret != "" {
+		auth := grades.NewAuthenticator([]byte(cfg.sessionSecret))
+		if cfg.tokens != "" {
+			if err := auth.LoadTokens(cfg.tokens); err != nil {
+				store.Close()
+				return nil, nil, err
+			}
+		}
+		srv.SetAuthenticator(auth)
+	}
+	return srv, store, nil
+}
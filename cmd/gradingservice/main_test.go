@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go-distributed/grades"
+	"go-distributed/log"
+	"go-distributed/registry"
+	"go-distributed/testcluster"
+	stlog "log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestStudentsEndToEnd 在同一进程中启动注册中心、日志服务和按本程序的配置创建的成绩服务，
+// 通过注册中心发现的成绩服务读写 /students
+func TestStudentsEndToEnd(t *testing.T) {
+	cfg := config{store: filepath.Join(t.TempDir(), "grades.journal")}
+	var stores []grades.Store
+	c, err := testcluster.Start(testcluster.Options{
+		NewGradingServer: func() (*grades.Server, error) {
+			srv, store, err := newServer(cfg)
+			if err == nil {
+				stores = append(stores, store)
+			}
+			return srv, err
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		c.Close()
+		for _, store := range stores {
+			store.Close()
+		}
+	}()
+	if err := c.WaitForConvergence(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	logs := c.Instances(registry.LogService)
+	grading := c.Instances(registry.GradingService)[0]
+	c.AssertProviders(t, grading, registry.LogService, logs[0].URL)
+
+	// 成绩服务的日志发送到它从注册中心发现的日志服务，而不是不可用的备用地址
+	startLogging("http://127.0.0.1:1", grading.URL, grading.Client.GetProvider)
+	defer resetStdLog()
+	stlog.Println("grading service end-to-end test")
+	log.CloseClientLogger()
+	// 日志服务按同步周期把缓冲的日志写入文件
+	received := func() bool {
+		data, _ := os.ReadFile(logs[0].LogFile())
+		return strings.Contains(string(data), "grading service end-to-end test")
+	}
+	if err := c.WaitFor(5*time.Second, received); err != nil {
+		t.Fatalf("log service %s did not receive the grading service's log: %v", logs[0].URL, err)
+	}
+
+	providers := c.Registered(registry.GradingService)
+	if len(providers) != 1 {
+		t.Fatalf("registered grading services are %v, want one", providers)
+	}
+	base := providers[0]
+
+	// POST 新建学生，ETag 是第一个版本
+	res := do(t, http.MethodPost, base+"/students", "", grades.Student{FirstName: "Ada", LastName: "Lovelace"})
+	var created grades.Student
+	decode(t, res, http.StatusCreated, &created)
+	if created.ID == 0 || res.Header.Get("ETag") != `"1"` {
+		t.Fatalf("created student %+v with ETag %s, want an ID and ETag \"1\"", created, res.Header.Get("ETag"))
+	}
+	path := fmt.Sprintf("%s/students/%d", base, created.ID)
+
+	// GET 读回同一个学生
+	res = do(t, http.MethodGet, path, "", nil)
+	var got grades.Student
+	decode(t, res, http.StatusOK, &got)
+	if got.FirstName != "Ada" || got.LastName != "Lovelace" {
+		t.Fatalf("GET returned %+v", got)
+	}
+	stale := res.Header.Get("ETag")
+
+	// PUT 带上当前的 ETag 修改成功，版本增加
+	got.LastName = "King"
+	res = do(t, http.MethodPut, path, stale, got)
+	var updated grades.Student
+	decode(t, res, http.StatusOK, &updated)
+	if updated.LastName != "King" || res.Header.Get("ETag") == stale {
+		t.Fatalf("PUT returned %+v with ETag %s, want LastName King and a new ETag", updated, res.Header.Get("ETag"))
+	}
+
+	// 用过期的 ETag 再次 PUT 返回 412，数据保持不变
+	got.LastName = "Byron"
+	res = do(t, http.MethodPut, path, stale, got)
+	decode(t, res, http.StatusPreconditionFailed, nil)
+	res = do(t, http.MethodGet, path, "", nil)
+	decode(t, res, http.StatusOK, &got)
+	if got.LastName != "King" {
+		t.Fatalf("stale PUT changed the student to %+v", got)
+	}
+}
+
+// resetStdLog 恢复 startLogging 修改的标准库日志记录器
+func resetStdLog() {
+	log.CloseClientLogger()
+	stlog.SetOutput(os.Stderr)
+	stlog.SetPrefix("")
+	stlog.SetFlags(stlog.LstdFlags)
+}
+
+func TestLogLevelRequiresAdmin(t *testing.T) {
+	tokens := filepath.Join(t.TempDir(), "tokens.json")
+	err := os.WriteFile(tokens, []byte(`{
+		"admin-token": {"name": "root", "role": "admin"},
+		"teacher-token": {"name": "grace", "role": "teacher", "courses": [1]}
+	}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := log.NewClient(string(registry.GradingService), log.ClientConfig{
+		Resolve:           func() (string, error) { return "http://127.0.0.1:1", nil },
+		LevelPollInterval: -1,
+		SpoolDir:          t.TempDir(),
+	})
+	defer client.Close()
+
+	tests := []struct {
+		name  string
+		cfg   config
+		token string
+		want  int
+	}{
+		{"auth disabled", config{}, "", http.StatusOK},
+		{"no token", config{tokens: tokens}, "", http.StatusUnauthorized},
+		{"teacher", config{tokens: tokens}, "teacher-token", http.StatusForbidden},
+		{"admin", config{tokens: tokens}, "admin-token", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, store, err := newServer(tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+			ts := httptest.NewServer(logLevelHandler(srv, client))
+			defer ts.Close()
+			for _, method := range []string{http.MethodGet, http.MethodPut} {
+				req, err := http.NewRequest(method, ts.URL, strings.NewReader(`{"Level": "DEBUG"}`))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if tt.token != "" {
+					req.Header.Set("Authorization", "Bearer "+tt.token)
+				}
+				res, err := http.DefaultClient.Do(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				res.Body.Close()
+				if res.StatusCode != tt.want {
+					t.Fatalf("%s /loglevel = %d, want %d", method, res.StatusCode, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// do 发送请求体为 body 的 JSON 编码的请求，ifMatch 不为空时作为 If-Match 发送
+func do(t *testing.T, method, url, ifMatch string, body interface{}) *http.Response {
+	t.Helper()
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req, err := http.NewRequest(method, url, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+// decode 检查响应的状态码，v 不为 nil 时把响应体解码到 v
+func decode(t *testing.T, res *http.Response, status int, v interface{}) {
+	t.Helper()
+	defer res.Body.Close()
+	if res.StatusCode != status {
+		t.Fatalf("%s %s responded with %v, want %v", res.Request.Method, res.Request.URL, res.StatusCode, status)
+	}
+	if v != nil {
+		if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"go-distributed/log"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// fieldFlags 收集可重复的 -field key:value 参数
+type fieldFlags []string
+
+func (f *fieldFlags) String() string     { return strings.Join(*f, ",") }
+func (f *fieldFlags) Set(v string) error { *f = append(*f, v); return nil }
+
+// main函数，logctl 是日志服务的命令行工具：
+//
+//	logctl query [-service S] [-level L] [-since T] [-until T] [-q text] [-regex RE] [-field k:v] [-limit N] [-offset N]
+//	logctl tail  [-service S] [-level L] [-q text] [-regex RE] [-field k:v]
+//	logctl level -service S [-instance URL] -level L | -clear
+//	logctl sources [-service S]
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	cmd := os.Args[1]
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	serviceURL := fs.String("url", "http://localhost:4000", "日志服务的地址")
+	service := fs.String("service", "", "只显示该服务的日志")
+	level := fs.String("level", "", "最低日志级别：DEBUG、INFO、WARN、ERROR")
+	q := fs.String("q", "", "消息中包含的文本")
+	regex := fs.String("regex", "", "消息需要匹配的正则表达式")
+	var fields fieldFlags
+	fs.Var(&fields, "field", "字段过滤条件 key:value，可重复")
+	since := fs.String("since", "", "起始时间（RFC3339）或相对时长，例如 15m")
+	until := fs.String("until", "", "结束时间（RFC3339）")
+	limit := fs.Int("limit", 100, "每页记录数")
+	offset := fs.Int("offset", 0, "跳过的记录数")
+	instance := fs.String("instance", "", "level 命令：只修改该实例的级别")
+	clearLevel := fs.Bool("clear", false, "level 命令：删除集中配置的级别")
+	fs.Parse(os.Args[2:])
+
+	params := url.Values{}
+	set := func(key, value string) {
+		if value != "" {
+			params.Set(key, value)
+		}
+	}
+	set("service", *service)
+	set("level", *level)
+	set("q", *q)
+	set("regex", *regex)
+	set("until", *until)
+	if d, err := time.ParseDuration(*since); err == nil {
+		set("since", time.Now().Add(-d).Format(time.RFC3339))
+	} else {
+		set("since", *since)
+	}
+	for _, f := range fields {
+		params.Add("field", f)
+	}
+
+	switch cmd {
+	case "query":
+		params.Set("limit", fmt.Sprint(*limit))
+		params.Set("offset", fmt.Sprint(*offset))
+		result, err := log.Query(*serviceURL, params)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		for _, rec := range result.Records {
+			printRecord(rec)
+		}
+		if result.NextOffset > 0 {
+			fmt.Fprintf(os.Stderr, "-- %d of %d records, next page: -offset %d\n",
+				len(result.Records), result.Total, result.NextOffset)
+		}
+	case "tail":
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer cancel()
+		if err := log.Tail(ctx, *serviceURL, params, printRecord); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	case "level":
+		var err error
+		if *clearLevel {
+			err = log.ClearLevel(*serviceURL, *service, *instance)
+		} else {
+			err = log.SetLevel(*serviceURL, log.LevelSetting{
+				Service:  *service,
+				Instance: *instance,
+				Level:    log.Level(*level),
+			})
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	case "sources":
+		sources, err := log.Sources(*serviceURL, *service)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "SERVICE\tINSTANCE\tRECORDS\tBYTES\tREC/S\tERRORS\tREJECTED\tLAST SEEN")
+		for _, src := range sources {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%.2f\t%d\t%d\t%s ago\n",
+				src.Service, src.Instance, src.Records, src.Bytes, src.RecordsPerSec,
+				src.ErrorRecords, src.Rejected, time.Duration(src.IdleSeconds*float64(time.Second)).Round(time.Second))
+		}
+		w.Flush()
+	default:
+		usage()
+	}
+}
+
+// printRecord 以一行文本的形式打印日志记录
+func printRecord(rec log.Record) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %-5s", rec.Time.Format(time.RFC3339), rec.Level)
+	if rec.Service != "" {
+		fmt.Fprintf(&b, " [%s]", rec.Service)
+	}
+	fmt.Fprintf(&b, " %s", rec.Message)
+	for k, v := range rec.Fields {
+		fmt.Fprintf(&b, " %s=%v", k, v)
+	}
+	if rec.TraceID != "" {
+		fmt.Fprintf(&b, " trace=%s", rec.TraceID)
+	}
+	fmt.Println(b.String())
+}
+
+// usage 打印用法并退出
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: logctl query|tail|level|sources [flags]")
+	os.Exit(2)
+}
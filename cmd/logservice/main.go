@@ -2,17 +2,63 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"go-distributed/log"
 	"go-distributed/registry"
 	"go-distributed/service"
 	stlog "log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 // main函数
 func main() {
-	// 运行log包，"./distributed.log"是日志文件路径
-	log.Run("./distributed.log")
+	// 输出目标和路由规则的配置文件，指定时忽略下面的文件参数
+	configPath := flag.String("config", "", "输出目标和路由规则的 JSON 配置文件")
+	// 日志文件路径以及轮转、保留和同步策略
+	destination := flag.String("file", "./distributed.log", "日志文件路径")
+	var cfg log.FileConfig
+	flag.Int64Var(&cfg.MaxSize, "max-size", 100<<20, "单个日志文件的最大字节数，0 表示不按大小轮转")
+	flag.DurationVar(&cfg.RotateInterval, "rotate-interval", 0, "按时间轮转的周期，例如 24h，0 表示不按时间轮转")
+	flag.BoolVar(&cfg.Compress, "compress", true, "是否 gzip 压缩轮转后的文件")
+	flag.DurationVar(&cfg.MaxAge, "max-age", 7*24*time.Hour, "轮转文件的最长保留时间，0 表示不限制")
+	flag.Int64Var(&cfg.MaxTotalSize, "max-total-size", 1<<30, "轮转文件的总大小上限，0 表示不限制")
+	walPath := flag.String("wal", "./distributed.wal", "预写日志路径，为空时不使用预写日志")
+	syncPolicy := flag.String("sync", string(log.SyncInterval), "同步策略：always、interval、never")
+	flag.DurationVar(&cfg.SyncInterval, "sync-interval", time.Second, "interval 策略下的同步周期")
+	flag.Parse()
+	cfg.Sync = log.SyncPolicy(*syncPolicy)
+
+	// 运行log包
+	sinks := log.SingleFileConfig(*destination, cfg)
+	if *configPath != "" {
+		var err error
+		if sinks, err = log.LoadConfig(*configPath); err != nil {
+			stlog.Fatalln(err)
+		}
+	}
+	if sinks.WAL == "" {
+		sinks.WAL = *walPath
+	}
+	if err := log.RunWithSinks(sinks); err != nil {
+		stlog.Fatalln(err)
+	}
+	defer log.Close()
+
+	// 收到 SIGHUP 时重新打开日志文件，以配合外部的日志轮转工具
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := log.Reopen(); err != nil {
+				stlog.Println(err)
+			}
+		}
+	}()
+
 	// 将变量host和port分别设置为"localhost"和"4000"
 	host, port := "localhost", "4000"
 	// 使用host和port变量创建serviceAddress字符串
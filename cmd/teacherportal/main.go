@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"go-distributed/log"
+	"go-distributed/portal"
+	"go-distributed/registry"
+	"go-distributed/service"
+	stlog "log"
+	"net/http"
+)
+
+func main() {
+	// 用户登录门户时提供自己在成绩服务的令牌，门户本身不持有令牌
+	fallbackLog := flag.String("log", "http://localhost:4000", "注册中心中没有日志服务时使用的日志服务地址")
+	flag.Parse()
+
+	// 将变量host和port分别设置为"localhost"和"5000"
+	host, port := "localhost", "5000"
+	// 使用host和port变量创建serviceAddress字符串
+	serviceAddress := fmt.Sprintf("http://%s:%s", host, port)
+	// 创建一个registry registration，门户依赖成绩服务和日志服务
+	r := registry.Registration{
+		ServiceName:      registry.TeacherPortal,
+		ServiceUrl:       serviceAddress,
+		RequiredServices: []registry.ServiceName{registry.GradingService, registry.LogService},
+		ServiceUpdateURL: serviceAddress + "/services",
+		HeartBeatURL:     serviceAddress + "/heartbeat",
+	}
+	// 使用给定参数启动服务，并存储上下文和错误值
+	ctx, err := service.Start(context.Background(), host, port, portal.RegisterHandlers, r)
+	// 如果启动服务时出现错误，则记录错误
+	if err != nil {
+		stlog.Fatalln(err)
+	}
+	if gradingProvider, err := registry.GetProvider(registry.GradingService); err == nil {
+		fmt.Printf("grading service found at : %v\n", gradingProvider)
+	}
+	// 日志发送到注册中心当前发现的日志服务
+	log.SetClientLoggerWithConfig(*fallbackLog, r.ServiceName, log.ClientConfig{Instance: serviceAddress})
+	// 允许在运行时通过 /loglevel 查看和修改本实例的最低日志级别
+	http.Handle("/loglevel", log.DefaultClient().LevelHandler())
+	// 等待上下文完成(即服务关闭)
+	<-ctx.Done()
+	// 发送尚未发送的日志
+	log.CloseClientLogger()
+
+	// 打印指示门户正在关闭的消息
+	fmt.Println("Shutting down teacher portal")
+}
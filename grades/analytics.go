@@ -0,0 +1,362 @@
+package grades
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// defaultBuckets 是直方图默认的分组数
+	defaultBuckets = 10
+	// maxCachedResults 是缓存的统计结果的条数上限，超过后清空缓存
+	maxCachedResults = 256
+)
+
+// Bucket 是直方图中的一组，包含 Min 不包含 Max，最后一组同时包含 Max
+type Bucket struct {
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Count int     `json:"count"`
+}
+
+// Stats 是一组分数的统计结果
+type Stats struct {
+	Count       int                `json:"count"`
+	Mean        float64            `json:"mean"`
+	Median      float64            `json:"median"`
+	StdDev      float64            `json:"stddev"`
+	Min         float64            `json:"min"`
+	Max         float64            `json:"max"`
+	Percentiles map[string]float64 `json:"percentiles,omitempty"`
+	Histogram   []Bucket           `json:"histogram,omitempty"`
+}
+
+// AssessmentStats 是一项考核（Title 和 Type 都相同的成绩）的统计结果
+type AssessmentStats struct {
+	Title string    `json:"title"`
+	Type  GradeType `json:"type"`
+	Stats
+}
+
+// StudentScore 是一个学生按评分策略计算的总评
+type StudentScore struct {
+	ID        int     `json:"id"`
+	FirstName string  `json:"firstName"`
+	LastName  string  `json:"lastName"`
+	Summary   Summary `json:"summary"`
+}
+
+// computeStats 计算 scores 的统计结果，buckets 大于 0 时同时计算百分位数和直方图
+func computeStats(scores []float64, buckets int) Stats {
+	st := Stats{Count: len(scores)}
+	if len(scores) == 0 {
+		return st
+	}
+	sorted := append([]float64{}, scores...)
+	sort.Float64s(sorted)
+	var sum float64
+	for _, s := range sorted {
+		sum += s
+	}
+	st.Mean = sum / float64(len(sorted))
+	var variance float64
+	for _, s := range sorted {
+		variance += (s - st.Mean) * (s - st.Mean)
+	}
+	st.StdDev = math.Sqrt(variance / float64(len(sorted)))
+	st.Min, st.Max = sorted[0], sorted[len(sorted)-1]
+	st.Median = percentile(sorted, 50)
+	if buckets <= 0 {
+		return st
+	}
+	st.Percentiles = make(map[string]float64)
+	for _, p := range []int{10, 25, 50, 75, 90, 95} {
+		st.Percentiles["p"+strconv.Itoa(p)] = percentile(sorted, float64(p))
+	}
+	width := float64(MaxScore-MinScore) / float64(buckets)
+	st.Histogram = make([]Bucket, buckets)
+	for i := range st.Histogram {
+		st.Histogram[i] = Bucket{Min: MinScore + width*float64(i), Max: MinScore + width*float64(i+1)}
+	}
+	for _, s := range sorted {
+		i := int((s - MinScore) / width)
+		if i >= buckets {
+			i = buckets - 1
+		}
+		if i < 0 {
+			i = 0
+		}
+		st.Histogram[i].Count++
+	}
+	return st
+}
+
+// percentile 用线性插值计算已排序的 sorted 的第 p 百分位数
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 1 {
+		return sorted[0]
+	}
+	rank := p / 100 * float64(len(sorted)-1)
+	lo := int(math.Floor(rank))
+	hi := int(math.Ceil(rank))
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(rank-float64(lo))
+}
+
+// gradeFilter 选出参与统计的成绩，零值选出所有成绩
+type gradeFilter struct {
+	Title    string
+	Type     GradeType
+	CourseID int
+	TermID   int
+}
+
+// parseGradeFilter 从查询参数 title、type、course、term 中解析过滤条件
+func parseGradeFilter(q url.Values) (gradeFilter, error) {
+	var f gradeFilter
+	var verr ValidationError
+	f.Title = q.Get("title")
+	if t := q.Get("type"); t != "" {
+		f.Type = GradeType(t)
+		if !f.Type.Valid() {
+			verr.add("type", "unknown grade type")
+		}
+	}
+	// 按固定的顺序检查，校验错误的顺序是确定的
+	for _, p := range []struct {
+		name string
+		dst  *int
+	}{{"course", &f.CourseID}, {"term", &f.TermID}} {
+		if v := q.Get(p.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				verr.add(p.name, "must be an integer ID")
+			}
+			*p.dst = n
+		}
+	}
+	return f, verr.err()
+}
+
+// scores 返回 ss 中满足过滤条件的成绩的分数
+func (f gradeFilter) scores(ss Students, catalog Catalog) []float64 {
+	var enrollments map[int]bool
+	if f.CourseID != 0 || f.TermID != 0 {
+		enrollments = make(map[int]bool)
+		for _, e := range catalog.Enrollments {
+			section, err := catalog.Section(e.SectionID)
+			if err != nil {
+				continue
+			}
+			if (f.CourseID == 0 || section.CourseID == f.CourseID) && (f.TermID == 0 || section.TermID == f.TermID) {
+				enrollments[e.ID] = true
+			}
+		}
+	}
+	scores := []float64{}
+	for _, s := range ss {
+		for _, g := range s.Grades {
+			if f.Title != "" && g.Title != f.Title {
+				continue
+			}
+			if f.Type != "" && g.Type != f.Type {
+				continue
+			}
+			if enrollments != nil && !enrollments[g.EnrollmentID] {
+				continue
+			}
+			scores = append(scores, float64(g.Score))
+		}
+	}
+	return scores
+}
+
+// analyticsHandler 处理统计分析相关的请求，结果按存储的版本号缓存，数据修改后自动失效
+type analyticsHandler struct {
+	store   Store
+	policy  Policy
+	mutex   sync.Mutex
+	version uint64
+	cache   map[string]interface{}
+}
+
+// ServeHTTP 按路径分发请求，只支持 GET：
+//
+//	/analytics/assessments              每项考核的统计，参数 type、course、term
+//	/analytics/distribution             满足条件的成绩的分布，参数 title、type、course、term、buckets
+//	/analytics/types                    每种成绩类型的统计，参数 course、term
+//	/analytics/below?threshold={score}  总评低于 threshold 的学生
+//
+// 启用认证时只有教师和管理员可以访问。
+func (ah *analyticsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, errMethodNotAllowed)
+		return
+	}
+	if err := requireRole(r, RoleTeacher, RoleAdmin); err != nil {
+		writeError(w, err)
+		return
+	}
+	var compute func(Students, Catalog) interface{}
+	var err error
+	q := r.URL.Query()
+	switch strings.TrimPrefix(r.URL.Path, "/analytics/") {
+	case "assessments":
+		compute, err = ah.assessments(q)
+	case "distribution":
+		compute, err = ah.distribution(q)
+	case "types":
+		compute, err = ah.types(q)
+	case "below":
+		compute, err = ah.below(q)
+	default:
+		err = errRouteNotFound
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	result, err := ah.cached(r, r.URL.Path+"?"+q.Encode(), compute)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// cached 返回 key 对应的缓存结果，存储的版本号变化后清空缓存重新计算。
+// 教师只统计选修自己课程的学生在这些课程中的成绩，结果按教师分别缓存。
+func (ah *analyticsHandler) cached(r *http.Request, key string, compute func(Students, Catalog) interface{}) (interface{}, error) {
+	p, teacher := PrincipalFrom(r.Context())
+	teacher = teacher && p.Role == RoleTeacher
+	if teacher {
+		key = fmt.Sprintf("teacher %q %v %s", p.Name, p.Courses, key)
+	}
+	// 先读版本号再读数据：读取期间发生的修改会让版本号变化，下次请求时重新计算
+	version := ah.store.Version()
+	ah.mutex.Lock()
+	if ah.cache == nil || ah.version != version {
+		ah.cache = make(map[string]interface{})
+		ah.version = version
+	}
+	result, ok := ah.cache[key]
+	ah.mutex.Unlock()
+	if ok {
+		return result, nil
+	}
+	students, err := ah.store.List()
+	if err != nil {
+		return nil, err
+	}
+	catalog, err := ah.store.Catalog()
+	if err != nil {
+		return nil, err
+	}
+	if teacher {
+		students = catalog.teacherStudents(p, students)
+	}
+	result = compute(students, catalog)
+	ah.mutex.Lock()
+	if ah.version == version {
+		if len(ah.cache) >= maxCachedResults {
+			ah.cache = make(map[string]interface{})
+		}
+		ah.cache[key] = result
+	}
+	ah.mutex.Unlock()
+	return result, nil
+}
+
+func (ah *analyticsHandler) assessments(q url.Values) (func(Students, Catalog) interface{}, error) {
+	f, err := parseGradeFilter(q)
+	if err != nil {
+		return nil, err
+	}
+	return func(ss Students, catalog Catalog) interface{} {
+		// 名称相同、类型不同的成绩是不同的考核，与成绩册的列一致
+		type assessment struct {
+			title string
+			typ   GradeType
+		}
+		seen := make(map[assessment]bool)
+		var assessments []assessment
+		for _, s := range ss {
+			for _, g := range s.Grades {
+				a := assessment{g.Title, g.Type}
+				if (f.Type == "" || g.Type == f.Type) && !seen[a] {
+					seen[a] = true
+					assessments = append(assessments, a)
+				}
+			}
+		}
+		typeOrder := map[GradeType]int{GradeQuiz: 0, GradeTest: 1, GradeExam: 2}
+		sort.Slice(assessments, func(i, j int) bool {
+			if assessments[i].title != assessments[j].title {
+				return assessments[i].title < assessments[j].title
+			}
+			return typeOrder[assessments[i].typ] < typeOrder[assessments[j].typ]
+		})
+		result := make([]AssessmentStats, 0, len(assessments))
+		for _, a := range assessments {
+			tf := f
+			tf.Title, tf.Type = a.title, a.typ
+			result = append(result, AssessmentStats{Title: a.title, Type: a.typ, Stats: computeStats(tf.scores(ss, catalog), 0)})
+		}
+		return result
+	}, nil
+}
+
+func (ah *analyticsHandler) distribution(q url.Values) (func(Students, Catalog) interface{}, error) {
+	f, err := parseGradeFilter(q)
+	if err != nil {
+		return nil, err
+	}
+	buckets := defaultBuckets
+	if v := q.Get("buckets"); v != "" {
+		if buckets, err = strconv.Atoi(v); err != nil || buckets <= 0 || buckets > 100 {
+			return nil, &ValidationError{Fields: []FieldError{{Field: "buckets", Message: "must be an integer between 1 and 100"}}}
+		}
+	}
+	return func(ss Students, catalog Catalog) interface{} {
+		return computeStats(f.scores(ss, catalog), buckets)
+	}, nil
+}
+
+func (ah *analyticsHandler) types(q url.Values) (func(Students, Catalog) interface{}, error) {
+	f, err := parseGradeFilter(q)
+	if err != nil {
+		return nil, err
+	}
+	return func(ss Students, catalog Catalog) interface{} {
+		result := make(map[GradeType]Stats)
+		for _, t := range []GradeType{GradeQuiz, GradeTest, GradeExam} {
+			tf := f
+			tf.Type = t
+			result[t] = computeStats(tf.scores(ss, catalog), 0)
+		}
+		return result
+	}, nil
+}
+
+func (ah *analyticsHandler) below(q url.Values) (func(Students, Catalog) interface{}, error) {
+	threshold, err := strconv.ParseFloat(q.Get("threshold"), 64)
+	if err != nil {
+		return nil, &ValidationError{Fields: []FieldError{{Field: "threshold", Message: "must be a number"}}}
+	}
+	return func(ss Students, catalog Catalog) interface{} {
+		result := []StudentScore{}
+		for _, s := range ss {
+			summary := ah.policy.Summarize(s)
+			if summary.Letter != "" && summary.Score < threshold {
+				result = append(result, StudentScore{ID: s.ID, FirstName: s.FirstName, LastName: s.LastName, Summary: summary})
+			}
+		}
+		sort.Slice(result, func(i, j int) bool { return result[i].Summary.Score < result[j].Summary.Score })
+		return result
+	}, nil
+}
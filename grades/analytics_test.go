@@ -0,0 +1,55 @@
+package grades
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestAssessmentsSeparateTypes(t *testing.T) {
+	store := NewMemoryStore(Students{
+		{ID: 1, Grades: []Grade{{ID: 1, Title: "Week 1", Type: GradeQuiz, Score: 80}, {ID: 2, Title: "Week 1", Type: GradeTest, Score: 60}}},
+		{ID: 2, Grades: []Grade{{ID: 1, Title: "Week 1", Type: GradeQuiz, Score: 100}}},
+	})
+	mux := http.NewServeMux()
+	NewServer(store).RegisterHandlers(mux)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/analytics/assessments", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /analytics/assessments responded with %v: %s", w.Code, w.Body)
+	}
+	var got []AssessmentStats
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("assessments are %+v, want Week 1 as a quiz and as a test", got)
+	}
+	if got[0].Type != GradeQuiz || got[0].Count != 2 || got[0].Mean != 90 {
+		t.Fatalf("quiz stats are %+v, want 2 scores with mean 90", got[0])
+	}
+	if got[1].Type != GradeTest || got[1].Count != 1 || got[1].Mean != 60 {
+		t.Fatalf("test stats are %+v, want 1 score with mean 60", got[1])
+	}
+}
+
+func TestParseGradeFilterErrorOrder(t *testing.T) {
+	q := url.Values{"type": {"Essay"}, "course": {"x"}, "term": {"y"}}
+	for i := 0; i < 20; i++ {
+		_, err := parseGradeFilter(q)
+		var verr *ValidationError
+		if !errors.As(err, &verr) {
+			t.Fatalf("parseGradeFilter returned %v, want a validation error", err)
+		}
+		var fields []string
+		for _, f := range verr.Fields {
+			fields = append(fields, f.Field)
+		}
+		if len(fields) != 3 || fields[0] != "type" || fields[1] != "course" || fields[2] != "term" {
+			t.Fatalf("error fields are %v, want [type course term]", fields)
+		}
+	}
+}
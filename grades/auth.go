@@ -0,0 +1,359 @@
+package grades
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 认证和授权失败时返回的错误
+var (
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+)
+
+// Role 是调用者的角色
+type Role string
+
+const (
+	// RoleStudent 只能读取自己的记录
+	RoleStudent = Role("student")
+	// RoleTeacher 可以读取选修自己课程的学生在这些课程中的成绩，并修改这些成绩
+	RoleTeacher = Role("teacher")
+	// RoleAdmin 可以访问所有接口
+	RoleAdmin = Role("admin")
+)
+
+// Principal 是通过认证的调用者
+type Principal struct {
+	Name string `json:"name"`
+	Role Role   `json:"role"`
+	// StudentID 是学生角色对应的学生
+	StudentID int `json:"studentId,omitempty"`
+	// Courses 是教师角色负责的课程
+	Courses []int `json:"courses,omitempty"`
+}
+
+// teaches 判断教师是否负责课程 courseID
+func (p Principal) teaches(courseID int) bool {
+	for _, id := range p.Courses {
+		if id == courseID {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator 通过请求头 Authorization: Bearer <token> 认证调用者。
+// 令牌可以是预先配置的 API 令牌，也可以是用密钥签名、带有过期时间的会话令牌。
+type Authenticator struct {
+	mutex  sync.RWMutex
+	tokens map[string]Principal
+	secret []byte
+}
+
+// NewAuthenticator 创建一个认证器，secret 用于签发和校验会话令牌，为空时只接受 API 令牌
+func NewAuthenticator(secret []byte) *Authenticator {
+	return &Authenticator{tokens: make(map[string]Principal), secret: secret}
+}
+
+// AddToken 添加一个 API 令牌
+func (a *Authenticator) AddToken(token string, p Principal) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.tokens[token] = p
+}
+
+// LoadTokens 从 JSON 文件中读取 API 令牌，文件内容为令牌到调用者的映射，例如：
+//
+//	{"s3cr3t": {"name": "alice", "role": "teacher", "courses": [1]}}
+func (a *Authenticator) LoadTokens(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var tokens map[string]Principal
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for token, p := range tokens {
+		switch p.Role {
+		case RoleStudent, RoleTeacher, RoleAdmin:
+		default:
+			return fmt.Errorf("%s: unknown role %q for %s", path, p.Role, p.Name)
+		}
+		a.AddToken(token, p)
+	}
+	return nil
+}
+
+// sessionClaims 是会话令牌中签名的内容
+type sessionClaims struct {
+	Principal
+	Expires int64 `json:"exp"`
+}
+
+// IssueToken 为 p 签发一个在 ttl 后过期的会话令牌
+func (a *Authenticator) IssueToken(p Principal, ttl time.Duration) (string, error) {
+	if len(a.secret) == 0 {
+		return "", errors.New("no secret configured for session tokens")
+	}
+	payload, err := json.Marshal(sessionClaims{Principal: p, Expires: time.Now().Add(ttl).Unix()})
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + a.sign(encoded), nil
+}
+
+// sign 返回 payload 的签名
+func (a *Authenticator) sign(payload string) string {
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Authenticate 从请求中认证调用者
+func (a *Authenticator) Authenticate(r *http.Request) (Principal, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return Principal{}, fmt.Errorf("%w: missing bearer token", ErrUnauthorized)
+	}
+	a.mutex.RLock()
+	for known, p := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(known), []byte(token)) == 1 {
+			a.mutex.RUnlock()
+			return p, nil
+		}
+	}
+	a.mutex.RUnlock()
+	payload, signature, ok := strings.Cut(token, ".")
+	if !ok || len(a.secret) == 0 || !hmac.Equal([]byte(signature), []byte(a.sign(payload))) {
+		return Principal{}, fmt.Errorf("%w: invalid token", ErrUnauthorized)
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return Principal{}, fmt.Errorf("%w: invalid token", ErrUnauthorized)
+	}
+	var claims sessionClaims
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return Principal{}, fmt.Errorf("%w: invalid token", ErrUnauthorized)
+	}
+	if time.Now().Unix() >= claims.Expires {
+		return Principal{}, fmt.Errorf("%w: token expired", ErrUnauthorized)
+	}
+	return claims.Principal, nil
+}
+
+// Middleware 返回先认证调用者再调用 next 的处理器。调用者会被放入请求的 context 中，
+// 并作为修改的操作者记录在历史中。
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, err := a.Authenticate(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), p)))
+	})
+}
+
+// tokenRequest 是 POST /auth/token 的请求体
+type tokenRequest struct {
+	Principal
+	// TTL 是 "1h30m" 形式的有效期
+	TTL string `json:"ttl"`
+}
+
+// tokenHandler 处理 POST /auth/token，只有管理员可以签发会话令牌，ttl 默认为 12 小时
+func (a *Authenticator) tokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, errMethodNotAllowed)
+		return
+	}
+	if p, _ := PrincipalFrom(r.Context()); p.Role != RoleAdmin {
+		writeError(w, fmt.Errorf("%w: only admins can issue tokens", ErrForbidden))
+		return
+	}
+	var req tokenRequest
+	if err := decodeBody(r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+	var verr ValidationError
+	if req.Name == "" {
+		verr.add("name", "is required")
+	}
+	switch req.Role {
+	case RoleStudent, RoleTeacher, RoleAdmin:
+	default:
+		verr.add("role", "must be one of student, teacher, admin")
+	}
+	ttl := 12 * time.Hour
+	if req.TTL != "" {
+		d, err := time.ParseDuration(req.TTL)
+		if err != nil || d <= 0 {
+			verr.add("ttl", "must be a positive duration such as 1h30m")
+		}
+		ttl = d
+	}
+	if err := verr.err(); err != nil {
+		writeError(w, err)
+		return
+	}
+	token, err := a.IssueToken(req.Principal, ttl)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"token": token})
+}
+
+type principalKey struct{}
+
+// WithPrincipal 返回带有调用者的 ctx
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFrom 返回 ctx 中的调用者，没有启用认证时 ok 为 false
+func PrincipalFrom(ctx context.Context) (p Principal, ok bool) {
+	p, ok = ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+// requireRole 检查调用者是否是 roles 中的一种角色，没有启用认证时总是通过
+func requireRole(r *http.Request, roles ...Role) error {
+	p, ok := PrincipalFrom(r.Context())
+	if !ok {
+		return nil
+	}
+	for _, role := range roles {
+		if p.Role == role {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s %q cannot access %s %s", ErrForbidden, p.Role, p.Name, r.Method, r.URL.Path)
+}
+
+// studentAccess 检查调用者能否访问学生 studentID：学生只能读取自己，教师只能访问选修自己课程的学生
+func studentAccess(r *http.Request, catalog func() (Catalog, error), studentID int, write bool) error {
+	p, ok := PrincipalFrom(r.Context())
+	if !ok || p.Role == RoleAdmin {
+		return nil
+	}
+	forbidden := fmt.Errorf("%w: %s %q cannot access student %d", ErrForbidden, p.Role, p.Name, studentID)
+	switch p.Role {
+	case RoleStudent:
+		if write || p.StudentID != studentID {
+			return forbidden
+		}
+		return nil
+	case RoleTeacher:
+		c, err := catalog()
+		if err != nil {
+			return err
+		}
+		if len(c.teacherEnrollments(p, studentID)) == 0 {
+			return forbidden
+		}
+		return nil
+	}
+	return forbidden
+}
+
+// gradeAccess 检查调用者能否修改学生 studentID 的成绩 g：教师只能修改自己课程中的成绩
+func gradeAccess(r *http.Request, catalog func() (Catalog, error), studentID int, g Grade) error {
+	p, ok := PrincipalFrom(r.Context())
+	if !ok || p.Role == RoleAdmin {
+		return nil
+	}
+	if p.Role == RoleTeacher {
+		c, err := catalog()
+		if err != nil {
+			return err
+		}
+		for _, e := range c.teacherEnrollments(p, studentID) {
+			if e.ID == g.EnrollmentID {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%w: %s %q cannot change grades of student %d outside their courses", ErrForbidden, p.Role, p.Name, studentID)
+}
+
+// teacherStudents 返回选修教师 p 负责的课程的学生，每个学生只保留这些课程中的成绩
+func (c Catalog) teacherStudents(p Principal, students Students) Students {
+	result := Students{}
+	for _, s := range students {
+		if len(c.teacherEnrollments(p, s.ID)) > 0 {
+			result = append(result, c.teacherView(p, s))
+		}
+	}
+	return result
+}
+
+// teacherView 返回学生 s 中教师 p 可以看到的部分，即 p 负责的课程中的成绩
+func (c Catalog) teacherView(p Principal, s Student) Student {
+	enrollments := c.teacherEnrollmentIDs(p, s.ID)
+	grades := []Grade{}
+	for _, g := range s.Grades {
+		if enrollments[g.EnrollmentID] {
+			grades = append(grades, g)
+		}
+	}
+	s.Grades = grades
+	return s
+}
+
+// teacherEvents 返回学生 studentID 的事件中教师 p 可以看到的部分：
+// 只保留 p 负责的课程中的成绩事件，学生事件中的学生也只保留这些课程中的成绩
+func (c Catalog) teacherEvents(p Principal, studentID int, events []Event) []Event {
+	enrollments := c.teacherEnrollmentIDs(p, studentID)
+	result := []Event{}
+	for _, e := range events {
+		if e.New != nil && !enrollments[e.New.EnrollmentID] || e.Previous != nil && !enrollments[e.Previous.EnrollmentID] {
+			continue
+		}
+		if e.NewStudent != nil {
+			s := c.teacherView(p, *e.NewStudent)
+			e.NewStudent = &s
+		}
+		if e.PreviousStudent != nil {
+			s := c.teacherView(p, *e.PreviousStudent)
+			e.PreviousStudent = &s
+		}
+		result = append(result, e)
+	}
+	return result
+}
+
+// teacherEnrollmentIDs 返回学生 studentID 在教师 p 负责的课程中的选课记录的 ID
+func (c Catalog) teacherEnrollmentIDs(p Principal, studentID int) map[int]bool {
+	ids := make(map[int]bool)
+	for _, e := range c.teacherEnrollments(p, studentID) {
+		ids[e.ID] = true
+	}
+	return ids
+}
+
+// teacherEnrollments 返回学生 studentID 在教师 p 负责的课程中的选课记录
+func (c Catalog) teacherEnrollments(p Principal, studentID int) []Enrollment {
+	var result []Enrollment
+	for _, e := range c.StudentEnrollments(studentID) {
+		if section, err := c.Section(e.SectionID); err == nil && p.teaches(section.CourseID) {
+			result = append(result, e)
+		}
+	}
+	return result
+}
@@ -0,0 +1,224 @@
+package grades
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// authFixture 是启用认证的成绩服务：学生 1 选修课程 1，学生 2 选修课程 2
+type authFixture struct {
+	url         string
+	auth        *Authenticator
+	enrollments [3]int
+}
+
+func newAuthFixture(t *testing.T) authFixture {
+	t.Helper()
+	store := NewMemoryStore(testSeed())
+	term, err := store.CreateTerm(Term{Name: "2026 秋"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := authFixture{auth: NewAuthenticator([]byte("test secret"))}
+	for studentID := 1; studentID <= 2; studentID++ {
+		course, err := store.CreateCourse(Course{Code: fmt.Sprintf("CS10%d", studentID), Title: "Course"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		section, err := store.CreateSection(Section{CourseID: course.ID, TermID: term.ID, Name: "A"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		e, err := store.Enroll(Enrollment{StudentID: studentID, SectionID: section.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.enrollments[studentID] = e.ID
+	}
+	f.auth.AddToken("admin-token", Principal{Name: "root", Role: RoleAdmin})
+	f.auth.AddToken("teacher-token", Principal{Name: "grace", Role: RoleTeacher, Courses: []int{1}})
+	f.auth.AddToken("student-token", Principal{Name: "ada", Role: RoleStudent, StudentID: 1})
+	s := NewServer(store)
+	s.SetAuthenticator(f.auth)
+	mux := http.NewServeMux()
+	s.RegisterHandlers(mux)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	f.url = srv.URL
+	return f
+}
+
+// do 以 token 发出请求，body 不为 nil 时编码为 JSON 请求体，返回状态码并把响应解码到 out
+func (f authFixture) do(t *testing.T, method, path, token string, body, out interface{}) int {
+	t.Helper()
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req, err := http.NewRequest(method, f.url+path, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if out != nil && res.StatusCode < 300 {
+		if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+	return res.StatusCode
+}
+
+func TestAuthenticate(t *testing.T) {
+	f := newAuthFixture(t)
+	session, err := f.auth.IssueToken(Principal{Name: "ada", Role: RoleStudent, StudentID: 1}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := f.auth.IssueToken(Principal{Name: "ada", Role: RoleStudent, StudentID: 1}, -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 把载荷中的角色改为管理员，但保留原来的签名
+	payload, signature, _ := strings.Cut(session, ".")
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = bytes.Replace(data, []byte(`"student"`), []byte(`"admin"`), 1)
+	tampered := base64.RawURLEncoding.EncodeToString(data) + "." + signature
+	other, err := NewAuthenticator([]byte("other secret")).IssueToken(Principal{Name: "root", Role: RoleAdmin}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"api token", "student-token", http.StatusOK},
+		{"session token", session, http.StatusOK},
+		{"missing token", "", http.StatusUnauthorized},
+		{"unknown api token", "guess", http.StatusUnauthorized},
+		{"tampered payload", tampered, http.StatusUnauthorized},
+		{"tampered signature", payload + "." + strings.Repeat("A", len(signature)), http.StatusUnauthorized},
+		{"signed with another secret", other, http.StatusUnauthorized},
+		{"expired token", expired, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := f.do(t, http.MethodGet, "/students/1", tt.token, nil, nil); got != tt.want {
+				t.Fatalf("GET /students/1 = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthorizeRoles(t *testing.T) {
+	f := newAuthFixture(t)
+	tests := []struct {
+		method, path, token string
+		want                int
+	}{
+		{http.MethodGet, "/students/1", "student-token", http.StatusOK},
+		{http.MethodGet, "/students/2", "student-token", http.StatusForbidden},
+		{http.MethodGet, "/students/1/history", "student-token", http.StatusOK},
+		{http.MethodPost, "/students", "student-token", http.StatusForbidden},
+		{http.MethodPost, "/students", "teacher-token", http.StatusForbidden},
+		{http.MethodPost, "/students", "admin-token", http.StatusCreated},
+		{http.MethodDelete, "/students/2", "teacher-token", http.StatusForbidden},
+		{http.MethodPost, "/courses", "teacher-token", http.StatusForbidden},
+		{http.MethodGet, "/analytics/distribution", "student-token", http.StatusForbidden},
+		{http.MethodGet, "/subscriptions", "teacher-token", http.StatusForbidden},
+		{http.MethodGet, "/subscriptions", "admin-token", http.StatusOK},
+	}
+	for _, tt := range tests {
+		var body interface{}
+		if tt.method == http.MethodPost && tt.path == "/students" {
+			body = Student{FirstName: "Grace", LastName: "Hopper"}
+		}
+		if got := f.do(t, tt.method, tt.path, tt.token, body, nil); got != tt.want {
+			t.Errorf("%s %s as %s = %d, want %d", tt.method, tt.path, tt.token, got, tt.want)
+		}
+	}
+}
+
+func TestTeacherCourseScope(t *testing.T) {
+	f := newAuthFixture(t)
+	var students Students
+	if got := f.do(t, http.MethodGet, "/students", "teacher-token", nil, &students); got != http.StatusOK {
+		t.Fatalf("GET /students = %d", got)
+	}
+	if len(students) != 1 || students[0].ID != 1 {
+		t.Fatalf("teacher sees %+v, want only student 1", students)
+	}
+	// 学生 1 的 Quiz 1 不属于任何课程，教师看不到
+	if len(students[0].Grades) != 0 {
+		t.Fatalf("teacher sees grades outside their courses: %+v", students[0].Grades)
+	}
+	if got := f.do(t, http.MethodGet, "/students/2", "teacher-token", nil, nil); got != http.StatusForbidden {
+		t.Fatalf("GET /students/2 = %d, want 403", got)
+	}
+
+	grade := Grade{Title: "Midterm", Type: GradeExam, Score: 88, EnrollmentID: f.enrollments[1]}
+	if got := f.do(t, http.MethodPost, "/students/1/grades", "teacher-token", grade, nil); got != http.StatusCreated {
+		t.Fatalf("POST grade in own course = %d, want 201", got)
+	}
+	grade.EnrollmentID = 0
+	if got := f.do(t, http.MethodPost, "/students/1/grades", "teacher-token", grade, nil); got != http.StatusForbidden {
+		t.Fatalf("POST grade outside any course = %d, want 403", got)
+	}
+	grade.EnrollmentID = f.enrollments[2]
+	if got := f.do(t, http.MethodPost, "/students/2/grades", "teacher-token", grade, nil); got != http.StatusForbidden {
+		t.Fatalf("POST grade in another course = %d, want 403", got)
+	}
+	if got := f.do(t, http.MethodPut, "/students/1/grades/1", "teacher-token", Grade{Title: "Quiz 1", Type: GradeQuiz, Score: 100}, nil); got != http.StatusForbidden {
+		t.Fatalf("PUT grade outside their courses = %d, want 403", got)
+	}
+}
+
+func TestTokenEndpoint(t *testing.T) {
+	f := newAuthFixture(t)
+	req := map[string]interface{}{"name": "ada", "role": "student", "studentId": 1, "ttl": "1h"}
+	if got := f.do(t, http.MethodPost, "/auth/token", "teacher-token", req, nil); got != http.StatusForbidden {
+		t.Fatalf("teacher POST /auth/token = %d, want 403", got)
+	}
+	if got := f.do(t, http.MethodPost, "/auth/token", "", req, nil); got != http.StatusUnauthorized {
+		t.Fatalf("anonymous POST /auth/token = %d, want 401", got)
+	}
+	for _, ttl := range []string{"soon", "-1h"} {
+		bad := map[string]interface{}{"name": "ada", "role": "student", "ttl": ttl}
+		if got := f.do(t, http.MethodPost, "/auth/token", "admin-token", bad, nil); got != http.StatusUnprocessableEntity {
+			t.Fatalf("POST /auth/token with ttl %q = %d, want 422", ttl, got)
+		}
+	}
+
+	var issued struct{ Token string }
+	if got := f.do(t, http.MethodPost, "/auth/token", "admin-token", req, &issued); got != http.StatusOK {
+		t.Fatalf("admin POST /auth/token = %d, want 200", got)
+	}
+	var s Student
+	if got := f.do(t, http.MethodGet, "/students/1", issued.Token, nil, &s); got != http.StatusOK || s.ID != 1 {
+		t.Fatalf("GET /students/1 with issued token = %d %+v", got, s)
+	}
+	if got := f.do(t, http.MethodGet, "/students/2", issued.Token, nil, nil); got != http.StatusForbidden {
+		t.Fatalf("GET /students/2 with issued student token = %d, want 403", got)
+	}
+}
@@ -0,0 +1,227 @@
+package grades
+
+import (
+	"fmt"
+	"time"
+)
+
+// Term 是一个学期
+type Term struct {
+	ID    int
+	Name  string
+	Start time.Time
+	End   time.Time
+}
+
+// Course 是一门课程。Policy 不为空时，这门课程的总评按它计算，否则使用服务的默认评分策略。
+type Course struct {
+	ID     int
+	Code   string
+	Title  string
+	Policy *Policy `json:",omitempty"`
+}
+
+// Section 是某门课程在某个学期开设的一个教学班
+type Section struct {
+	ID         int
+	CourseID   int
+	TermID     int
+	Name       string
+	Instructor string
+}
+
+// Enrollment 表示学生选修了一个教学班，学生在这个教学班中的成绩通过 Grade.EnrollmentID 关联到选课记录
+type Enrollment struct {
+	ID        int
+	StudentID int
+	SectionID int
+}
+
+// Catalog 是课程目录：学期、课程、教学班和选课记录
+type Catalog struct {
+	Terms       []Term
+	Courses     []Course
+	Sections    []Section
+	Enrollments []Enrollment
+}
+
+// Term 返回 ID 为 id 的学期
+func (c *Catalog) Term(id int) (*Term, error) {
+	for i := range c.Terms {
+		if c.Terms[i].ID == id {
+			return &c.Terms[i], nil
+		}
+	}
+	return nil, fmt.Errorf("term with ID %d %w", id, ErrNotFound)
+}
+
+// Course 返回 ID 为 id 的课程
+func (c *Catalog) Course(id int) (*Course, error) {
+	for i := range c.Courses {
+		if c.Courses[i].ID == id {
+			return &c.Courses[i], nil
+		}
+	}
+	return nil, fmt.Errorf("course with ID %d %w", id, ErrNotFound)
+}
+
+// Section 返回 ID 为 id 的教学班
+func (c *Catalog) Section(id int) (*Section, error) {
+	for i := range c.Sections {
+		if c.Sections[i].ID == id {
+			return &c.Sections[i], nil
+		}
+	}
+	return nil, fmt.Errorf("section with ID %d %w", id, ErrNotFound)
+}
+
+// Enrollment 返回 ID 为 id 的选课记录
+func (c *Catalog) Enrollment(id int) (*Enrollment, error) {
+	for i := range c.Enrollments {
+		if c.Enrollments[i].ID == id {
+			return &c.Enrollments[i], nil
+		}
+	}
+	return nil, fmt.Errorf("enrollment with ID %d %w", id, ErrNotFound)
+}
+
+// StudentEnrollments 返回学生 studentID 的所有选课记录
+func (c Catalog) StudentEnrollments(studentID int) []Enrollment {
+	var result []Enrollment
+	for _, e := range c.Enrollments {
+		if e.StudentID == studentID {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+// Validate 检查学期的名称和起止时间
+func (t Term) Validate() error {
+	var verr ValidationError
+	if t.Name == "" {
+		verr.add("Name", "is required")
+	}
+	if !t.Start.IsZero() && !t.End.IsZero() && t.End.Before(t.Start) {
+		verr.add("End", "must not be before Start")
+	}
+	return verr.err()
+}
+
+// Validate 检查课程的代码、名称和评分策略
+func (c Course) Validate() error {
+	var verr ValidationError
+	if c.Code == "" {
+		verr.add("Code", "is required")
+	}
+	if c.Title == "" {
+		verr.add("Title", "is required")
+	}
+	if c.Policy != nil {
+		if err := c.Policy.Validate(); err != nil {
+			for _, f := range err.(*ValidationError).Fields {
+				verr.add("Policy."+f.Field, f.Message)
+			}
+		}
+	}
+	return verr.err()
+}
+
+// Validate 检查教学班的名称
+func (s Section) Validate() error {
+	var verr ValidationError
+	if s.Name == "" {
+		verr.add("Name", "is required")
+	}
+	return verr.err()
+}
+
+// clone 返回课程目录的深拷贝
+func (c Catalog) clone() Catalog {
+	return Catalog{
+		Terms:       append([]Term(nil), c.Terms...),
+		Courses:     append([]Course(nil), c.Courses...),
+		Sections:    append([]Section(nil), c.Sections...),
+		Enrollments: append([]Enrollment(nil), c.Enrollments...),
+	}
+}
+
+// CourseEnrollment 是学生选修的一门课程，包括教学班、学期、这门课程的成绩和按课程的评分策略计算的总评
+type CourseEnrollment struct {
+	Enrollment Enrollment
+	Course     Course
+	Section    Section
+	Term       Term
+	Grades     []Grade
+	Summary    Summary
+}
+
+// CourseStudent 是选修一门课程的学生，Student.Grades 只包含这门课程的成绩
+type CourseStudent struct {
+	Student    Student
+	Enrollment Enrollment
+	Summary    Summary
+}
+
+// policyFor 返回课程使用的评分策略
+func (c Course) policyFor(fallback Policy) Policy {
+	if c.Policy != nil {
+		return *c.Policy
+	}
+	return fallback
+}
+
+// gradesFor 返回学生属于选课记录 enrollmentID 的成绩
+func (s Student) gradesFor(enrollmentID int) []Grade {
+	grades := []Grade{}
+	for _, g := range s.Grades {
+		if g.EnrollmentID == enrollmentID {
+			grades = append(grades, g)
+		}
+	}
+	return grades
+}
+
+// studentCourses 返回学生选修的所有课程
+func (c Catalog) studentCourses(s Student, policy Policy) []CourseEnrollment {
+	result := []CourseEnrollment{}
+	for _, e := range c.StudentEnrollments(s.ID) {
+		ce := CourseEnrollment{Enrollment: e, Grades: s.gradesFor(e.ID)}
+		if section, err := c.Section(e.SectionID); err == nil {
+			ce.Section = *section
+		}
+		if course, err := c.Course(ce.Section.CourseID); err == nil {
+			ce.Course = *course
+		}
+		if term, err := c.Term(ce.Section.TermID); err == nil {
+			ce.Term = *term
+		}
+		ce.Summary = ce.Course.policyFor(policy).Summarize(Student{ID: s.ID, Grades: ce.Grades})
+		result = append(result, ce)
+	}
+	return result
+}
+
+// courseStudents 返回选修课程 courseID 的学生，termID 不为 0 时只返回该学期的学生
+func (c Catalog) courseStudents(courseID, termID int, students Students, policy Policy) []CourseStudent {
+	result := []CourseStudent{}
+	course, err := c.Course(courseID)
+	if err != nil {
+		return result
+	}
+	for _, e := range c.Enrollments {
+		section, err := c.Section(e.SectionID)
+		if err != nil || section.CourseID != courseID || (termID != 0 && section.TermID != termID) {
+			continue
+		}
+		student, err := students.GetByID(e.StudentID)
+		if err != nil {
+			continue
+		}
+		cs := CourseStudent{Student: student.clone(), Enrollment: e}
+		cs.Student.Grades = student.gradesFor(e.ID)
+		cs.Summary = course.policyFor(policy).Summarize(cs.Student)
+		result = append(result, cs)
+	}
+	return result
+}
@@ -0,0 +1,324 @@
+// Package client 是成绩服务的 Go 客户端。它通过注册中心找到成绩服务，
+// 一个实例不可用时换用另一个实例重试，并把成绩服务的错误响应转换为带类型的错误。
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-distributed/grades"
+	"go-distributed/registry"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ErrNoProviders 表示注册中心中没有可用的成绩服务实例
+var ErrNoProviders = errors.New("no grading service providers available")
+
+// Error 是成绩服务返回的错误响应。它可以用 errors.Is 与 grades 包中的 ErrNotFound、ErrConflict、
+// ErrPreconditionFailed、ErrUnauthorized 和 ErrForbidden 比较；校验失败时可以用 errors.As 得到 *grades.ValidationError。
+type Error struct {
+	Status  int                 `json:"-"`
+	Code    string              `json:"code"`
+	Message string              `json:"message"`
+	Fields  []grades.FieldError `json:"fields,omitempty"`
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("grading service responded with %v", e.Status)
+	}
+	return fmt.Sprintf("grading service responded with %v: %s", e.Status, e.Message)
+}
+
+// Unwrap 返回与状态码对应的 grades 包中的错误
+func (e *Error) Unwrap() error {
+	switch e.Status {
+	case http.StatusNotFound:
+		return grades.ErrNotFound
+	case http.StatusConflict:
+		return grades.ErrConflict
+	case http.StatusPreconditionFailed:
+		return grades.ErrPreconditionFailed
+	case http.StatusUnauthorized:
+		return grades.ErrUnauthorized
+	case http.StatusForbidden:
+		return grades.ErrForbidden
+	case http.StatusUnprocessableEntity:
+		return &grades.ValidationError{Fields: e.Fields}
+	}
+	return nil
+}
+
+// Config 用于配置客户端，零值通过默认的注册中心客户端发现成绩服务
+type Config struct {
+	// Registry 是用于发现成绩服务的注册中心客户端，为 nil 时使用 registry 包的默认客户端
+	Registry *registry.Client
+	// BaseURL 不为空时直接访问该地址，不再通过注册中心发现
+	BaseURL string
+	// Token 是启用认证的成绩服务的令牌
+	Token string
+	// HTTPClient 默认为超时 10 秒的 http.Client
+	HTTPClient *http.Client
+}
+
+// Client 是成绩服务的客户端，可以被多个协程同时使用。
+// 修改方法的操作者和原因取自 ctx，见 grades.WithChange；成绩服务启用认证时操作者以令牌为准。
+type Client struct {
+	cfg Config
+}
+
+// New 使用 cfg 创建客户端
+func New(cfg Config) *Client {
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Client{cfg: cfg}
+}
+
+// WithToken 返回使用令牌 token 的客户端副本，例如网页服务用当前登录用户的令牌访问成绩服务
+func (c *Client) WithToken(token string) *Client {
+	cfg := c.cfg
+	cfg.Token = token
+	return &Client{cfg: cfg}
+}
+
+// providers 返回本次请求依次尝试的成绩服务地址：先是注册中心随机给出的一个，然后是其余实例
+func (c *Client) providers() ([]string, error) {
+	if c.cfg.BaseURL != "" {
+		return []string{c.cfg.BaseURL}, nil
+	}
+	getProvider, getProviders := registry.GetProvider, registry.GetProviders
+	if c.cfg.Registry != nil {
+		getProvider, getProviders = c.cfg.Registry.GetProvider, c.cfg.Registry.GetProviders
+	}
+	first, err := getProvider(registry.GradingService)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNoProviders, err)
+	}
+	urls := []string{first}
+	others := getProviders(registry.GradingService)
+	rand.Shuffle(len(others), func(i, j int) { others[i], others[j] = others[j], others[i] })
+	for _, u := range others {
+		if u != first {
+			urls = append(urls, u)
+		}
+	}
+	return urls, nil
+}
+
+// request 描述一次对成绩服务的调用
+type request struct {
+	method string
+	path   string
+	query  url.Values
+	body   interface{}
+	// version 不为 0 时作为 If-Match 发送
+	version uint64
+}
+
+// do 发送请求，成功时把响应体解码到 result 中并返回响应头。
+// 无法连接某个实例，或者幂等的请求遇到网关错误时，换用下一个实例重试。
+func (c *Client) do(ctx context.Context, req request, result interface{}) (http.Header, error) {
+	var data []byte
+	if req.body != nil {
+		var err error
+		if data, err = json.Marshal(req.body); err != nil {
+			return nil, err
+		}
+	}
+	urls, err := c.providers()
+	if err != nil {
+		return nil, err
+	}
+	for _, base := range urls {
+		var header http.Header
+		var retry bool
+		header, retry, err = c.send(ctx, base, req, data, result)
+		if !retry || ctx.Err() != nil {
+			return header, err
+		}
+	}
+	return nil, err
+}
+
+// send 向 base 所在的实例发送一次请求，返回的 retry 表示可以换用另一个实例重试
+func (c *Client) send(ctx context.Context, base string, req request, data []byte, result interface{}) (http.Header, bool, error) {
+	u := base + req.path
+	if len(req.query) > 0 {
+		u += "?" + req.query.Encode()
+	}
+	var body io.Reader
+	if data != nil {
+		body = bytes.NewReader(data)
+	}
+	r, err := http.NewRequestWithContext(ctx, req.method, u, body)
+	if err != nil {
+		return nil, false, err
+	}
+	if data != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	if c.cfg.Token != "" {
+		r.Header.Set("Authorization", "Bearer "+c.cfg.Token)
+	}
+	change := grades.ChangeFrom(ctx)
+	if change.Actor != "" {
+		r.Header.Set(grades.ActorHeader, change.Actor)
+	}
+	if change.Reason != "" {
+		r.Header.Set(grades.ReasonHeader, change.Reason)
+	}
+	if req.version != 0 {
+		r.Header.Set("If-Match", `"`+strconv.FormatUint(req.version, 10)+`"`)
+	}
+	res, err := c.cfg.HTTPClient.Do(r)
+	if err != nil {
+		// 连接失败时请求没有到达服务，任何请求都可以重试；其他网络错误只重试幂等的请求
+		var opErr *net.OpError
+		return nil, (errors.As(err, &opErr) && opErr.Op == "dial") || idempotent(req.method), err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		e := &Error{Status: res.StatusCode}
+		json.NewDecoder(res.Body).Decode(e)
+		switch res.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return res.Header, idempotent(req.method), e
+		}
+		return res.Header, false, e
+	}
+	if result != nil && res.StatusCode != http.StatusNoContent {
+		if err := json.NewDecoder(res.Body).Decode(result); err != nil {
+			return res.Header, false, err
+		}
+	}
+	return res.Header, false, nil
+}
+
+// idempotent 判断重复发送 method 请求是否安全
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// StudentPage 是 ListStudents 返回的一页学生
+type StudentPage struct {
+	Students grades.Students
+	// Total 是满足条件的学生总数
+	Total int
+	// NextOffset 是下一页的 offset，没有下一页时为 0
+	NextOffset int
+}
+
+// StudentDetail 是 GetStudent 返回的学生及其按评分策略计算的总评
+type StudentDetail struct {
+	grades.Student
+	Summary grades.Summary
+}
+
+// ListStudents 按条件查询一页学生，q.Limit 为 0 时使用成绩服务默认的每页 50 个，之后的页从 NextOffset 开始
+func (c *Client) ListStudents(ctx context.Context, q grades.StudentQuery) (StudentPage, error) {
+	var page StudentPage
+	header, err := c.do(ctx, request{method: http.MethodGet, path: "/students", query: q.Values()}, &page.Students)
+	if err != nil {
+		return page, err
+	}
+	page.Total, _ = strconv.Atoi(header.Get("X-Total-Count"))
+	page.NextOffset, _ = strconv.Atoi(header.Get("X-Next-Offset"))
+	return page, nil
+}
+
+// GetStudent 返回 ID 为 id 的学生，其中的 Version 可以传给修改方法用于乐观并发控制
+func (c *Client) GetStudent(ctx context.Context, id int) (StudentDetail, error) {
+	var s StudentDetail
+	_, err := c.do(ctx, request{method: http.MethodGet, path: studentPath(id)}, &s)
+	return s, err
+}
+
+// CreateStudent 添加一个学生，返回带有 ID 的学生
+func (c *Client) CreateStudent(ctx context.Context, s grades.Student) (grades.Student, error) {
+	var created grades.Student
+	_, err := c.do(ctx, request{method: http.MethodPost, path: "/students", body: s}, &created)
+	return created, err
+}
+
+// UpdateStudent 替换学生 s.ID 的姓名，s.Grades 不为 nil 时同时替换全部成绩。
+// 学生在 s.Version 之后被修改过时返回的错误与 grades.ErrPreconditionFailed 相等。
+func (c *Client) UpdateStudent(ctx context.Context, s grades.Student) (grades.Student, error) {
+	var updated grades.Student
+	_, err := c.do(ctx, request{method: http.MethodPut, path: studentPath(s.ID), body: s, version: s.Version}, &updated)
+	return updated, err
+}
+
+// DeleteStudent 删除版本为 version 的学生 id
+func (c *Client) DeleteStudent(ctx context.Context, id int, version uint64) error {
+	_, err := c.do(ctx, request{method: http.MethodDelete, path: studentPath(id), version: version}, nil)
+	return err
+}
+
+// ListGrades 返回学生 id 的全部成绩
+func (c *Client) ListGrades(ctx context.Context, id int) ([]grades.Grade, error) {
+	var gs []grades.Grade
+	_, err := c.do(ctx, request{method: http.MethodGet, path: studentPath(id) + "/grades"}, &gs)
+	return gs, err
+}
+
+// GetGrade 返回学生 id 的成绩 gradeID
+func (c *Client) GetGrade(ctx context.Context, id, gradeID int) (grades.Grade, error) {
+	var g grades.Grade
+	_, err := c.do(ctx, request{method: http.MethodGet, path: gradePath(id, gradeID)}, &g)
+	return g, err
+}
+
+// AddGrade 为学生 id 添加一条成绩，返回带有 ID 的成绩
+func (c *Client) AddGrade(ctx context.Context, id int, g grades.Grade) (grades.Grade, error) {
+	var created grades.Grade
+	_, err := c.do(ctx, request{method: http.MethodPost, path: studentPath(id) + "/grades", body: g}, &created)
+	return created, err
+}
+
+// UpdateGrade 替换版本为 version 的学生 id 的成绩 g.ID
+func (c *Client) UpdateGrade(ctx context.Context, id int, version uint64, g grades.Grade) (grades.Grade, error) {
+	var updated grades.Grade
+	_, err := c.do(ctx, request{method: http.MethodPut, path: gradePath(id, g.ID), body: g, version: version}, &updated)
+	return updated, err
+}
+
+// DeleteGrade 删除版本为 version 的学生 id 的成绩 gradeID
+func (c *Client) DeleteGrade(ctx context.Context, id int, version uint64, gradeID int) error {
+	_, err := c.do(ctx, request{method: http.MethodDelete, path: gradePath(id, gradeID), version: version}, nil)
+	return err
+}
+
+// StudentCourses 返回学生 id 选修的课程，以及学生在每门课程中的成绩和总评
+func (c *Client) StudentCourses(ctx context.Context, id int) ([]grades.CourseEnrollment, error) {
+	var courses []grades.CourseEnrollment
+	_, err := c.do(ctx, request{method: http.MethodGet, path: studentPath(id) + "/courses"}, &courses)
+	return courses, err
+}
+
+// History 返回学生 id 的全部事件
+func (c *Client) History(ctx context.Context, id int) ([]grades.Event, error) {
+	var events []grades.Event
+	_, err := c.do(ctx, request{method: http.MethodGet, path: studentPath(id) + "/history"}, &events)
+	return events, err
+}
+
+func studentPath(id int) string {
+	return "/students/" + strconv.Itoa(id)
+}
+
+func gradePath(id, gradeID int) string {
+	return fmt.Sprintf("/students/%d/grades/%d", id, gradeID)
+}
@@ -0,0 +1,206 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"go-distributed/grades"
+	"go-distributed/registry"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// newGradingServer 启动一个成绩服务，返回它的地址和收到的请求数
+func newGradingServer(t *testing.T) (string, *int64) {
+	t.Helper()
+	s := grades.NewServer(grades.NewMemoryStore(grades.Students{{ID: 1, FirstName: "Ada", LastName: "Lovelace"}}))
+	mux := http.NewServeMux()
+	s.RegisterHandlers(mux)
+	var hits int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL, &hits
+}
+
+// deadURL 返回一个没有服务监听的地址，连接它会失败
+func deadURL(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "http://" + ln.Addr().String()
+	ln.Close()
+	return url
+}
+
+// discover 返回一个发现了成绩服务实例 urls 的注册中心客户端。
+// 假的注册中心在收到注册时把 urls 推送给注册的服务。
+func discover(t *testing.T, urls ...string) *registry.Client {
+	t.Helper()
+	reg := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var registration registry.Registration
+		if err := json.NewDecoder(r.Body).Decode(&registration); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var added []map[string]string
+		for _, u := range urls {
+			added = append(added, map[string]string{"Name": string(registry.GradingService), "URL": u})
+		}
+		data, _ := json.Marshal(map[string]interface{}{"Added": added})
+		res, err := http.Post(registration.ServiceUpdateURL, "application/json", bytes.NewReader(data))
+		if err != nil {
+			t.Error(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		res.Body.Close()
+	}))
+	t.Cleanup(reg.Close)
+	mux := http.NewServeMux()
+	consumer := httptest.NewServer(mux)
+	t.Cleanup(consumer.Close)
+	rc := registry.NewClient(reg.URL)
+	err := rc.RegisterService(mux, registry.Registration{
+		ServiceName:      "TestConsumer",
+		ServiceUrl:       consumer.URL,
+		RequiredServices: []registry.ServiceName{registry.GradingService},
+		ServiceUpdateURL: consumer.URL + "/services",
+		HeartBeatURL:     consumer.URL + "/heartbeat",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rc.GetProviders(registry.GradingService); len(got) != len(urls) {
+		t.Fatalf("discovered %v, want %v", got, urls)
+	}
+	return rc
+}
+
+func TestFailover(t *testing.T) {
+	live, hits := newGradingServer(t)
+	c := New(Config{Registry: discover(t, deadURL(t), live)})
+	ctx := context.Background()
+	// 注册中心随机给出第一个实例，多次调用几乎一定会先选中不可用的实例
+	const calls = 20
+	for i := 0; i < calls; i++ {
+		if _, err := c.GetStudent(ctx, 1); err != nil {
+			t.Fatalf("GET %d: %v", i, err)
+		}
+		// 连接失败时请求没有发出，不幂等的请求也可以换用另一个实例
+		if _, err := c.AddGrade(ctx, 1, grades.Grade{Title: "Quiz", Type: grades.GradeQuiz, Score: 80}); err != nil {
+			t.Fatalf("POST %d: %v", i, err)
+		}
+	}
+	if got := atomic.LoadInt64(hits); got != 2*calls {
+		t.Fatalf("live instance received %d requests, want %d", got, 2*calls)
+	}
+	gs, err := c.ListGrades(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gs) != calls {
+		t.Fatalf("student has %d grades, want %d", len(gs), calls)
+	}
+
+	if _, err := New(Config{Registry: discover(t, deadURL(t))}).GetStudent(ctx, 1); err == nil {
+		t.Fatal("GET with only a dead instance succeeded")
+	}
+}
+
+func TestRetryOnlyIdempotentAfterSend(t *testing.T) {
+	live, hits := newGradingServer(t)
+	// broken 读取请求之后断开连接，请求可能已经被处理
+	var brokenHits int64
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&brokenHits, 1)
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn.Close()
+	}))
+	t.Cleanup(broken.Close)
+	c := New(Config{Registry: discover(t, broken.URL, live)})
+	ctx := context.Background()
+
+	for i := 0; i < 20; i++ {
+		if _, err := c.GetStudent(ctx, 1); err != nil {
+			t.Fatalf("GET %d: %v", i, err)
+		}
+	}
+	var failed int
+	for i := 0; i < 20; i++ {
+		before, liveBefore := atomic.LoadInt64(&brokenHits), atomic.LoadInt64(hits)
+		_, err := c.AddGrade(ctx, 1, grades.Grade{Title: "Quiz", Type: grades.GradeQuiz, Score: 80})
+		if atomic.LoadInt64(&brokenHits) == before {
+			if err != nil {
+				t.Fatalf("POST %d to the live instance: %v", i, err)
+			}
+			continue
+		}
+		// 请求已经发出后失败，不幂等的请求不能再发给另一个实例
+		failed++
+		if err == nil {
+			t.Fatalf("POST %d succeeded after the broken instance dropped the connection", i)
+		}
+		if atomic.LoadInt64(hits) != liveBefore {
+			t.Fatalf("POST %d was retried on the live instance", i)
+		}
+	}
+	if failed == 0 {
+		t.Fatal("no POST was sent to the broken instance")
+	}
+}
+
+func TestErrors(t *testing.T) {
+	live, _ := newGradingServer(t)
+	c := New(Config{BaseURL: live})
+	ctx := context.Background()
+
+	_, err := c.GetStudent(ctx, 99)
+	var cerr *Error
+	if !errors.Is(err, grades.ErrNotFound) || !errors.As(err, &cerr) || cerr.Status != http.StatusNotFound {
+		t.Fatalf("GET a missing student: %v", err)
+	}
+
+	s, err := c.GetStudent(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale := s.Student
+	s.LastName = "King"
+	if _, err := c.UpdateStudent(ctx, s.Student); err != nil {
+		t.Fatal(err)
+	}
+	stale.LastName = "Byron"
+	_, err = c.UpdateStudent(ctx, stale)
+	if !errors.Is(err, grades.ErrPreconditionFailed) || !errors.As(err, &cerr) || cerr.Status != http.StatusPreconditionFailed {
+		t.Fatalf("PUT with a stale version: %v", err)
+	}
+	if errors.Is(err, grades.ErrNotFound) {
+		t.Fatalf("412 matches ErrNotFound: %v", err)
+	}
+
+	_, err = c.AddGrade(ctx, 1, grades.Grade{Title: "", Type: grades.GradeQuiz, Score: 200})
+	var verr *grades.ValidationError
+	if !errors.As(err, &verr) || !errors.As(err, &cerr) || cerr.Status != http.StatusUnprocessableEntity {
+		t.Fatalf("POST an invalid grade: %v", err)
+	}
+	fields := make(map[string]bool)
+	for _, f := range verr.Fields {
+		fields[f.Field] = true
+	}
+	if !fields["Title"] || !fields["Score"] {
+		t.Fatalf("validation error has fields %+v, want Title and Score", verr.Fields)
+	}
+}
@@ -0,0 +1,238 @@
+package grades
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// coursesHandler 处理课程目录相关的请求
+type coursesHandler struct {
+	store  Store
+	policy Policy
+}
+
+// ServeHTTP 按路径和方法分发请求：
+//
+//	GET, POST /terms
+//	GET, POST /courses
+//	GET       /courses/{id}
+//	GET, POST /courses/{id}/sections
+//	GET       /courses/{id}/students?term={termID}
+//
+// 启用认证时，修改课程目录需要管理员，查看选修课程的学生需要该课程的教师或管理员。
+func (ch coursesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	pathSegments := strings.Split(r.URL.Path, "/")
+	if r.Method != http.MethodGet {
+		if err := requireRole(r, RoleAdmin); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+	if pathSegments[1] == "terms" {
+		if len(pathSegments) != 2 {
+			writeError(w, errRouteNotFound)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			ch.getTerms(w, r)
+		case http.MethodPost:
+			ch.createTerm(w, r)
+		default:
+			writeError(w, errMethodNotAllowed)
+		}
+		return
+	}
+	var id int
+	var err error
+	if len(pathSegments) >= 3 {
+		if id, err = strconv.Atoi(pathSegments[2]); err != nil {
+			writeError(w, errRouteNotFound)
+			return
+		}
+	}
+	switch {
+	case len(pathSegments) == 2:
+		switch r.Method {
+		case http.MethodGet:
+			ch.getCourses(w, r)
+		case http.MethodPost:
+			ch.createCourse(w, r)
+		default:
+			writeError(w, errMethodNotAllowed)
+		}
+	case len(pathSegments) == 3:
+		switch r.Method {
+		case http.MethodGet:
+			ch.getCourse(w, r, id)
+		default:
+			writeError(w, errMethodNotAllowed)
+		}
+	case len(pathSegments) == 4 && pathSegments[3] == "sections":
+		switch r.Method {
+		case http.MethodGet:
+			ch.getSections(w, r, id)
+		case http.MethodPost:
+			ch.createSection(w, r, id)
+		default:
+			writeError(w, errMethodNotAllowed)
+		}
+	case len(pathSegments) == 4 && pathSegments[3] == "students":
+		switch r.Method {
+		case http.MethodGet:
+			ch.getStudents(w, r, id)
+		default:
+			writeError(w, errMethodNotAllowed)
+		}
+	default:
+		writeError(w, errRouteNotFound)
+	}
+}
+
+func (ch coursesHandler) getTerms(w http.ResponseWriter, r *http.Request) {
+	catalog, err := ch.store.Catalog()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	terms := catalog.Terms
+	if terms == nil {
+		terms = []Term{}
+	}
+	writeJSON(w, http.StatusOK, terms)
+}
+
+func (ch coursesHandler) createTerm(w http.ResponseWriter, r *http.Request) {
+	var t Term
+	if err := decodeBody(r, &t); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := t.Validate(); err != nil {
+		writeError(w, err)
+		return
+	}
+	t, err := ch.store.CreateTerm(t)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, t)
+}
+
+func (ch coursesHandler) getCourses(w http.ResponseWriter, r *http.Request) {
+	catalog, err := ch.store.Catalog()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	courses := catalog.Courses
+	if courses == nil {
+		courses = []Course{}
+	}
+	writeJSON(w, http.StatusOK, courses)
+}
+
+func (ch coursesHandler) createCourse(w http.ResponseWriter, r *http.Request) {
+	var c Course
+	if err := decodeBody(r, &c); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := c.Validate(); err != nil {
+		writeError(w, err)
+		return
+	}
+	c, err := ch.store.CreateCourse(c)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("/courses/%d", c.ID))
+	writeJSON(w, http.StatusCreated, c)
+}
+
+func (ch coursesHandler) getCourse(w http.ResponseWriter, r *http.Request, id int) {
+	catalog, err := ch.store.Catalog()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	course, err := catalog.Course(id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, course)
+}
+
+func (ch coursesHandler) getSections(w http.ResponseWriter, r *http.Request, id int) {
+	catalog, err := ch.store.Catalog()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if _, err := catalog.Course(id); err != nil {
+		writeError(w, err)
+		return
+	}
+	sections := []Section{}
+	for _, s := range catalog.Sections {
+		if s.CourseID == id {
+			sections = append(sections, s)
+		}
+	}
+	writeJSON(w, http.StatusOK, sections)
+}
+
+// createSection 处理 POST /courses/{id}/sections，请求体中需要指定 TermID
+func (ch coursesHandler) createSection(w http.ResponseWriter, r *http.Request, id int) {
+	var s Section
+	if err := decodeBody(r, &s); err != nil {
+		writeError(w, err)
+		return
+	}
+	s.CourseID = id
+	if err := s.Validate(); err != nil {
+		writeError(w, err)
+		return
+	}
+	s, err := ch.store.CreateSection(s)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, s)
+}
+
+func (ch coursesHandler) getStudents(w http.ResponseWriter, r *http.Request, id int) {
+	if p, ok := PrincipalFrom(r.Context()); ok && p.Role != RoleAdmin && !(p.Role == RoleTeacher && p.teaches(id)) {
+		writeError(w, fmt.Errorf("%w: %s %q does not teach course %d", ErrForbidden, p.Role, p.Name, id))
+		return
+	}
+	var termID int
+	if v := r.URL.Query().Get("term"); v != "" {
+		var err error
+		if termID, err = strconv.Atoi(v); err != nil {
+			writeError(w, &ValidationError{Fields: []FieldError{{Field: "term", Message: "must be a term ID"}}})
+			return
+		}
+	}
+	catalog, err := ch.store.Catalog()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if _, err := catalog.Course(id); err != nil {
+		writeError(w, err)
+		return
+	}
+	students, err := ch.store.List()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, catalog.courseStudents(id, termID, students, ch.policy))
+}
@@ -0,0 +1,161 @@
+package grades
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// FieldError 描述请求体中一个字段的校验错误
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError 表示请求体没有通过校验，Fields 列出所有出错的字段
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		msgs[i] = f.Field + ": " + f.Message
+	}
+	return "validation failed: " + strings.Join(msgs, "; ")
+}
+
+// add 记录一个字段错误
+func (e *ValidationError) add(field, message string) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: message})
+}
+
+// err 没有字段错误时返回 nil
+func (e *ValidationError) err() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+// errorBody 是出错时返回的 JSON 响应体
+type errorBody struct {
+	Code    string       `json:"code"`
+	Message string       `json:"message"`
+	Fields  []FieldError `json:"fields,omitempty"`
+}
+
+// errBadBody 表示请求体不是合法的 JSON
+var errBadBody = errors.New("malformed request body")
+
+// decodeBody 把请求体解析到 v，请求体必须是恰好一个 JSON 值，且不能包含未知的字段
+func decodeBody(r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		if err == io.EOF {
+			return fmt.Errorf("%w: empty body", errBadBody)
+		}
+		return fmt.Errorf("%w: %v", errBadBody, err)
+	}
+	if dec.More() {
+		return fmt.Errorf("%w: unexpected data after JSON value", errBadBody)
+	}
+	return nil
+}
+
+// 路由层面的错误
+var (
+	errRouteNotFound    = errors.New("no such resource")
+	errMethodNotAllowed = errors.New("method not allowed")
+)
+
+// writeError 把 err 转换为状态码和 JSON 错误响应，所有成绩服务的接口出错时都使用这个格式：
+//
+//	{"code": "invalid", "message": "validation failed", "fields": [{"field": "Score", "message": "..."}]}
+func writeError(w http.ResponseWriter, err error) {
+	status, body := http.StatusInternalServerError, errorBody{Code: "internal", Message: "internal error"}
+	var verr *ValidationError
+	switch {
+	case err == errRouteNotFound:
+		status, body = http.StatusNotFound, errorBody{Code: "not_found", Message: err.Error()}
+	case err == errMethodNotAllowed:
+		status, body = http.StatusMethodNotAllowed, errorBody{Code: "method_not_allowed", Message: err.Error()}
+	case errors.As(err, &verr):
+		status, body = http.StatusUnprocessableEntity, errorBody{Code: "invalid", Message: "validation failed", Fields: verr.Fields}
+	case errors.Is(err, errBadBody):
+		status, body = http.StatusBadRequest, errorBody{Code: "bad_request", Message: err.Error()}
+	case errors.Is(err, ErrNotFound):
+		status, body = http.StatusNotFound, errorBody{Code: "not_found", Message: err.Error()}
+	case errors.Is(err, ErrUnauthorized):
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		status, body = http.StatusUnauthorized, errorBody{Code: "unauthorized", Message: err.Error()}
+	case errors.Is(err, ErrForbidden):
+		status, body = http.StatusForbidden, errorBody{Code: "forbidden", Message: err.Error()}
+	case err == errPreconditionRequired:
+		status, body = http.StatusPreconditionRequired, errorBody{Code: "precondition_required", Message: err.Error()}
+	case errors.Is(err, ErrPreconditionFailed):
+		status, body = http.StatusPreconditionFailed, errorBody{Code: "precondition_failed", Message: err.Error()}
+	case errors.Is(err, ErrConflict):
+		status, body = http.StatusConflict, errorBody{Code: "conflict", Message: err.Error()}
+	default:
+		log.Println(err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// 成绩分数的取值范围
+const (
+	MinScore = 0
+	MaxScore = 100
+)
+
+// Valid 判断成绩类型是否为 GradeQuiz、GradeTest 或 GradeExam
+func (t GradeType) Valid() bool {
+	switch t {
+	case GradeQuiz, GradeTest, GradeExam:
+		return true
+	}
+	return false
+}
+
+// Validate 检查成绩的标题、类型和分数，返回的错误是 *ValidationError
+func (g Grade) Validate() error {
+	var verr ValidationError
+	g.validate(&verr, "")
+	return verr.err()
+}
+
+// validate 把成绩的字段错误记录到 verr，字段名加上前缀 prefix
+func (g Grade) validate(verr *ValidationError, prefix string) {
+	if strings.TrimSpace(g.Title) == "" {
+		verr.add(prefix+"Title", "is required")
+	}
+	if !g.Type.Valid() {
+		verr.add(prefix+"Type", fmt.Sprintf("must be one of %s, %s, %s", GradeQuiz, GradeTest, GradeExam))
+	}
+	if g.Score < MinScore || g.Score > MaxScore {
+		verr.add(prefix+"Score", fmt.Sprintf("must be between %d and %d", MinScore, MaxScore))
+	}
+}
+
+// Validate 检查学生的姓名和所有成绩，返回的错误是 *ValidationError
+func (s Student) Validate() error {
+	var verr ValidationError
+	if strings.TrimSpace(s.FirstName) == "" {
+		verr.add("FirstName", "is required")
+	}
+	if strings.TrimSpace(s.LastName) == "" {
+		verr.add("LastName", "is required")
+	}
+	for i, g := range s.Grades {
+		g.validate(&verr, fmt.Sprintf("Grades[%d].", i))
+	}
+	return verr.err()
+}
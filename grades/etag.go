@@ -0,0 +1,82 @@
+package grades
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ErrPreconditionFailed 表示学生的版本与 If-Match 中期望的版本不一致，即学生在读取之后被其他人修改过
+var ErrPreconditionFailed = errors.New("precondition failed")
+
+// errPreconditionRequired 表示修改请求没有携带 If-Match 请求头
+var errPreconditionRequired = errors.New("If-Match header is required")
+
+type versionKey struct{}
+
+// WithVersion 返回带有期望版本的 ctx。传给 Store 的修改方法后，学生的当前版本与 version 不一致时返回 ErrPreconditionFailed。
+func WithVersion(ctx context.Context, version uint64) context.Context {
+	return context.WithValue(ctx, versionKey{}, version)
+}
+
+// checkVersion 检查学生的版本是否与 ctx 中期望的版本一致，ctx 中没有期望的版本时总是通过
+func checkVersion(ctx context.Context, s Student) error {
+	version, ok := ctx.Value(versionKey{}).(uint64)
+	if !ok || version == s.Version {
+		return nil
+	}
+	return fmt.Errorf("student %d is at version %d, not %d: %w", s.ID, s.Version, version, ErrPreconditionFailed)
+}
+
+// ETag 返回学生当前版本的实体标签
+func ETag(s Student) string {
+	return `"` + strconv.FormatUint(s.Version, 10) + `"`
+}
+
+// parseETag 从实体标签中解析版本，弱标签与强标签等价
+func parseETag(tag string) (uint64, bool) {
+	tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return 0, false
+	}
+	version, err := strconv.ParseUint(tag[1:len(tag)-1], 10, 64)
+	return version, err == nil
+}
+
+// notModified 判断 If-None-Match 中是否有学生当前的实体标签
+func notModified(r *http.Request, s Student) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+	for _, tag := range strings.Split(header, ",") {
+		if strings.TrimSpace(tag) == "*" {
+			return true
+		}
+		if version, ok := parseETag(tag); ok && version == s.Version {
+			return true
+		}
+	}
+	return false
+}
+
+// conditionalContext 返回带有 If-Match 中期望版本的修改 context。required 为 true 时请求必须携带 If-Match，
+// If-Match 为 * 时不检查版本。
+func conditionalContext(r *http.Request, required bool) (context.Context, error) {
+	ctx := requestContext(r)
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	switch {
+	case header == "" && required:
+		return nil, errPreconditionRequired
+	case header == "" || header == "*":
+		return ctx, nil
+	}
+	version, ok := parseETag(header)
+	if !ok {
+		return nil, &ValidationError{Fields: []FieldError{{Field: "If-Match", Message: "must be a single entity tag"}}}
+	}
+	return WithVersion(ctx, version), nil
+}
@@ -0,0 +1,115 @@
+package grades
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newETagServer 返回不启用认证的成绩服务
+func newETagServer(t *testing.T) string {
+	t.Helper()
+	s := NewServer(NewMemoryStore(testSeed()))
+	mux := http.NewServeMux()
+	s.RegisterHandlers(mux)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+// request 发出带有请求头 header 的请求，返回状态码和 ETag 响应头
+func request(t *testing.T, method, url string, header map[string]string, body string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	return res.StatusCode, res.Header.Get("ETag")
+}
+
+func TestIfMatch(t *testing.T) {
+	url := newETagServer(t)
+	status, etag := request(t, http.MethodGet, url+"/students/1", nil, "")
+	if status != http.StatusOK || etag == "" {
+		t.Fatalf("GET /students/1 = %d with ETag %q", status, etag)
+	}
+	patch := `{"FirstName": "Augusta"}`
+	tests := []struct {
+		name    string
+		ifMatch string
+		want    int
+	}{
+		{"missing", "", http.StatusPreconditionRequired},
+		{"stale", `"99"`, http.StatusPreconditionFailed},
+		{"malformed", `"1", "2"`, http.StatusUnprocessableEntity},
+		{"current", etag, http.StatusOK},
+		// 上一次修改之后 etag 已经过期
+		{"stale after update", etag, http.StatusPreconditionFailed},
+		{"weak tag of another version", "W/" + etag, http.StatusPreconditionFailed},
+		{"any", "*", http.StatusOK},
+	}
+	for _, tt := range tests {
+		header := map[string]string{}
+		if tt.ifMatch != "" {
+			header["If-Match"] = tt.ifMatch
+		}
+		if got, _ := request(t, http.MethodPatch, url+"/students/1", header, patch); got != tt.want {
+			t.Errorf("%s: PATCH with If-Match %q = %d, want %d", tt.name, tt.ifMatch, got, tt.want)
+		}
+	}
+
+	// 添加成绩不要求 If-Match，但携带时同样检查版本
+	grade := `{"Title": "Quiz 2", "Type": "Quiz", "Score": 80}`
+	if got, _ := request(t, http.MethodPost, url+"/students/1/grades", nil, grade); got != http.StatusCreated {
+		t.Fatalf("POST grade without If-Match = %d, want 201", got)
+	}
+	if got, _ := request(t, http.MethodPost, url+"/students/1/grades", map[string]string{"If-Match": etag}, grade); got != http.StatusPreconditionFailed {
+		t.Fatalf("POST grade with stale If-Match = %d, want 412", got)
+	}
+	if got, _ := request(t, http.MethodDelete, url+"/students/1/grades/1", nil, ""); got != http.StatusPreconditionRequired {
+		t.Fatalf("DELETE grade without If-Match = %d, want 428", got)
+	}
+	_, etag = request(t, http.MethodGet, url+"/students/1", nil, "")
+	if got, _ := request(t, http.MethodDelete, url+"/students/1/grades/1", map[string]string{"If-Match": etag}, ""); got != http.StatusNoContent {
+		t.Fatalf("DELETE grade with current If-Match = %d, want 204", got)
+	}
+}
+
+func TestIfNoneMatch(t *testing.T) {
+	url := newETagServer(t)
+	_, etag := request(t, http.MethodGet, url+"/students/1", nil, "")
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		want        int
+	}{
+		{"current", etag, http.StatusNotModified},
+		{"weak current", "W/" + etag, http.StatusNotModified},
+		{"any", "*", http.StatusNotModified},
+		{"list containing current", `"98", ` + etag + `, "99"`, http.StatusNotModified},
+		{"list without current", `"98", "99"`, http.StatusOK},
+		{"stale", `"99"`, http.StatusOK},
+		{"malformed", "current", http.StatusOK},
+	}
+	for _, tt := range tests {
+		header := map[string]string{"If-None-Match": tt.ifNoneMatch}
+		for _, path := range []string{"/students/1", "/students/1/grades/1"} {
+			got, gotTag := request(t, http.MethodGet, url+path, header, "")
+			if got != tt.want {
+				t.Errorf("%s: GET %s with If-None-Match %q = %d, want %d", tt.name, path, tt.ifNoneMatch, got, tt.want)
+			}
+			if gotTag != etag {
+				t.Errorf("%s: GET %s returned ETag %q, want %q", tt.name, path, gotTag, etag)
+			}
+		}
+	}
+}
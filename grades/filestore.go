@@ -0,0 +1,196 @@
+package grades
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// defaultCompactSize 是文件存储的日志在快照之后至少追加多少字节才会被压缩
+const defaultCompactSize = 4 << 20
+
+// FileStore 是持久化到文件的 Store。每次修改都以一行 JSON 追加到日志文件并 fsync，
+// 启动时重放日志恢复数据；快照之后追加的内容超过快照本身的大小（至少 compactSize）时，日志被压缩为一个新的快照。
+type FileStore struct {
+	*MemoryStore
+	path string
+	file *os.File
+	size int64
+	// snapshotSize 是日志开头的快照的大小
+	snapshotSize int64
+	compactSize  int64
+}
+
+// OpenFileStore 打开 path 处的文件存储。文件不存在时用 seed 初始化。
+func OpenFileStore(path string, seed Students) (*FileStore, error) {
+	fs := &FileStore{MemoryStore: &MemoryStore{}, path: path, compactSize: defaultCompactSize}
+	exists, err := fs.load()
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		fs.data.apply(seedMutation(seed))
+	}
+	if !exists || fs.needsCompaction() {
+		if err := fs.compact(fs.data); err != nil {
+			return nil, err
+		}
+	} else {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, err
+		}
+		fs.file = f
+	}
+	fs.persist = fs.append
+	return fs, nil
+}
+
+// load 重放日志文件，返回文件是否存在。崩溃时写了一半的最后一行会被截掉，
+// 其他无法解析的行表示文件已损坏，返回错误。
+func (fs *FileStore) load() (bool, error) {
+	f, err := os.Open(fs.path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 256*1024*1024)
+	line := 0
+	// torn 是无法解析的那一行的行号，只有它是最后一行时才能忽略
+	torn := 0
+	var tornErr error
+	for scanner.Scan() {
+		if torn != 0 {
+			return true, fmt.Errorf("%s:%d: %w", fs.path, torn, tornErr)
+		}
+		line++
+		var m mutation
+		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			torn, tornErr = line, err
+			continue
+		}
+		if line == 1 && m.Op == opSnapshot {
+			fs.snapshotSize = int64(len(scanner.Bytes()) + 1)
+		}
+		fs.size += int64(len(scanner.Bytes()) + 1)
+		if err := fs.data.apply(m); err != nil {
+			return true, fmt.Errorf("%s:%d: %w", fs.path, line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return true, err
+	}
+	if torn != 0 {
+		// 截掉写了一半的行，之后追加的修改从新的一行开始
+		return true, os.Truncate(fs.path, fs.size)
+	}
+	return true, nil
+}
+
+// needsCompaction 判断快照之后追加的内容是否已经多到需要压缩。
+// 压缩的代价与快照大小成正比，按快照大小的比例触发，写入的平均代价不会随历史增长。
+func (fs *FileStore) needsCompaction() bool {
+	threshold := fs.snapshotSize
+	if threshold < fs.compactSize {
+		threshold = fs.compactSize
+	}
+	return fs.size-fs.snapshotSize >= threshold
+}
+
+// append 把修改追加到日志文件并 fsync。快照之后追加的内容已经足够多时，先把 current 压缩为快照。
+// 写入失败时截掉可能写了一半的行；连截断也失败时关闭存储，之后的修改都会失败，
+// 以免新的修改接在损坏的行后面使日志无法再被打开。调用者需要持有 fs.mutex。
+func (fs *FileStore) append(current dataset, m mutation) error {
+	if fs.file == nil {
+		return fmt.Errorf("file store %s is closed", fs.path)
+	}
+	if fs.needsCompaction() {
+		if err := fs.compact(current); err != nil {
+			return err
+		}
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = fs.file.Write(append(data, '\n'))
+	if err == nil {
+		err = fs.file.Sync()
+	}
+	if err != nil {
+		if terr := fs.file.Truncate(fs.size); terr != nil {
+			fs.file.Close()
+			fs.file = nil
+			return fmt.Errorf("%v; truncate %s: %v", err, fs.path, terr)
+		}
+		return err
+	}
+	fs.size += int64(len(data) + 1)
+	return nil
+}
+
+// compact 把 d 写成一个快照，替换原来的日志文件
+func (fs *FileStore) compact(d dataset) error {
+	data, err := json.Marshal(mutation{Op: opSnapshot, Students: d.Students, Catalog: &d.Catalog, Events: d.Events,
+		LastStudentID: d.LastStudentID, LastGradeIDs: d.LastGradeIDs})
+	if err != nil {
+		return err
+	}
+	tmp := fs.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(data, '\n'))
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, fs.path); err != nil {
+		f.Close()
+		return err
+	}
+	if fs.file != nil {
+		fs.file.Close()
+	}
+	fs.file = f
+	fs.size = int64(len(data) + 1)
+	fs.snapshotSize = fs.size
+	// 同步目录，重命名在崩溃后也能保留
+	return syncDir(filepath.Dir(fs.path))
+}
+
+// syncDir 把目录 dir 的元数据同步到磁盘
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if closeErr := d.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Close 关闭日志文件，之后的修改都会失败
+func (fs *FileStore) Close() error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	if fs.file == nil {
+		return nil
+	}
+	err := fs.file.Close()
+	fs.file = nil
+	return err
+}
@@ -0,0 +1,41 @@
+package grades
+
+import (
+	_ "embed"
+	"encoding/json"
+	"os"
+)
+
+// defaultFixtures 是内置的示例学生数据
+//
+//go:embed fixtures/students.json
+var defaultFixtures []byte
+
+// MockStudents 返回一份新的内置示例学生数据
+func MockStudents() Students {
+	ss, err := parseFixtures(defaultFixtures)
+	if err != nil {
+		// 内置的数据在编译时就已确定，解析失败说明文件本身有误
+		panic(err)
+	}
+	return ss
+}
+
+// LoadFixtures 从 JSON 文件中读取学生数据，格式与 GET /students 的响应相同
+func LoadFixtures(path string) (Students, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseFixtures(data)
+}
+
+// parseFixtures 解析 JSON 格式的学生数据
+func parseFixtures(data []byte) (Students, error) {
+	var ss Students
+	if err := json.Unmarshal(data, &ss); err != nil {
+		return nil, err
+	}
+	ss.assignGradeIDs()
+	return ss, nil
+}
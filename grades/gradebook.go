@@ -0,0 +1,569 @@
+package grades
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxImportSize 是导入的 CSV 文件的大小上限
+const maxImportSize = 10 << 20
+
+// RowError 是导入时某一行的错误，Row 从 1 开始计数，第 1 行是表头
+type RowError struct {
+	Row     int    `json:"row"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+// ImportResult 是一次导入的结果。有任何行出错时不会修改任何数据。
+type ImportResult struct {
+	DryRun          bool       `json:"dryRun"`
+	Rows            int        `json:"rows"`
+	StudentsCreated int        `json:"studentsCreated"`
+	StudentsUpdated int        `json:"studentsUpdated"`
+	GradesAdded     int        `json:"gradesAdded"`
+	GradesUpdated   int        `json:"gradesUpdated"`
+	GradesUnchanged int        `json:"gradesUnchanged"`
+	Errors          []RowError `json:"errors,omitempty"`
+}
+
+// importPlan 是导入对一个学生的修改
+type importPlan struct {
+	student     Student
+	isNew       bool
+	nameChanged bool
+	added       []Grade
+	updated     []Grade
+}
+
+// importRow 是从 CSV 的一行中解析出的学生和成绩
+type importRow struct {
+	row       int
+	id        string
+	firstName string
+	lastName  string
+	grades    []Grade
+	// byEnrollment 为 true 表示 CSV 有 EnrollmentID 列，按 Title 和 EnrollmentID 匹配已有成绩；
+	// 否则按 Title 和 Type 匹配，更新时保留成绩原来关联的选课记录
+	byEnrollment bool
+}
+
+// Import 从 CSV 中导入学生名单和成绩，支持两种格式：
+//
+//   - 每行一条成绩：表头包含 Title 列，以及 ID、FirstName、LastName、Type、Score、EnrollmentID 列；
+//     没有 Title 的行只导入学生。
+//   - 成绩册：表头为 ID、FirstName、LastName 和若干 "Title (Type)" 形式的考核列，即 GET /gradebook 导出的格式，
+//     Average、Letter 等计算出的列会被忽略。
+//
+// 有 ID 的行更新该学生，否则按姓名匹配已有学生，找不到时新建学生。同一学生相同 Title 和 EnrollmentID 的成绩会被更新，
+// 没有 EnrollmentID 列时（例如导出的成绩册）按 Title 和 Type 匹配；匹配不到时添加新成绩。
+// dryRun 为 true 时只返回将要进行的修改。有任何行出错时不修改数据，所有修改通过 Store.Apply 一次完成。
+// 修改记录的事件使用 ctx 中的操作者和原因。
+func Import(ctx context.Context, store Store, r io.Reader, dryRun bool) (ImportResult, error) {
+	result := ImportResult{DryRun: dryRun}
+	rows, rowErrs, err := parseImport(r)
+	if err != nil {
+		return result, err
+	}
+	result.Rows = len(rows)
+	result.Errors = rowErrs
+	students, err := store.List()
+	if err != nil {
+		return result, err
+	}
+	catalog, err := store.Catalog()
+	if err != nil {
+		return result, err
+	}
+	plans := make(map[int]*importPlan)
+	var order []*importPlan
+	for _, row := range rows {
+		p, err := resolveStudent(row, students, plans, &order)
+		if err != nil {
+			result.Errors = append(result.Errors, *err)
+			continue
+		}
+		if !p.isNew && (row.firstName != "" && row.firstName != p.student.FirstName ||
+			row.lastName != "" && row.lastName != p.student.LastName) {
+			if row.firstName != "" {
+				p.student.FirstName = row.firstName
+			}
+			if row.lastName != "" {
+				p.student.LastName = row.lastName
+			}
+			p.nameChanged = true
+		}
+		if err := checkImportEnrollments(row, p, catalog); err != nil {
+			result.Errors = append(result.Errors, *err)
+			continue
+		}
+		for _, g := range row.grades {
+			p.upsert(g, row.byEnrollment, &result)
+		}
+	}
+	sort.Slice(result.Errors, func(i, j int) bool { return result.Errors[i].Row < result.Errors[j].Row })
+	for _, p := range order {
+		if p.isNew {
+			result.StudentsCreated++
+		} else if p.nameChanged {
+			result.StudentsUpdated++
+		}
+	}
+	if dryRun || len(result.Errors) > 0 {
+		return result, nil
+	}
+	var b Batch
+	for _, p := range order {
+		p.apply(&b)
+	}
+	return result, store.Apply(ctx, &b)
+}
+
+// checkImportEnrollments 检查行中成绩关联的选课记录都属于学生，新建的学生还没有选课记录
+func checkImportEnrollments(row importRow, p *importPlan, catalog Catalog) *RowError {
+	for _, g := range row.grades {
+		if g.EnrollmentID == 0 {
+			continue
+		}
+		if e, err := catalog.Enrollment(g.EnrollmentID); err != nil || p.isNew || e.StudentID != p.student.ID {
+			return &RowError{Row: row.row, Field: "EnrollmentID", Message: "no such enrollment for this student"}
+		}
+	}
+	return nil
+}
+
+// resolveStudent 找到或新建行 row 对应的学生
+func resolveStudent(row importRow, students Students, plans map[int]*importPlan, order *[]*importPlan) (*importPlan, *RowError) {
+	if row.id != "" {
+		id, err := strconv.Atoi(row.id)
+		if err != nil {
+			return nil, &RowError{Row: row.row, Field: "ID", Message: "must be an integer"}
+		}
+		if p, ok := plans[id]; ok {
+			return p, nil
+		}
+		s, err := students.GetByID(id)
+		if err != nil {
+			return nil, &RowError{Row: row.row, Field: "ID", Message: "no student with this ID"}
+		}
+		p := &importPlan{student: s.clone()}
+		plans[id] = p
+		*order = append(*order, p)
+		return p, nil
+	}
+	if row.firstName == "" || row.lastName == "" {
+		return nil, &RowError{Row: row.row, Message: "either ID or both FirstName and LastName are required"}
+	}
+	// 新建的学生还没有 ID，用负数暂时标识
+	for _, p := range *order {
+		if p.isNew && sameName(p.student, row) {
+			return p, nil
+		}
+	}
+	var match *Student
+	for i := range students {
+		if sameName(students[i], row) {
+			if match != nil {
+				return nil, &RowError{Row: row.row, Message: "more than one student with this name, use ID"}
+			}
+			match = &students[i]
+		}
+	}
+	if match == nil {
+		p := &importPlan{student: Student{ID: -len(*order) - 1, FirstName: row.firstName, LastName: row.lastName}, isNew: true}
+		plans[p.student.ID] = p
+		*order = append(*order, p)
+		return p, nil
+	}
+	if p, ok := plans[match.ID]; ok {
+		return p, nil
+	}
+	p := &importPlan{student: match.clone()}
+	plans[match.ID] = p
+	*order = append(*order, p)
+	return p, nil
+}
+
+// sameName 判断学生的姓名是否与行中的姓名相同，不区分大小写
+func sameName(s Student, row importRow) bool {
+	return strings.EqualFold(s.FirstName, row.firstName) && strings.EqualFold(s.LastName, row.lastName)
+}
+
+// sameAssessment 判断导入的成绩 g 与已有的成绩 existing 是否是同一项考核，byEnrollment 见 importRow
+func sameAssessment(existing, g Grade, byEnrollment bool) bool {
+	if byEnrollment {
+		return existing.Title == g.Title && existing.EnrollmentID == g.EnrollmentID
+	}
+	return existing.Title == g.Title && existing.Type == g.Type
+}
+
+// upsert 把成绩 g 合并到计划中
+func (p *importPlan) upsert(g Grade, byEnrollment bool, result *ImportResult) {
+	for i, existing := range p.student.Grades {
+		if !sameAssessment(existing, g, byEnrollment) {
+			continue
+		}
+		if !byEnrollment {
+			g.EnrollmentID = existing.EnrollmentID
+		}
+		if existing.Type == g.Type && existing.Score == g.Score {
+			result.GradesUnchanged++
+			return
+		}
+		g.ID = existing.ID
+		p.student.Grades[i] = g
+		if g.ID == 0 {
+			// 成绩是本次导入中前面的行添加的，直接替换要添加的成绩
+			for j, added := range p.added {
+				if sameAssessment(added, g, byEnrollment) {
+					p.added[j] = g
+				}
+			}
+			return
+		}
+		// 成绩已经被本次导入中前面的行更新过，只保留最后一次更新
+		for j := range p.updated {
+			if p.updated[j].ID == g.ID {
+				p.updated[j] = g
+				return
+			}
+		}
+		result.GradesUpdated++
+		p.updated = append(p.updated, g)
+		return
+	}
+	result.GradesAdded++
+	p.student.Grades = append(p.student.Grades, g)
+	if !p.isNew {
+		p.added = append(p.added, g)
+	}
+}
+
+// apply 把计划中的修改加入 b
+func (p *importPlan) apply(b *Batch) {
+	if p.isNew {
+		s := p.student
+		s.ID = 0
+		b.CreateStudent(s)
+		return
+	}
+	if p.nameChanged {
+		b.ReplaceStudent(Student{ID: p.student.ID, FirstName: p.student.FirstName, LastName: p.student.LastName})
+	}
+	for _, g := range p.updated {
+		b.ReplaceGrade(p.student.ID, g)
+	}
+	for _, g := range p.added {
+		b.AddGrade(p.student.ID, g)
+	}
+}
+
+// 成绩册中由计算得出、导入时忽略的列
+var computedColumns = map[string]bool{"average": true, "letter": true, "gpa": true}
+
+// parseImport 解析 CSV，返回所有合法的行和出错的行。表头不合法时返回错误。
+func parseImport(r io.Reader) ([]importRow, []RowError, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, nil, &ValidationError{Fields: []FieldError{{Field: "body", Message: "empty CSV"}}}
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", errBadBody, err)
+	}
+	columns := make(map[string]int)
+	assessments := make(map[int]Grade)
+	var verr ValidationError
+	for i, name := range header {
+		key := strings.ToLower(strings.TrimSpace(name))
+		switch key {
+		case "id", "studentid":
+			columns["id"] = i
+		case "firstname", "lastname", "title", "type", "score", "enrollmentid":
+			columns[key] = i
+		default:
+			if computedColumns[key] {
+				continue
+			}
+			title, typ, ok := parseAssessmentColumn(name)
+			if !ok {
+				verr.add(fmt.Sprintf("header[%d]", i), fmt.Sprintf("unknown column %q, want \"Title (Type)\"", name))
+				continue
+			}
+			assessments[i] = Grade{Title: title, Type: typ}
+		}
+	}
+	_, long := columns["title"]
+	_, byEnrollment := columns["enrollmentid"]
+	if long && len(assessments) > 0 {
+		verr.add("header", "assessment columns cannot be combined with a Title column")
+	}
+	if err := verr.err(); err != nil {
+		return nil, nil, err
+	}
+	cell := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+	var rows []importRow
+	var rowErrs []RowError
+	for line := 2; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		var perr *csv.ParseError
+		if errors.As(err, &perr) {
+			rowErrs = append(rowErrs, RowError{Row: line, Message: perr.Err.Error()})
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		row := importRow{row: line, id: cell(record, "id"), firstName: cell(record, "firstname"), lastName: cell(record, "lastname"), byEnrollment: byEnrollment}
+		var errs []RowError
+		// field 是分数所在的列，用于报告错误
+		parseGrade := func(g Grade, field, score, enrollment string) {
+			f, err := strconv.ParseFloat(score, 32)
+			if err != nil {
+				errs = append(errs, RowError{Row: line, Field: field, Message: "score must be a number"})
+				return
+			}
+			g.Score = float32(f)
+			if enrollment != "" {
+				if g.EnrollmentID, err = strconv.Atoi(enrollment); err != nil {
+					errs = append(errs, RowError{Row: line, Field: "EnrollmentID", Message: "must be an integer"})
+					return
+				}
+			}
+			if err := g.Validate(); err != nil {
+				for _, f := range err.(*ValidationError).Fields {
+					errs = append(errs, RowError{Row: line, Field: f.Field, Message: f.Message})
+				}
+				return
+			}
+			row.grades = append(row.grades, g)
+		}
+		if long {
+			if title := cell(record, "title"); title != "" {
+				parseGrade(Grade{Title: title, Type: GradeType(cell(record, "type"))}, "Score", cell(record, "score"), cell(record, "enrollmentid"))
+			}
+		} else {
+			indexes := make([]int, 0, len(assessments))
+			for i := range assessments {
+				indexes = append(indexes, i)
+			}
+			sort.Ints(indexes)
+			for _, i := range indexes {
+				if i < len(record) && strings.TrimSpace(record[i]) != "" {
+					parseGrade(assessments[i], header[i], strings.TrimSpace(record[i]), cell(record, "enrollmentid"))
+				}
+			}
+		}
+		if len(errs) > 0 {
+			rowErrs = append(rowErrs, errs...)
+			continue
+		}
+		rows = append(rows, row)
+	}
+	return rows, rowErrs, nil
+}
+
+// parseAssessmentColumn 解析成绩册中 "Title (Type)" 形式的列名
+func parseAssessmentColumn(name string) (string, GradeType, bool) {
+	name = strings.TrimSpace(name)
+	open := strings.LastIndex(name, " (")
+	if open <= 0 || !strings.HasSuffix(name, ")") {
+		return "", "", false
+	}
+	typ := GradeType(name[open+2 : len(name)-1])
+	return name[:open], typ, typ.Valid()
+}
+
+// gradebook 生成成绩册：每个学生一行，每项考核一列，最后是总评和等级。
+// courseID 不为 0 时只包含选修该课程的学生在这门课程中的成绩。numeric 标出哪些列是数值。
+func gradebook(ss Students, catalog Catalog, courseID int, policy Policy) (rows [][]string, numeric []bool, err error) {
+	type entry struct {
+		student Student
+		summary Summary
+	}
+	var entries []entry
+	if courseID != 0 {
+		course, err := catalog.Course(courseID)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, cs := range catalog.courseStudents(course.ID, 0, ss, policy) {
+			entries = append(entries, entry{cs.Student, cs.Summary})
+		}
+	} else {
+		for _, s := range ss {
+			entries = append(entries, entry{s, policy.Summarize(s)})
+		}
+	}
+	type column struct {
+		title string
+		typ   GradeType
+	}
+	seen := make(map[column]bool)
+	var columns []column
+	for _, e := range entries {
+		for _, g := range e.student.Grades {
+			c := column{g.Title, g.Type}
+			if !seen[c] {
+				seen[c] = true
+				columns = append(columns, c)
+			}
+		}
+	}
+	typeOrder := map[GradeType]int{GradeQuiz: 0, GradeTest: 1, GradeExam: 2}
+	sort.SliceStable(columns, func(i, j int) bool {
+		if columns[i].typ != columns[j].typ {
+			return typeOrder[columns[i].typ] < typeOrder[columns[j].typ]
+		}
+		return columns[i].title < columns[j].title
+	})
+	header := []string{"ID", "FirstName", "LastName"}
+	numeric = []bool{true, false, false}
+	for _, c := range columns {
+		header = append(header, fmt.Sprintf("%s (%s)", c.title, c.typ))
+		numeric = append(numeric, true)
+	}
+	header = append(header, "Average", "Letter")
+	numeric = append(numeric, true, false)
+	rows = [][]string{header}
+	for _, e := range entries {
+		row := []string{strconv.Itoa(e.student.ID), e.student.FirstName, e.student.LastName}
+		for _, c := range columns {
+			value := ""
+			// 同一考核有多条成绩时取最后一条
+			for _, g := range e.student.Grades {
+				if g.Title == c.title && g.Type == c.typ {
+					value = strconv.FormatFloat(float64(g.Score), 'f', -1, 32)
+				}
+			}
+			row = append(row, value)
+		}
+		average := ""
+		if e.summary.Letter != "" {
+			average = strconv.FormatFloat(e.summary.Score, 'f', 2, 64)
+		}
+		rows = append(rows, append(row, average, e.summary.Letter))
+	}
+	return rows, numeric, nil
+}
+
+// gradebookHandler 处理成绩册的导入和导出
+type gradebookHandler struct {
+	store  Store
+	policy Policy
+}
+
+// ServeHTTP 处理以下请求：
+//
+//	GET  /gradebook?format={csv|xlsx}&course={courseID}  导出成绩册
+//	POST /gradebook?dryRun=true                          导入 CSV，格式见 Import
+//
+// 启用认证时，导入和导出全部学生需要管理员，教师可以导出自己负责的课程。
+func (gh gradebookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		gh.export(w, r)
+	case http.MethodPost:
+		gh.importCSV(w, r)
+	default:
+		writeError(w, errMethodNotAllowed)
+	}
+}
+
+func (gh gradebookHandler) export(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var verr ValidationError
+	format := q.Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "xlsx" {
+		verr.add("format", "must be csv or xlsx")
+	}
+	var courseID int
+	if v := q.Get("course"); v != "" {
+		var err error
+		if courseID, err = strconv.Atoi(v); err != nil {
+			verr.add("course", "must be a course ID")
+		}
+	}
+	if err := verr.err(); err != nil {
+		writeError(w, err)
+		return
+	}
+	if p, ok := PrincipalFrom(r.Context()); ok && p.Role != RoleAdmin && !(p.Role == RoleTeacher && courseID != 0 && p.teaches(courseID)) {
+		writeError(w, fmt.Errorf("%w: %s %q cannot export this gradebook", ErrForbidden, p.Role, p.Name))
+		return
+	}
+	students, err := gh.store.List()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	catalog, err := gh.store.Catalog()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	rows, numeric, err := gradebook(students, catalog, courseID, gh.policy)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if format == "xlsx" {
+		w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		w.Header().Set("Content-Disposition", `attachment; filename="gradebook.xlsx"`)
+		if err := writeXLSX(w, "Gradebook", rows, numeric); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="gradebook.csv"`)
+	cw := csv.NewWriter(w)
+	cw.WriteAll(rows)
+	if err := cw.Error(); err != nil {
+		log.Println(err)
+	}
+}
+
+func (gh gradebookHandler) importCSV(w http.ResponseWriter, r *http.Request) {
+	if err := requireRole(r, RoleAdmin); err != nil {
+		writeError(w, err)
+		return
+	}
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dryRun"))
+	ctx := requestContext(r)
+	if ChangeFrom(ctx).Reason == "" {
+		ctx = WithChange(ctx, Change{Actor: ChangeFrom(ctx).Actor, Reason: "import"})
+	}
+	result, err := Import(ctx, gh.store, http.MaxBytesReader(w, r.Body, maxImportSize), dryRun)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	status := http.StatusOK
+	if len(result.Errors) > 0 {
+		status = http.StatusUnprocessableEntity
+	}
+	writeJSON(w, status, result)
+}
@@ -0,0 +1,150 @@
+package grades
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// gradeScores 返回学生每项考核的分数，键为 "Title (Type)"
+func gradeScores(s Student) map[string]float32 {
+	scores := make(map[string]float32)
+	for _, g := range s.Grades {
+		scores[g.Title+" ("+string(g.Type)+")"] = g.Score
+	}
+	return scores
+}
+
+const importCSV = `ID,FirstName,LastName,Title,Type,Score
+1,Ada,Lovelace,Quiz 1,Quiz,95
+1,Ada,Lovelace,Quiz 2,Quiz,70
+1,Ada,Lovelace,Quiz 2,Quiz,75
+1,Ada,Lovelace,Quiz 1,Quiz,96
+,Grace,Hopper,Exam,Exam,88
+,grace,hopper,Exam,Exam,89
+`
+
+func TestImportUpsert(t *testing.T) {
+	store := NewMemoryStore(testSeed())
+	want := ImportResult{Rows: 6, StudentsCreated: 1, GradesAdded: 2, GradesUpdated: 1}
+
+	// 试运行只报告将要进行的修改
+	version := store.Version()
+	result, err := Import(context.Background(), store, strings.NewReader(importCSV), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dryWant := want
+	dryWant.DryRun = true
+	if !sameResult(result, dryWant) {
+		t.Fatalf("dry run result is %+v, want %+v", result, dryWant)
+	}
+	if store.Version() != version {
+		t.Fatal("a dry run changed the store")
+	}
+
+	result, err = Import(context.Background(), store, strings.NewReader(importCSV), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameResult(result, want) {
+		t.Fatalf("import result is %+v, want %+v", result, want)
+	}
+	ada, _ := store.Get(1)
+	if got := gradeScores(ada); len(ada.Grades) != 2 || got["Quiz 1 (Quiz)"] != 96 || got["Quiz 2 (Quiz)"] != 75 {
+		t.Fatalf("Ada's grades are %+v, want Quiz 1 updated to 96 and one Quiz 2 of 75", ada.Grades)
+	}
+	if ada.Grades[0].ID != 1 {
+		t.Fatalf("the updated grade has ID %d, want it to keep ID 1", ada.Grades[0].ID)
+	}
+	students, _ := store.List()
+	grace := students[len(students)-1]
+	if grace.FirstName != "Grace" || len(grace.Grades) != 1 || grace.Grades[0].Score != 89 {
+		t.Fatalf("new student is %+v, want Grace Hopper with one Exam of 89", grace)
+	}
+}
+
+// sameResult 比较两个没有行错误的导入结果
+func sameResult(got, want ImportResult) bool {
+	return len(got.Errors) == 0 && got.DryRun == want.DryRun && got.Rows == want.Rows &&
+		got.StudentsCreated == want.StudentsCreated && got.StudentsUpdated == want.StudentsUpdated &&
+		got.GradesAdded == want.GradesAdded && got.GradesUpdated == want.GradesUpdated
+}
+
+func TestImportRowErrorsChangeNothing(t *testing.T) {
+	store := NewMemoryStore(testSeed())
+	version := store.Version()
+	csv := `ID,FirstName,LastName,Title,Type,Score,EnrollmentID
+1,Ada,Lovelace,Quiz 2,Quiz,70,
+2,Alan,Turing,Quiz 1,Quiz,abc,
+9,,,Quiz 1,Quiz,50,
+,Alan,,Quiz 1,Quiz,50,
+1,Ada,Lovelace,Exam,Essay,50,
+1,Ada,Lovelace,Exam,Exam,50,7
+`
+	result, err := Import(context.Background(), store, strings.NewReader(csv), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantRows := []int{3, 4, 5, 6, 7}
+	if len(result.Errors) != len(wantRows) {
+		t.Fatalf("row errors are %+v, want errors in rows %v", result.Errors, wantRows)
+	}
+	for i, e := range result.Errors {
+		if e.Row != wantRows[i] {
+			t.Fatalf("row errors are %+v, want errors in rows %v", result.Errors, wantRows)
+		}
+	}
+	if store.Version() != version {
+		t.Fatal("an import with row errors changed the store")
+	}
+}
+
+func TestGradebookRoundTrip(t *testing.T) {
+	store := NewMemoryStore(testSeed())
+	ctx := context.Background()
+	if _, err := store.AddGrade(ctx, 2, Grade{Title: "Quiz 1", Type: GradeQuiz, Score: 70}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.AddGrade(ctx, 2, Grade{Title: "Quiz 1", Type: GradeExam, Score: 65.5}); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	NewServer(store).RegisterHandlers(mux)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/gradebook", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /gradebook responded with %v: %s", w.Code, w.Body)
+	}
+	exported := w.Body.String()
+
+	// 导出的成绩册原样导入不会修改数据
+	version := store.Version()
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/gradebook", strings.NewReader(exported)))
+	var result ImportResult
+	json.NewDecoder(w.Body).Decode(&result)
+	if w.Code != http.StatusOK || result.GradesUnchanged != 3 || result.GradesAdded != 0 || result.GradesUpdated != 0 {
+		t.Fatalf("re-importing the export responded with %v and %+v, want 3 unchanged grades", w.Code, result)
+	}
+	if store.Version() != version {
+		t.Fatal("re-importing the export changed the store")
+	}
+
+	// 修改一个分数后导入只更新这一项考核，同名但类型不同的成绩不受影响
+	edited := strings.Replace(exported, "65.5", "80", 1)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/gradebook", strings.NewReader(edited)))
+	result = ImportResult{}
+	json.NewDecoder(w.Body).Decode(&result)
+	if w.Code != http.StatusOK || result.GradesUpdated != 1 || result.GradesAdded != 0 {
+		t.Fatalf("importing the edited export responded with %v and %+v, want one updated grade", w.Code, result)
+	}
+	alan, _ := store.Get(2)
+	if got := gradeScores(alan); len(alan.Grades) != 2 || got["Quiz 1 (Quiz)"] != 70 || got["Quiz 1 (Exam)"] != 80 {
+		t.Fatalf("Alan's grades are %+v, want the quiz unchanged and the exam updated to 80", alan.Grades)
+	}
+}
@@ -2,7 +2,6 @@ package grades
 
 import (
 	"fmt"
-	"sync"
 )
 
 type Student struct {
@@ -10,9 +9,15 @@ type Student struct {
 	FirstName string
 	LastName  string
 	Grades    []Grade
+	// Version 在学生或其成绩每次修改后增加，作为 ETag 用于乐观并发控制
+	Version uint64 `json:",omitempty"`
 }
 
+// Average 返回学生所有成绩的算术平均，没有成绩时返回 0。按评分策略计算总评见 Policy.Summarize。
 func (s Student) Average() float32 {
+	if len(s.Grades) == 0 {
+		return 0
+	}
 	var result float32
 	for _, grade := range s.Grades {
 		result += grade.Score
@@ -22,18 +27,23 @@ func (s Student) Average() float32 {
 
 type Students []Student
 
-var (
-	students      Students
-	studentsMutex sync.Mutex
-)
-
 func (ss Students) GetByID(id int) (*Student, error) {
 	for i := range ss {
 		if ss[i].ID == id {
 			return &ss[i], nil
 		}
 	}
-	return nil, fmt.Errorf("student with ID %d not found", id)
+	return nil, fmt.Errorf("student with ID %d %w", id, ErrNotFound)
+}
+
+// GradeByID 返回学生 ID 为 id 的成绩
+func (s *Student) GradeByID(id int) (*Grade, error) {
+	for i := range s.Grades {
+		if s.Grades[i].ID == id {
+			return &s.Grades[i], nil
+		}
+	}
+	return nil, fmt.Errorf("grade with ID %d of student %d %w", id, s.ID, ErrNotFound)
 }
 
 type GradeType string
@@ -45,7 +55,10 @@ const (
 )
 
 type Grade struct {
+	ID    int
 	Title string
 	Type  GradeType
 	Score float32
+	// EnrollmentID 是成绩所属的选课记录，为 0 时成绩不属于任何课程
+	EnrollmentID int `json:",omitempty"`
 }
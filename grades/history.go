@@ -0,0 +1,119 @@
+package grades
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// 修改学生和成绩的请求可以用这些请求头说明操作者和修改原因
+const (
+	ActorHeader  = "X-Actor"
+	ReasonHeader = "X-Change-Reason"
+)
+
+// systemActor 是服务自身产生的修改的操作者，例如导入初始数据
+const systemActor = "system"
+
+// Change 说明一次修改的操作者和原因
+type Change struct {
+	Actor  string
+	Reason string
+}
+
+type changeKey struct{}
+
+// WithChange 返回带有操作者和原因的 ctx，传给 Store 的修改方法后会被记录在事件中
+func WithChange(ctx context.Context, c Change) context.Context {
+	return context.WithValue(ctx, changeKey{}, c)
+}
+
+// ChangeFrom 返回 ctx 中的操作者和原因
+func ChangeFrom(ctx context.Context) Change {
+	c, _ := ctx.Value(changeKey{}).(Change)
+	return c
+}
+
+// requestContext 返回带有操作者和原因的请求 context。启用认证时操作者是通过认证的调用者，
+// 否则取自请求头。
+func requestContext(r *http.Request) context.Context {
+	actor := r.Header.Get(ActorHeader)
+	if p, ok := PrincipalFrom(r.Context()); ok {
+		actor = p.Name
+	}
+	return WithChange(r.Context(), Change{Actor: actor, Reason: r.Header.Get(ReasonHeader)})
+}
+
+// EventType 是事件的类型
+type EventType string
+
+const (
+	StudentCreated = EventType("StudentCreated")
+	StudentUpdated = EventType("StudentUpdated")
+	StudentDeleted = EventType("StudentDeleted")
+	GradeAdded     = EventType("GradeAdded")
+	GradeUpdated   = EventType("GradeUpdated")
+	GradeDeleted   = EventType("GradeDeleted")
+)
+
+// Event 是对一个学生或其成绩的一次修改，创建后不会再被修改。
+// 成绩事件的 Previous 和 New 是修改前后的成绩，学生事件的 PreviousStudent 和 NewStudent 是修改前后的学生。
+type Event struct {
+	Seq             uint64    `json:"seq"`
+	Type            EventType `json:"type"`
+	Time            time.Time `json:"time"`
+	Actor           string    `json:"actor,omitempty"`
+	Reason          string    `json:"reason,omitempty"`
+	StudentID       int       `json:"studentId"`
+	GradeID         int       `json:"gradeId,omitempty"`
+	Previous        *Grade    `json:"previous,omitempty"`
+	New             *Grade    `json:"new,omitempty"`
+	PreviousStudent *Student  `json:"previousStudent,omitempty"`
+	NewStudent      *Student  `json:"newStudent,omitempty"`
+}
+
+// Replay 按顺序重放事件，返回事件结束时的学生数据。重放存储中的全部事件得到的结果与 Store.List 相同。
+func Replay(events []Event) Students {
+	ss := Students{}
+	for _, e := range events {
+		student, err := ss.GetByID(e.StudentID)
+		switch e.Type {
+		case StudentCreated:
+			ss = append(ss, e.NewStudent.clone())
+		case StudentUpdated:
+			if err == nil {
+				*student = e.NewStudent.clone()
+			}
+		case StudentDeleted:
+			for i := range ss {
+				if ss[i].ID == e.StudentID {
+					ss = append(ss[:i:i], ss[i+1:]...)
+					break
+				}
+			}
+		case GradeAdded:
+			if err == nil {
+				student.Grades = append(student.Grades, *e.New)
+				student.Version++
+			}
+		case GradeUpdated:
+			if err == nil {
+				if grade, err := student.GradeByID(e.GradeID); err == nil {
+					*grade = *e.New
+					student.Version++
+				}
+			}
+		case GradeDeleted:
+			if err == nil {
+				for i := range student.Grades {
+					if student.Grades[i].ID == e.GradeID {
+						student.Grades = append(student.Grades[:i:i], student.Grades[i+1:]...)
+						student.Version++
+						break
+					}
+				}
+			}
+		}
+	}
+	return ss
+}
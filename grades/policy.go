@@ -0,0 +1,165 @@
+package grades
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// Cutoff 是一个等级的最低分数线及对应的绩点
+type Cutoff struct {
+	Letter string  `json:"letter"`
+	Min    float64 `json:"min"`
+	GPA    float64 `json:"gpa"`
+}
+
+// Policy 描述如何由各项成绩计算总评。零值按所有成绩的算术平均计算，使用默认的等级分数线。
+type Policy struct {
+	// Weights 是每种成绩类型的权重，为空时所有成绩权重相同。
+	// 学生缺少某种类型的成绩时，其余类型的权重按比例放大。
+	Weights map[GradeType]float64 `json:"weights,omitempty"`
+	// DropLowestQuiz 为 true 时，学生有两次以上测验时去掉分数最低的一次
+	DropLowestQuiz bool `json:"dropLowestQuiz,omitempty"`
+	// Cutoffs 是等级分数线，为空时使用 DefaultCutoffs
+	Cutoffs []Cutoff `json:"cutoffs,omitempty"`
+}
+
+// DefaultCutoffs 是默认的等级分数线
+var DefaultCutoffs = []Cutoff{
+	{Letter: "A", Min: 90, GPA: 4.0},
+	{Letter: "B", Min: 80, GPA: 3.0},
+	{Letter: "C", Min: 70, GPA: 2.0},
+	{Letter: "D", Min: 60, GPA: 1.0},
+	{Letter: "F", Min: 0, GPA: 0},
+}
+
+// LoadPolicy 从 JSON 文件中读取评分策略，例如：
+//
+//	{"weights": {"Exam": 0.5, "Test": 0.3, "Quiz": 0.2}, "dropLowestQuiz": true}
+func LoadPolicy(path string) (Policy, error) {
+	var p Policy
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return p, err
+	}
+	if err := json.Unmarshal(data, &p); err != nil {
+		return p, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := p.Validate(); err != nil {
+		return p, fmt.Errorf("%s: %w", path, err)
+	}
+	return p, nil
+}
+
+// Validate 检查权重是否为非负数、成绩类型是否合法，以及分数线是否完整
+func (p Policy) Validate() error {
+	var verr ValidationError
+	for t, w := range p.Weights {
+		if !t.Valid() {
+			verr.add("weights."+string(t), "unknown grade type")
+		}
+		if w < 0 {
+			verr.add("weights."+string(t), "must not be negative")
+		}
+	}
+	for i, c := range p.Cutoffs {
+		if c.Letter == "" {
+			verr.add(fmt.Sprintf("cutoffs[%d].letter", i), "is required")
+		}
+	}
+	return verr.err()
+}
+
+// TypeSummary 是学生某种类型成绩的汇总
+type TypeSummary struct {
+	Count   int     `json:"count"`
+	Average float64 `json:"average"`
+	Weight  float64 `json:"weight"`
+}
+
+// Summary 是按评分策略计算出的学生总评
+type Summary struct {
+	// Score 是加权后的总评分数，学生没有计入总评的成绩时为 0
+	Score float64 `json:"score"`
+	// Letter 和 GPA 是 Score 对应的等级和绩点，学生没有计入总评的成绩时为空
+	Letter string                    `json:"letter,omitempty"`
+	GPA    float64                   `json:"gpa"`
+	ByType map[GradeType]TypeSummary `json:"byType"`
+	// Dropped 是按策略没有计入总评的成绩 ID
+	Dropped []int `json:"dropped,omitempty"`
+}
+
+// Summarize 按策略计算学生 s 的总评
+func (p Policy) Summarize(s Student) Summary {
+	summary := Summary{ByType: make(map[GradeType]TypeSummary)}
+	grades := s.Grades
+	if p.DropLowestQuiz {
+		lowest := -1
+		quizzes := 0
+		for i, g := range grades {
+			if g.Type != GradeQuiz {
+				continue
+			}
+			quizzes++
+			if lowest < 0 || g.Score < grades[lowest].Score {
+				lowest = i
+			}
+		}
+		if quizzes > 1 {
+			summary.Dropped = []int{grades[lowest].ID}
+			grades = append(grades[:lowest:lowest], grades[lowest+1:]...)
+		}
+	}
+	if len(grades) == 0 {
+		return summary
+	}
+	totals := make(map[GradeType]float64)
+	for _, g := range grades {
+		ts := summary.ByType[g.Type]
+		ts.Count++
+		summary.ByType[g.Type] = ts
+		totals[g.Type] += float64(g.Score)
+	}
+	var weighted, weights float64
+	for t, ts := range summary.ByType {
+		ts.Average = totals[t] / float64(ts.Count)
+		if len(p.Weights) == 0 {
+			// 没有配置权重时按成绩的条数加权，即所有成绩的算术平均
+			ts.Weight = float64(ts.Count)
+		} else {
+			ts.Weight = p.Weights[t]
+		}
+		summary.ByType[t] = ts
+		weighted += ts.Average * ts.Weight
+		weights += ts.Weight
+	}
+	if weights == 0 {
+		return summary
+	}
+	// 把实际使用的权重归一化，便于展示
+	for t, ts := range summary.ByType {
+		ts.Weight /= weights
+		summary.ByType[t] = ts
+	}
+	summary.Score = weighted / weights
+	summary.Letter, summary.GPA = p.letter(summary.Score)
+	return summary
+}
+
+// letter 返回分数对应的等级和绩点
+func (p Policy) letter(score float64) (string, float64) {
+	cutoffs := p.Cutoffs
+	if len(cutoffs) == 0 {
+		cutoffs = DefaultCutoffs
+	}
+	sorted := append([]Cutoff{}, cutoffs...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Min > sorted[j].Min })
+	for _, c := range sorted {
+		if score >= c.Min {
+			return c.Letter, c.GPA
+		}
+	}
+	last := sorted[len(sorted)-1]
+	return last.Letter, last.GPA
+}
@@ -0,0 +1,191 @@
+package grades
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// GET /students 每页的学生数：没有 limit 参数时使用 defaultStudentLimit，limit 最大为 maxStudentLimit
+const (
+	defaultStudentLimit = 50
+	maxStudentLimit     = 1000
+)
+
+// StudentQuery 描述了 GET /students 的搜索、过滤、排序和分页条件，零值的 Apply 返回所有学生
+type StudentQuery struct {
+	// Search 按姓名搜索，不区分大小写
+	Search string
+	// MinAverage 和 MaxAverage 按评分策略计算的总评分数过滤，设置后没有成绩的学生不会出现在结果中
+	MinAverage *float64
+	MaxAverage *float64
+	// Types 只保留至少有一条这些类型成绩的学生
+	Types []GradeType
+	// Sort 是排序字段：id、firstName、lastName 或 average，Desc 为 true 时降序
+	Sort string
+	Desc bool
+	// Offset 和 Limit 用于分页，Limit 为 0 时不限制条数
+	Offset int
+	Limit  int
+}
+
+// ParseStudentQuery 从查询参数中解析搜索条件。支持的参数：
+// q、minAvg、maxAvg、type（可重复）、sort（字段名前加 - 表示降序）、offset、limit。
+// 没有 limit 参数时每页 50 个学生，limit 超过 1000 时按 1000 处理。
+func ParseStudentQuery(q url.Values) (StudentQuery, error) {
+	sq := StudentQuery{Limit: defaultStudentLimit}
+	var verr ValidationError
+	sq.Search = strings.TrimSpace(q.Get("q"))
+	for _, name := range []string{"minAvg", "maxAvg"} {
+		v := q.Get(name)
+		if v == "" {
+			continue
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			verr.add(name, "must be a number")
+			continue
+		}
+		if name == "minAvg" {
+			sq.MinAverage = &f
+		} else {
+			sq.MaxAverage = &f
+		}
+	}
+	for _, t := range q["type"] {
+		if !GradeType(t).Valid() {
+			verr.add("type", fmt.Sprintf("must be one of %s, %s, %s", GradeQuiz, GradeTest, GradeExam))
+			continue
+		}
+		sq.Types = append(sq.Types, GradeType(t))
+	}
+	if s := q.Get("sort"); s != "" {
+		sq.Sort, sq.Desc = strings.TrimPrefix(s, "-"), strings.HasPrefix(s, "-")
+		switch sq.Sort {
+		case "id", "firstName", "lastName", "average":
+		default:
+			verr.add("sort", "must be one of id, firstName, lastName, average")
+		}
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			verr.add("offset", "must be a non-negative integer")
+		}
+		sq.Offset = n
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			verr.add("limit", "must be a positive integer")
+		}
+		if n > maxStudentLimit {
+			n = maxStudentLimit
+		}
+		sq.Limit = n
+	}
+	return sq, verr.err()
+}
+
+// Values 返回与条件对应的查询参数，是 ParseStudentQuery 的逆操作
+func (sq StudentQuery) Values() url.Values {
+	q := url.Values{}
+	if sq.Search != "" {
+		q.Set("q", sq.Search)
+	}
+	if sq.MinAverage != nil {
+		q.Set("minAvg", strconv.FormatFloat(*sq.MinAverage, 'f', -1, 64))
+	}
+	if sq.MaxAverage != nil {
+		q.Set("maxAvg", strconv.FormatFloat(*sq.MaxAverage, 'f', -1, 64))
+	}
+	for _, t := range sq.Types {
+		q.Add("type", string(t))
+	}
+	if sq.Sort != "" {
+		if sq.Desc {
+			q.Set("sort", "-"+sq.Sort)
+		} else {
+			q.Set("sort", sq.Sort)
+		}
+	}
+	if sq.Offset > 0 {
+		q.Set("offset", strconv.Itoa(sq.Offset))
+	}
+	if sq.Limit > 0 {
+		q.Set("limit", strconv.Itoa(sq.Limit))
+	}
+	return q
+}
+
+// Apply 按条件筛选、排序并分页 ss，返回当前页的学生和满足条件的学生总数
+func (sq StudentQuery) Apply(ss Students, policy Policy) (Students, int) {
+	search := strings.ToLower(sq.Search)
+	scores := make(map[int]float64, len(ss))
+	result := Students{}
+	for _, s := range ss {
+		if search != "" {
+			name := strings.ToLower(s.FirstName + " " + s.LastName)
+			if !strings.Contains(name, search) {
+				continue
+			}
+		}
+		if len(sq.Types) > 0 && !s.hasGradeType(sq.Types) {
+			continue
+		}
+		summary := policy.Summarize(s)
+		scores[s.ID] = summary.Score
+		if sq.MinAverage != nil || sq.MaxAverage != nil {
+			if summary.Letter == "" {
+				continue
+			}
+			if sq.MinAverage != nil && summary.Score < *sq.MinAverage {
+				continue
+			}
+			if sq.MaxAverage != nil && summary.Score > *sq.MaxAverage {
+				continue
+			}
+		}
+		result = append(result, s)
+	}
+	if sq.Sort != "" {
+		less := func(a, b Student) bool { return a.ID < b.ID }
+		switch sq.Sort {
+		case "firstName":
+			less = func(a, b Student) bool { return a.FirstName < b.FirstName }
+		case "lastName":
+			less = func(a, b Student) bool { return a.LastName < b.LastName }
+		case "average":
+			less = func(a, b Student) bool { return scores[a.ID] < scores[b.ID] }
+		}
+		sort.SliceStable(result, func(i, j int) bool {
+			if sq.Desc {
+				return less(result[j], result[i])
+			}
+			return less(result[i], result[j])
+		})
+	}
+	total := len(result)
+	if sq.Offset >= total {
+		return Students{}, total
+	}
+	result = result[sq.Offset:]
+	if sq.Limit > 0 && len(result) > sq.Limit {
+		result = result[:sq.Limit]
+	}
+	return result, total
+}
+
+// hasGradeType 判断学生是否有 types 中任意一种类型的成绩
+func (s Student) hasGradeType(types []GradeType) bool {
+	for _, g := range s.Grades {
+		for _, t := range types {
+			if g.Type == t {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -3,106 +3,651 @@ package grades
 import (
 	"bytes"
 	"encoding/json"
-	"log"
+	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
 )
 
+// defaultStore 是 RegisterHandlers 使用的存储，默认是装有示例数据的内存存储
+var defaultStore Store = NewMemoryStore(MockStudents())
+
+// defaultPolicy 是 RegisterHandlers 使用的评分策略
+var defaultPolicy Policy
+
+// defaultAuth 是 RegisterHandlers 使用的认证器，为 nil 时不启用认证
+var defaultAuth *Authenticator
+
+// SetStore 替换 RegisterHandlers 使用的存储，需要在 RegisterHandlers 之前调用
+func SetStore(store Store) {
+	defaultStore = store
+}
+
+// SetPolicy 替换 RegisterHandlers 使用的评分策略，需要在 RegisterHandlers 之前调用
+func SetPolicy(policy Policy) {
+	defaultPolicy = policy
+}
+
+// SetAuthenticator 为 RegisterHandlers 注册的接口启用认证和授权，需要在 RegisterHandlers 之前调用
+func SetAuthenticator(auth *Authenticator) {
+	defaultAuth = auth
+}
+
+// RegisterHandlers 在默认的 ServeMux 上注册成绩服务的http请求处理器
 func RegisterHandlers() {
-	handler := new(studentsHandler)
-	http.Handle("/students", handler)
-	http.Handle("/students/", handler)
+	s := NewServer(defaultStore)
+	s.SetPolicy(defaultPolicy)
+	s.SetAuthenticator(defaultAuth)
+	s.RegisterHandlers(http.DefaultServeMux)
+}
+
+// Server 是成绩服务的一个实例，持有独立的存储，
+// 同一进程中可以创建多个互不影响的实例。
+type Server struct {
+	store  Store
+	policy Policy
+	auth   *Authenticator
+	events *Publisher
+}
+
+// NewServer 创建一个使用 store 的成绩服务实例，store 中的新事件会推送给 /subscriptions 中的订阅者
+func NewServer(store Store) *Server {
+	return &Server{store: store, events: NewPublisher(store)}
+}
+
+// Events 返回把实例的事件推送给订阅者的 Publisher
+func (s *Server) Events() *Publisher {
+	return s.events
+}
+
+// SetPolicy 替换实例使用的评分策略，需要在 RegisterHandlers 之前调用
+func (s *Server) SetPolicy(policy Policy) {
+	s.policy = policy
+}
+
+// SetAuthenticator 为实例的接口启用认证和授权，需要在 RegisterHandlers 之前调用。
+// 启用后所有请求都需要携带令牌，学生只能读取自己的记录，教师只能访问选修自己课程的学生，
+// 管理员不受限制；POST /auth/token 供管理员签发会话令牌。
+func (s *Server) SetAuthenticator(auth *Authenticator) {
+	s.auth = auth
+}
+
+// RegisterHandlers 在 mux 上注册成绩服务的http请求处理器
+func (s *Server) RegisterHandlers(mux *http.ServeMux) {
+	handler := s.protect(&studentsHandler{store: s.store, policy: s.policy})
+	mux.Handle("/students", handler)
+	mux.Handle("/students/", handler)
+	courses := s.protect(&coursesHandler{store: s.store, policy: s.policy})
+	mux.Handle("/terms", courses)
+	mux.Handle("/courses", courses)
+	mux.Handle("/courses/", courses)
+	mux.Handle("/analytics/", s.protect(&analyticsHandler{store: s.store, policy: s.policy}))
+	mux.Handle("/gradebook", s.protect(gradebookHandler{store: s.store, policy: s.policy}))
+	subscriptions := s.protect(subscriptionsHandler{events: s.events})
+	mux.Handle("/subscriptions", subscriptions)
+	mux.Handle("/subscriptions/", subscriptions)
+	if s.auth != nil {
+		mux.Handle("/auth/token", s.protect(http.HandlerFunc(s.auth.tokenHandler)))
+	}
+}
+
+// protect 在启用认证时为 h 加上认证中间件
+func (s *Server) protect(h http.Handler) http.Handler {
+	if s.auth == nil {
+		return h
+	}
+	return s.auth.Middleware(h)
+}
+
+// Restrict 返回只允许 roles 中的角色访问的 h，用于在实例的 mux 之外注册的接口。没有启用认证时返回 h。
+func (s *Server) Restrict(h http.Handler, roles ...Role) http.Handler {
+	if s.auth == nil {
+		return h
+	}
+	return s.auth.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := requireRole(r, roles...); err != nil {
+			writeError(w, err)
+			return
+		}
+		h.ServeHTTP(w, r)
+	}))
 }
 
 type studentsHandler struct {
+	store  Store
+	policy Policy
+}
+
+// studentView 是 GET /students/{id} 的响应，在学生数据之外附带按评分策略计算的总评
+type studentView struct {
+	Student
+	Summary Summary
 }
 
+// ServeHTTP 按路径和方法分发请求：
+//
+//	GET, POST               /students
+//	GET, PUT, PATCH, DELETE /students/{id}
+//	GET, POST               /students/{id}/grades
+//	GET, PUT, PATCH, DELETE /students/{id}/grades/{gradeID}
+//	GET                     /students/{id}/courses
+//	GET, POST               /students/{id}/enrollments
+//	DELETE                  /students/{id}/enrollments/{enrollmentID}
+//	GET                     /students/{id}/history
+//
+// 修改学生和成绩的请求可以用 X-Actor 和 X-Change-Reason 请求头说明操作者和原因，它们会被记录在历史中。
+// 读取单个学生及其成绩的响应带有学生版本的 ETag，并支持 If-None-Match；
+// PUT、PATCH 和 DELETE 必须带有 If-Match，版本不一致时返回 412，POST 成绩时 If-Match 是可选的。
 func (sh studentsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	pathSegments := strings.Split(r.URL.Path, "/")
-	switch len(pathSegments) {
-	case 2:
-		sh.GetAll(w, r)
-	case 3:
-		id, err := strconv.Atoi(pathSegments[2])
-		if err != nil {
-			w.WriteHeader(http.StatusNotFound)
+	var id, subID int
+	var resource string
+	var err error
+	if len(pathSegments) >= 3 {
+		if id, err = strconv.Atoi(pathSegments[2]); err != nil {
+			writeError(w, errRouteNotFound)
 			return
 		}
-		sh.GetOne(w, r, id)
-	case 4:
-		id, err := strconv.Atoi(pathSegments[2])
-		if err != nil {
-			w.WriteHeader(http.StatusNotFound)
+	}
+	if len(pathSegments) >= 4 {
+		resource = pathSegments[3]
+	}
+	if len(pathSegments) == 5 {
+		if subID, err = strconv.Atoi(pathSegments[4]); err != nil {
+			writeError(w, errRouteNotFound)
 			return
 		}
-		sh.addGrade(w, r, id)
+	}
+	if err := sh.authorize(r, len(pathSegments), resource, id); err != nil {
+		writeError(w, err)
+		return
+	}
+	switch {
+	case len(pathSegments) == 2:
+		switch r.Method {
+		case http.MethodGet:
+			sh.GetAll(w, r)
+		case http.MethodPost:
+			sh.createStudent(w, r)
+		default:
+			writeError(w, errMethodNotAllowed)
+		}
+	case len(pathSegments) == 3:
+		switch r.Method {
+		case http.MethodGet:
+			sh.GetOne(w, r, id)
+		case http.MethodPut:
+			sh.replaceStudent(w, r, id)
+		case http.MethodPatch:
+			sh.patchStudent(w, r, id)
+		case http.MethodDelete:
+			sh.deleteStudent(w, r, id)
+		default:
+			writeError(w, errMethodNotAllowed)
+		}
+	case len(pathSegments) == 4 && resource == "grades":
+		switch r.Method {
+		case http.MethodGet:
+			sh.getGrades(w, r, id)
+		case http.MethodPost:
+			sh.addGrade(w, r, id)
+		default:
+			writeError(w, errMethodNotAllowed)
+		}
+	case len(pathSegments) == 5 && resource == "grades":
+		switch r.Method {
+		case http.MethodGet:
+			sh.getGrade(w, r, id, subID)
+		case http.MethodPut:
+			sh.replaceGrade(w, r, id, subID)
+		case http.MethodPatch:
+			sh.patchGrade(w, r, id, subID)
+		case http.MethodDelete:
+			sh.deleteGrade(w, r, id, subID)
+		default:
+			writeError(w, errMethodNotAllowed)
+		}
+	case len(pathSegments) == 4 && resource == "courses":
+		switch r.Method {
+		case http.MethodGet:
+			sh.getCourses(w, r, id)
+		default:
+			writeError(w, errMethodNotAllowed)
+		}
+	case len(pathSegments) == 4 && resource == "history":
+		switch r.Method {
+		case http.MethodGet:
+			sh.getHistory(w, r, id)
+		default:
+			writeError(w, errMethodNotAllowed)
+		}
+	case len(pathSegments) == 4 && resource == "enrollments":
+		switch r.Method {
+		case http.MethodGet:
+			sh.getEnrollments(w, r, id)
+		case http.MethodPost:
+			sh.enroll(w, r, id)
+		default:
+			writeError(w, errMethodNotAllowed)
+		}
+	case len(pathSegments) == 5 && resource == "enrollments":
+		switch r.Method {
+		case http.MethodDelete:
+			sh.unenroll(w, r, id, subID)
+		default:
+			writeError(w, errMethodNotAllowed)
+		}
 	default:
-		w.WriteHeader(http.StatusNotFound)
+		writeError(w, errRouteNotFound)
 	}
 }
 
-func (sh studentsHandler) GetAll(w http.ResponseWriter, r *http.Request) {
-	studentsMutex.Lock()
-	defer studentsMutex.Unlock()
+// authorize 检查调用者能否发出请求：列表和读取对所有角色开放但按角色限制范围，
+// 修改成绩需要教师或管理员，其余修改只有管理员可以进行
+func (sh studentsHandler) authorize(r *http.Request, segments int, resource string, id int) error {
+	switch {
+	case segments == 2:
+		if r.Method == http.MethodGet {
+			return nil
+		}
+		return requireRole(r, RoleAdmin)
+	case r.Method == http.MethodGet:
+		return studentAccess(r, sh.store.Catalog, id, false)
+	case resource == "grades":
+		return studentAccess(r, sh.store.Catalog, id, true)
+	default:
+		return requireRole(r, RoleAdmin)
+	}
+}
+
+// visible 返回调用者可以看到的学生：学生只能看到自己，教师只能看到选修自己课程的学生及其在这些课程中的成绩
+func (sh studentsHandler) visible(r *http.Request, students Students) (Students, error) {
+	p, ok := PrincipalFrom(r.Context())
+	if !ok || p.Role == RoleAdmin {
+		return students, nil
+	}
+	if p.Role == RoleTeacher {
+		catalog, err := sh.store.Catalog()
+		if err != nil {
+			return nil, err
+		}
+		return catalog.teacherStudents(p, students), nil
+	}
+	result := Students{}
+	for _, s := range students {
+		if p.Role == RoleStudent && p.StudentID == s.ID {
+			result = append(result, s)
+		}
+	}
+	return result, nil
+}
+
+// scoped 返回学生 s 中调用者可以看到的部分：教师只能看到自己课程中的成绩，其他调用者已经由 authorize 检查过
+func (sh studentsHandler) scoped(r *http.Request, s Student) (Student, error) {
+	p, ok := PrincipalFrom(r.Context())
+	if !ok || p.Role != RoleTeacher {
+		return s, nil
+	}
+	catalog, err := sh.store.Catalog()
+	if err != nil {
+		return Student{}, err
+	}
+	return catalog.teacherView(p, s), nil
+}
 
-	data, err := sh.toJSON(students)
+// GetAll 处理 GET /students，查询参数见 ParseStudentQuery。
+// 响应体仍是学生数组，满足条件的学生总数放在 X-Total-Count 响应头中，
+// 还有下一页时 X-Next-Offset 响应头给出下一页的 offset。
+func (sh studentsHandler) GetAll(w http.ResponseWriter, r *http.Request) {
+	query, err := ParseStudentQuery(r.URL.Query())
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Println(err)
+		writeError(w, err)
 		return
 	}
-	w.Header().Add("Content-Type", "application/json")
-	w.Write(data)
+	students, err := sh.store.List()
+	if err == nil {
+		students, err = sh.visible(r, students)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	page, total := query.Apply(students, sh.policy)
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	if next := query.Offset + len(page); next < total {
+		w.Header().Set("X-Next-Offset", strconv.Itoa(next))
+	}
+	writeJSON(w, http.StatusOK, page)
 }
 
 func (sh studentsHandler) GetOne(w http.ResponseWriter, r *http.Request, id int) {
-	studentsMutex.Lock()
-	defer studentsMutex.Unlock()
-	student, err := students.GetByID(id)
+	student, err := sh.store.Get(id)
+	if err == nil {
+		student, err = sh.scoped(r, student)
+	}
 	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
-		log.Println(err)
+		writeError(w, err)
 		return
 	}
-	data, err := sh.toJSON(student)
+	if !writeETag(w, r, student) {
+		return
+	}
+	writeJSON(w, http.StatusOK, studentView{Student: student, Summary: sh.policy.Summarize(student)})
+}
+
+// createStudent 处理 POST /students，学生的 ID 和成绩的 ID 由存储分配
+func (sh studentsHandler) createStudent(w http.ResponseWriter, r *http.Request) {
+	var s Student
+	if err := decodeBody(r, &s); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := s.Validate(); err != nil {
+		writeError(w, err)
+		return
+	}
+	s, err := sh.store.CreateStudent(requestContext(r), s)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Println(err)
+		writeError(w, err)
 		return
 	}
-	w.Header().Add("Content-Type", "application/json")
-	w.Write(data)
+	w.Header().Set("Location", fmt.Sprintf("/students/%d", s.ID))
+	w.Header().Set("ETag", ETag(s))
+	writeJSON(w, http.StatusCreated, s)
+}
+
+// replaceStudent 处理 PUT /students/{id}，请求体中没有 Grades 时保留原有的成绩。
+// 请求体可以是 GET /students/{id} 返回的内容，其中只读的 Summary 会被忽略。
+func (sh studentsHandler) replaceStudent(w http.ResponseWriter, r *http.Request, id int) {
+	var v studentView
+	if err := decodeBody(r, &v); err != nil {
+		writeError(w, err)
+		return
+	}
+	s := v.Student
+	s.ID = id
+	if err := s.Validate(); err != nil {
+		writeError(w, err)
+		return
+	}
+	ctx, err := conditionalContext(r, true)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	s, err = sh.store.ReplaceStudent(ctx, s)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("ETag", ETag(s))
+	writeJSON(w, http.StatusOK, s)
+}
+
+// studentPatch 是 PATCH /students/{id} 的请求体，只修改出现的字段
+type studentPatch struct {
+	FirstName *string
+	LastName  *string
+}
+
+func (sh studentsHandler) patchStudent(w http.ResponseWriter, r *http.Request, id int) {
+	var p studentPatch
+	if err := decodeBody(r, &p); err != nil {
+		writeError(w, err)
+		return
+	}
+	ctx, err := conditionalContext(r, true)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	s, err := sh.store.Get(id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if p.FirstName != nil {
+		s.FirstName = *p.FirstName
+	}
+	if p.LastName != nil {
+		s.LastName = *p.LastName
+	}
+	if err := s.Validate(); err != nil {
+		writeError(w, err)
+		return
+	}
+	s, err = sh.store.ReplaceStudent(ctx, s)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("ETag", ETag(s))
+	writeJSON(w, http.StatusOK, s)
+}
+
+func (sh studentsHandler) deleteStudent(w http.ResponseWriter, r *http.Request, id int) {
+	ctx, err := conditionalContext(r, true)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := sh.store.DeleteStudent(ctx, id); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (sh studentsHandler) getGrades(w http.ResponseWriter, r *http.Request, id int) {
+	student, err := sh.store.Get(id)
+	if err == nil {
+		student, err = sh.scoped(r, student)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if !writeETag(w, r, student) {
+		return
+	}
+	grades := student.Grades
+	if grades == nil {
+		grades = []Grade{}
+	}
+	writeJSON(w, http.StatusOK, grades)
+}
+
+func (sh studentsHandler) getGrade(w http.ResponseWriter, r *http.Request, id, gradeID int) {
+	student, err := sh.store.Get(id)
+	if err == nil {
+		student, err = sh.scoped(r, student)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	grade, err := student.GradeByID(gradeID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if !writeETag(w, r, student) {
+		return
+	}
+	writeJSON(w, http.StatusOK, grade)
 }
 
 func (sh studentsHandler) addGrade(w http.ResponseWriter, r *http.Request, id int) {
-	studentsMutex.Lock()
-	defer studentsMutex.Unlock()
-	student, err := students.GetByID(id)
+	var g Grade
+	if err := decodeBody(r, &g); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := g.Validate(); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := gradeAccess(r, sh.store.Catalog, id, g); err != nil {
+		writeError(w, err)
+		return
+	}
+	ctx, err := conditionalContext(r, false)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	g, err = sh.store.AddGrade(ctx, id, g)
 	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
-		log.Println(err)
+		writeError(w, err)
 		return
 	}
+	w.Header().Set("Location", fmt.Sprintf("/students/%d/grades/%d", id, g.ID))
+	writeJSON(w, http.StatusCreated, g)
+}
+
+// replaceGrade 处理 PUT /students/{id}/grades/{gradeID}
+func (sh studentsHandler) replaceGrade(w http.ResponseWriter, r *http.Request, id, gradeID int) {
 	var g Grade
-	dec := json.NewDecoder(r.Body)
-	err = dec.Decode(&g)
+	if err := decodeBody(r, &g); err != nil {
+		writeError(w, err)
+		return
+	}
+	g.ID = gradeID
+	if err := g.Validate(); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := sh.existingGradeAccess(r, id, gradeID); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := gradeAccess(r, sh.store.Catalog, id, g); err != nil {
+		writeError(w, err)
+		return
+	}
+	ctx, err := conditionalContext(r, true)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	g, err = sh.store.ReplaceGrade(ctx, id, g)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, g)
+}
+
+// gradePatch 是 PATCH /students/{id}/grades/{gradeID} 的请求体，只修改出现的字段
+type gradePatch struct {
+	Title *string
+	Type  *GradeType
+	Score *float32
+}
+
+func (sh studentsHandler) patchGrade(w http.ResponseWriter, r *http.Request, id, gradeID int) {
+	var p gradePatch
+	if err := decodeBody(r, &p); err != nil {
+		writeError(w, err)
+		return
+	}
+	ctx, err := conditionalContext(r, true)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	student, err := sh.store.Get(id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	grade, err := student.GradeByID(gradeID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := gradeAccess(r, sh.store.Catalog, id, *grade); err != nil {
+		writeError(w, err)
+		return
+	}
+	g := *grade
+	if p.Title != nil {
+		g.Title = *p.Title
+	}
+	if p.Type != nil {
+		g.Type = *p.Type
+	}
+	if p.Score != nil {
+		g.Score = *p.Score
+	}
+	if err := g.Validate(); err != nil {
+		writeError(w, err)
+		return
+	}
+	g, err = sh.store.ReplaceGrade(ctx, id, g)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, g)
+}
+
+func (sh studentsHandler) deleteGrade(w http.ResponseWriter, r *http.Request, id, gradeID int) {
+	if err := sh.existingGradeAccess(r, id, gradeID); err != nil {
+		writeError(w, err)
+		return
+	}
+	ctx, err := conditionalContext(r, true)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := sh.store.DeleteGrade(ctx, id, gradeID); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// existingGradeAccess 检查调用者能否修改学生 id 现有的成绩 gradeID
+func (sh studentsHandler) existingGradeAccess(r *http.Request, id, gradeID int) error {
+	if p, ok := PrincipalFrom(r.Context()); !ok || p.Role == RoleAdmin {
+		return nil
+	}
+	student, err := sh.store.Get(id)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		log.Println(err)
+		return err
 	}
-	student.Grades = append(student.Grades, g)
-	w.WriteHeader(http.StatusCreated)
-	data, err := sh.toJSON(g)
+	grade, err := student.GradeByID(gradeID)
 	if err != nil {
-		log.Println(err)
+		return err
+	}
+	return gradeAccess(r, sh.store.Catalog, id, *grade)
+}
+
+// writeETag 设置学生当前版本的 ETag 响应头。If-None-Match 中有该版本时返回 304 并返回 false，调用者不需要再写入响应体。
+func writeETag(w http.ResponseWriter, r *http.Request, s Student) bool {
+	w.Header().Set("ETag", ETag(s))
+	if notModified(r, s) {
+		w.WriteHeader(http.StatusNotModified)
+		return false
+	}
+	return true
+}
+
+// writeJSON 以 JSON 格式写入状态码为 status 的响应
+func writeJSON(w http.ResponseWriter, status int, obj interface{}) {
+	data, err := toJSON(obj)
+	if err != nil {
+		writeError(w, err)
 		return
 	}
 	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(status)
 	w.Write(data)
 }
 
-func (sh studentsHandler) toJSON(obj interface{}) ([]byte, error) {
+func toJSON(obj interface{}) ([]byte, error) {
 	var b bytes.Buffer
 	enc := json.NewEncoder(&b)
 	err := enc.Encode(obj)
@@ -111,3 +656,117 @@ func (sh studentsHandler) toJSON(obj interface{}) ([]byte, error) {
 	}
 	return b.Bytes(), nil
 }
+
+func (sh studentsHandler) getCourses(w http.ResponseWriter, r *http.Request, id int) {
+	student, err := sh.store.Get(id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	catalog, err := sh.store.Catalog()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	courses := catalog.studentCourses(student, sh.policy)
+	if p, ok := PrincipalFrom(r.Context()); ok && p.Role == RoleTeacher {
+		// 教师只能看到自己负责的课程
+		visible := []CourseEnrollment{}
+		for _, ce := range courses {
+			if p.teaches(ce.Course.ID) {
+				visible = append(visible, ce)
+			}
+		}
+		courses = visible
+	}
+	writeJSON(w, http.StatusOK, courses)
+}
+
+func (sh studentsHandler) getEnrollments(w http.ResponseWriter, r *http.Request, id int) {
+	if _, err := sh.store.Get(id); err != nil {
+		writeError(w, err)
+		return
+	}
+	catalog, err := sh.store.Catalog()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	enrollments := catalog.StudentEnrollments(id)
+	if p, ok := PrincipalFrom(r.Context()); ok && p.Role == RoleTeacher {
+		enrollments = catalog.teacherEnrollments(p, id)
+	}
+	if enrollments == nil {
+		enrollments = []Enrollment{}
+	}
+	writeJSON(w, http.StatusOK, enrollments)
+}
+
+// enroll 处理 POST /students/{id}/enrollments，请求体为 {"SectionID": 1}
+func (sh studentsHandler) enroll(w http.ResponseWriter, r *http.Request, id int) {
+	var e Enrollment
+	if err := decodeBody(r, &e); err != nil {
+		writeError(w, err)
+		return
+	}
+	e.StudentID = id
+	e, err := sh.store.Enroll(e)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("/students/%d/enrollments/%d", id, e.ID))
+	writeJSON(w, http.StatusCreated, e)
+}
+
+func (sh studentsHandler) unenroll(w http.ResponseWriter, r *http.Request, id, enrollmentID int) {
+	catalog, err := sh.store.Catalog()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if e, err := catalog.Enrollment(enrollmentID); err != nil || e.StudentID != id {
+		writeError(w, fmt.Errorf("enrollment with ID %d of student %d %w", enrollmentID, id, ErrNotFound))
+		return
+	}
+	if err := sh.store.Unenroll(enrollmentID); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getHistory 处理 GET /students/{id}/history，gradeId 参数只返回一条成绩的事件
+func (sh studentsHandler) getHistory(w http.ResponseWriter, r *http.Request, id int) {
+	var gradeID int
+	if v := r.URL.Query().Get("gradeId"); v != "" {
+		var err error
+		if gradeID, err = strconv.Atoi(v); err != nil {
+			writeError(w, &ValidationError{Fields: []FieldError{{Field: "gradeId", Message: "must be a grade ID"}}})
+			return
+		}
+	}
+	events, err := sh.store.History(id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if p, ok := PrincipalFrom(r.Context()); ok && p.Role == RoleTeacher {
+		catalog, err := sh.store.Catalog()
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		events = catalog.teacherEvents(p, id, events)
+	}
+	if gradeID != 0 {
+		filtered := []Event{}
+		for _, e := range events {
+			if e.GradeID == gradeID {
+				filtered = append(filtered, e)
+			}
+		}
+		events = filtered
+	}
+	writeJSON(w, http.StatusOK, events)
+}
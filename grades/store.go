@@ -0,0 +1,776 @@
+package grades
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrNotFound 表示要访问的学生、成绩或课程目录中的条目不存在
+var ErrNotFound = errors.New("not found")
+
+// ErrConflict 表示修改与现有数据冲突，例如删除还有成绩关联的选课记录
+var ErrConflict = errors.New("conflict")
+
+// Store 保存学生及其成绩，实现需要支持并发访问。
+// 返回的数据都是副本，调用者可以随意修改。
+// 找不到学生或成绩时返回的错误满足 errors.Is(err, ErrNotFound)。
+type Store interface {
+	// List 返回所有学生
+	List() (Students, error)
+	// Get 返回 ID 为 id 的学生
+	Get(id int) (Student, error)
+	// CreateStudent 添加一个学生，ID 由存储分配，返回添加后的学生。
+	// 修改学生和成绩的方法都会记录一条事件，事件的操作者和原因来自 ctx，见 WithChange。
+	CreateStudent(ctx context.Context, s Student) (Student, error)
+	// ReplaceStudent 替换 ID 为 s.ID 的学生的姓名；s.Grades 不为 nil 时同时替换全部成绩
+	ReplaceStudent(ctx context.Context, s Student) (Student, error)
+	// DeleteStudent 删除 ID 为 id 的学生
+	DeleteStudent(ctx context.Context, id int) error
+	// AddGrade 为 ID 为 id 的学生添加一条成绩，成绩的 ID 由存储分配
+	AddGrade(ctx context.Context, id int, g Grade) (Grade, error)
+	// ReplaceGrade 替换 ID 为 id 的学生的 ID 为 g.ID 的成绩
+	ReplaceGrade(ctx context.Context, id int, g Grade) (Grade, error)
+	// DeleteGrade 删除 ID 为 id 的学生的 ID 为 gradeID 的成绩
+	DeleteGrade(ctx context.Context, id, gradeID int) error
+	// History 返回 ID 为 id 的学生的所有事件，按发生的顺序排列。学生被删除后事件仍然保留。
+	History(id int) ([]Event, error)
+	// Catalog 返回课程目录
+	Catalog() (Catalog, error)
+	// CreateTerm 添加一个学期，ID 由存储分配
+	CreateTerm(t Term) (Term, error)
+	// CreateCourse 添加一门课程，ID 由存储分配
+	CreateCourse(c Course) (Course, error)
+	// CreateSection 添加一个教学班，ID 由存储分配，所属的课程和学期必须存在
+	CreateSection(s Section) (Section, error)
+	// Enroll 让学生选修一个教学班，ID 由存储分配
+	Enroll(e Enrollment) (Enrollment, error)
+	// Unenroll 删除 ID 为 id 的选课记录，还有成绩关联到这条记录时返回 ErrConflict
+	Unenroll(id int) error
+	// Apply 在一次修改中执行 b 中的全部修改，任何一个失败时都不修改数据
+	Apply(ctx context.Context, b *Batch) error
+	// Version 返回数据的版本号，每次修改成功后都会增加，用于判断缓存的计算结果是否过期
+	Version() uint64
+	// Watch 注册一个在修改成功后按发生顺序接收新事件的函数。fn 在持有存储的写锁时被调用，
+	// 不能阻塞，也不能再调用存储的方法。
+	Watch(fn func(Event))
+	// Close 释放存储占用的资源
+	Close() error
+}
+
+// MemoryStore 是保存在内存中的 Store，进程退出后数据会丢失。
+// 读取只持有读锁，可以并发进行；修改持有写锁，且只在修改内存数据（文件存储还包括写日志）期间持有。
+type MemoryStore struct {
+	mutex   sync.RWMutex
+	data    dataset
+	version uint64
+	// persist 在修改生效前被调用，返回错误时修改不会生效。文件存储用它写日志。
+	persist func(current dataset, m mutation) error
+	// watchers 是 Watch 注册的函数
+	watchers []func(Event)
+}
+
+// dataset 是存储中的全部数据
+type dataset struct {
+	Students Students
+	Catalog
+	// Events 是学生和成绩的修改历史，只会追加
+	Events []Event
+	// LastStudentID 是分配过的最大学生 ID，LastGradeIDs 是每个学生分配过的最大成绩 ID。
+	// 已删除的学生和成绩的 ID 不会被重用，分配新 ID 时不需要扫描历史。
+	LastStudentID int
+	LastGradeIDs  map[int]int
+}
+
+// NewMemoryStore 使用 ss 的副本创建内存存储
+func NewMemoryStore(ss Students) *MemoryStore {
+	ms := &MemoryStore{}
+	ms.data.apply(seedMutation(ss))
+	return ms
+}
+
+// seedMutation 返回用 ss 初始化存储的快照，每个学生都会记录一条由 system 创建的事件
+func seedMutation(ss Students) mutation {
+	return mutation{Op: opSnapshot, Students: ss, Time: time.Now().UTC(), Actor: systemActor, Reason: "seed"}
+}
+
+func (ms *MemoryStore) List() (Students, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+	return ms.data.Students.clone(), nil
+}
+
+func (ms *MemoryStore) Get(id int) (Student, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+	student, err := ms.data.Students.GetByID(id)
+	if err != nil {
+		return Student{}, err
+	}
+	return student.clone(), nil
+}
+
+func (ms *MemoryStore) CreateStudent(ctx context.Context, s Student) (Student, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	s = s.clone()
+	s.ID = ms.data.nextStudentID()
+	s.assignGradeIDs()
+	if err := ms.commit(changed(ctx, mutation{Op: opCreateStudent, Student: &s})); err != nil {
+		return Student{}, err
+	}
+	return ms.stored(s.ID), nil
+}
+
+func (ms *MemoryStore) ReplaceStudent(ctx context.Context, s Student) (Student, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	if err := ms.checkVersion(ctx, s.ID); err != nil {
+		return Student{}, err
+	}
+	s, err := ms.data.replacement(s)
+	if err != nil {
+		return Student{}, err
+	}
+	if err := ms.commit(changed(ctx, mutation{Op: opReplaceStudent, Student: &s})); err != nil {
+		return Student{}, err
+	}
+	return ms.stored(s.ID), nil
+}
+
+func (ms *MemoryStore) DeleteStudent(ctx context.Context, id int) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	if err := ms.checkVersion(ctx, id); err != nil {
+		return err
+	}
+	return ms.commit(changed(ctx, mutation{Op: opDeleteStudent, ID: id}))
+}
+
+func (ms *MemoryStore) AddGrade(ctx context.Context, id int, g Grade) (Grade, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	student, err := ms.data.Students.GetByID(id)
+	if err != nil {
+		return Grade{}, err
+	}
+	if err := checkVersion(ctx, *student); err != nil {
+		return Grade{}, err
+	}
+	g.ID = ms.data.nextGradeID(student.ID)
+	if err := ms.commit(changed(ctx, mutation{Op: opAddGrade, ID: id, Grade: &g})); err != nil {
+		return Grade{}, err
+	}
+	return g, nil
+}
+
+func (ms *MemoryStore) ReplaceGrade(ctx context.Context, id int, g Grade) (Grade, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	if err := ms.checkVersion(ctx, id); err != nil {
+		return Grade{}, err
+	}
+	if err := ms.commit(changed(ctx, mutation{Op: opReplaceGrade, ID: id, Grade: &g})); err != nil {
+		return Grade{}, err
+	}
+	return g, nil
+}
+
+func (ms *MemoryStore) DeleteGrade(ctx context.Context, id, gradeID int) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	if err := ms.checkVersion(ctx, id); err != nil {
+		return err
+	}
+	return ms.commit(changed(ctx, mutation{Op: opDeleteGrade, ID: id, GradeID: gradeID}))
+}
+
+func (ms *MemoryStore) History(id int) ([]Event, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+	events := []Event{}
+	for _, e := range ms.data.Events {
+		if e.StudentID == id {
+			events = append(events, e)
+		}
+	}
+	if len(events) == 0 {
+		if _, err := ms.data.Students.GetByID(id); err != nil {
+			return nil, err
+		}
+	}
+	return events, nil
+}
+
+func (ms *MemoryStore) Catalog() (Catalog, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+	return ms.data.Catalog.clone(), nil
+}
+
+func (ms *MemoryStore) CreateTerm(t Term) (Term, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	t.ID = 1
+	for _, other := range ms.data.Terms {
+		if other.ID >= t.ID {
+			t.ID = other.ID + 1
+		}
+	}
+	return t, ms.commit(mutation{Op: opCreateTerm, Term: &t})
+}
+
+func (ms *MemoryStore) CreateCourse(c Course) (Course, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	c.ID = 1
+	for _, other := range ms.data.Courses {
+		if other.ID >= c.ID {
+			c.ID = other.ID + 1
+		}
+	}
+	return c, ms.commit(mutation{Op: opCreateCourse, Course: &c})
+}
+
+func (ms *MemoryStore) CreateSection(s Section) (Section, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	s.ID = 1
+	for _, other := range ms.data.Sections {
+		if other.ID >= s.ID {
+			s.ID = other.ID + 1
+		}
+	}
+	return s, ms.commit(mutation{Op: opCreateSection, Section: &s})
+}
+
+func (ms *MemoryStore) Enroll(e Enrollment) (Enrollment, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	e.ID = 1
+	for _, other := range ms.data.Enrollments {
+		if other.ID >= e.ID {
+			e.ID = other.ID + 1
+		}
+	}
+	return e, ms.commit(mutation{Op: opEnroll, Enrollment: &e})
+}
+
+func (ms *MemoryStore) Unenroll(id int) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	return ms.commit(mutation{Op: opUnenroll, ID: id})
+}
+
+func (ms *MemoryStore) Apply(ctx context.Context, b *Batch) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	// 在副本上依次生成并执行每个修改，后面的修改分配 ID 时能看到前面的修改
+	next := ms.data.clone()
+	batch := changed(ctx, mutation{Op: opBatch})
+	for _, m := range b.ops {
+		switch m.Op {
+		case opCreateStudent:
+			s := m.Student.clone()
+			s.ID = next.nextStudentID()
+			s.assignGradeIDs()
+			m.Student = &s
+		case opReplaceStudent:
+			s, err := next.replacement(*m.Student)
+			if err != nil {
+				return err
+			}
+			m.Student = &s
+		case opAddGrade:
+			student, err := next.Students.GetByID(m.ID)
+			if err != nil {
+				return err
+			}
+			g := *m.Grade
+			g.ID = next.nextGradeID(student.ID)
+			m.Grade = &g
+		}
+		m.Time, m.Actor, m.Reason = batch.Time, batch.Actor, batch.Reason
+		if err := next.apply(m); err != nil {
+			return err
+		}
+		batch.Batch = append(batch.Batch, m)
+	}
+	if len(batch.Batch) == 0 {
+		return nil
+	}
+	return ms.commit(batch)
+}
+
+func (ms *MemoryStore) Version() uint64 {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+	return ms.version
+}
+
+func (ms *MemoryStore) Watch(fn func(Event)) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	ms.watchers = append(ms.watchers, fn)
+}
+
+func (ms *MemoryStore) Close() error {
+	return nil
+}
+
+// Batch 是一组要在一次修改中全部完成的学生和成绩修改，由 Store.Apply 执行。
+// 每个修改仍然各自记录一条事件。
+type Batch struct {
+	ops []mutation
+}
+
+// CreateStudent 添加一个学生，ID 由存储分配
+func (b *Batch) CreateStudent(s Student) {
+	s = s.clone()
+	b.ops = append(b.ops, mutation{Op: opCreateStudent, Student: &s})
+}
+
+// ReplaceStudent 替换学生 s.ID 的姓名，s.Grades 不为 nil 时同时替换全部成绩
+func (b *Batch) ReplaceStudent(s Student) {
+	s = s.clone()
+	b.ops = append(b.ops, mutation{Op: opReplaceStudent, Student: &s})
+}
+
+// AddGrade 为学生 id 添加一条成绩，成绩的 ID 由存储分配
+func (b *Batch) AddGrade(id int, g Grade) {
+	b.ops = append(b.ops, mutation{Op: opAddGrade, ID: id, Grade: &g})
+}
+
+// ReplaceGrade 替换学生 id 的成绩 g.ID
+func (b *Batch) ReplaceGrade(id int, g Grade) {
+	b.ops = append(b.ops, mutation{Op: opReplaceGrade, ID: id, Grade: &g})
+}
+
+// checkVersion 检查学生 id 的版本是否与 ctx 中期望的版本一致，调用者需要持有 ms.mutex
+func (ms *MemoryStore) checkVersion(ctx context.Context, id int) error {
+	student, err := ms.data.Students.GetByID(id)
+	if err != nil {
+		return err
+	}
+	return checkVersion(ctx, *student)
+}
+
+// stored 返回存储中学生 id 的副本，调用者需要持有 ms.mutex
+func (ms *MemoryStore) stored(id int) Student {
+	student, _ := ms.data.Students.GetByID(id)
+	return student.clone()
+}
+
+// commit 执行修改，调用者需要持有 ms.mutex。
+// 有 persist 时先在副本上执行修改，确认可以成功后再持久化并替换当前数据。
+func (ms *MemoryStore) commit(m mutation) error {
+	recorded := len(ms.data.Events)
+	if ms.persist == nil {
+		if err := ms.data.apply(m); err != nil {
+			return err
+		}
+	} else {
+		next := ms.data.clone()
+		if err := next.apply(m); err != nil {
+			return err
+		}
+		if err := ms.persist(ms.data, m); err != nil {
+			return err
+		}
+		ms.data = next
+	}
+	ms.version++
+	for _, e := range ms.data.Events[recorded:] {
+		for _, fn := range ms.watchers {
+			fn(e)
+		}
+	}
+	return nil
+}
+
+// 修改操作的类型，也是文件存储中日志条目的类型
+const (
+	opSnapshot       = "snapshot"
+	opCreateStudent  = "createStudent"
+	opReplaceStudent = "replaceStudent"
+	opDeleteStudent  = "deleteStudent"
+	opAddGrade       = "addGrade"
+	opReplaceGrade   = "replaceGrade"
+	opDeleteGrade    = "deleteGrade"
+	opCreateTerm     = "createTerm"
+	opCreateCourse   = "createCourse"
+	opCreateSection  = "createSection"
+	opEnroll         = "enroll"
+	opUnenroll       = "unenroll"
+	opBatch          = "batch"
+)
+
+// mutation 是一次对学生数据的修改。所有 ID 在生成修改时就已确定，
+// 因此文件存储重放日志时得到的结果与最初执行时相同。
+type mutation struct {
+	Op         string      `json:"op"`
+	ID         int         `json:"id,omitempty"`
+	GradeID    int         `json:"gradeId,omitempty"`
+	Grade      *Grade      `json:"grade,omitempty"`
+	Student    *Student    `json:"student,omitempty"`
+	Term       *Term       `json:"term,omitempty"`
+	Course     *Course     `json:"course,omitempty"`
+	Section    *Section    `json:"section,omitempty"`
+	Enrollment *Enrollment `json:"enrollment,omitempty"`
+	// Time、Actor 和 Reason 记录修改发生的时间、操作者和原因，用于生成事件
+	Time   time.Time `json:"time"`
+	Actor  string    `json:"actor,omitempty"`
+	Reason string    `json:"reason,omitempty"`
+	// Students、Catalog、Events 和分配过的最大 ID 是快照中的全部数据。
+	// 没有 LastStudentID 的旧快照在加载时从历史中计算最大 ID。
+	Students      Students    `json:"students,omitempty"`
+	Catalog       *Catalog    `json:"catalog,omitempty"`
+	Events        []Event     `json:"events,omitempty"`
+	LastStudentID int         `json:"lastStudentId,omitempty"`
+	LastGradeIDs  map[int]int `json:"lastGradeIds,omitempty"`
+	// Batch 是一次 Apply 中全部生效或全部不生效的修改
+	Batch []mutation `json:"batch,omitempty"`
+}
+
+// changed 为修改 m 填上当前时间以及 ctx 中的操作者和原因
+func changed(ctx context.Context, m mutation) mutation {
+	c := ChangeFrom(ctx)
+	m.Time = time.Now().UTC()
+	m.Actor = c.Actor
+	m.Reason = c.Reason
+	return m
+}
+
+// apply 在 d 上执行修改，失败时 d 保持不变
+func (d *dataset) apply(m mutation) error {
+	ss := &d.Students
+	switch m.Op {
+	case opSnapshot:
+		*ss = m.Students.clone()
+		ss.assignGradeIDs()
+		d.Catalog = Catalog{}
+		if m.Catalog != nil {
+			d.Catalog = m.Catalog.clone()
+		}
+		d.Events = append([]Event(nil), m.Events...)
+		d.LastStudentID, d.LastGradeIDs = m.LastStudentID, make(map[int]int, len(m.LastGradeIDs))
+		for id, last := range m.LastGradeIDs {
+			d.LastGradeIDs[id] = last
+		}
+		if m.LastStudentID == 0 {
+			for _, e := range d.Events {
+				d.observeEvent(e)
+			}
+		}
+		for _, s := range *ss {
+			d.observe(s)
+		}
+		if m.Events == nil {
+			// 初始数据和加入历史记录之前的快照没有事件，为每个学生补上一条创建事件，使历史可以重放出当前的数据
+			for i := range *ss {
+				if (*ss)[i].Version == 0 {
+					(*ss)[i].Version = 1
+				}
+				s := (*ss)[i].clone()
+				d.record(m, Event{Type: StudentCreated, StudentID: s.ID, NewStudent: &s})
+			}
+		}
+	case opCreateStudent:
+		if _, err := ss.GetByID(m.Student.ID); err == nil {
+			return fmt.Errorf("student with ID %d already exists", m.Student.ID)
+		}
+		if err := d.checkEnrollments(*m.Student); err != nil {
+			return err
+		}
+		s := m.Student.clone()
+		s.Version = 1
+		*ss = append(*ss, s.clone())
+		d.observe(s)
+		d.record(m, Event{Type: StudentCreated, StudentID: s.ID, NewStudent: &s})
+	case opReplaceStudent:
+		student, err := ss.GetByID(m.Student.ID)
+		if err != nil {
+			return err
+		}
+		if err := d.checkEnrollments(*m.Student); err != nil {
+			return err
+		}
+		previous, s := student.clone(), m.Student.clone()
+		s.Version = previous.Version + 1
+		*student = s.clone()
+		d.observe(s)
+		d.record(m, Event{Type: StudentUpdated, StudentID: s.ID, PreviousStudent: &previous, NewStudent: &s})
+	case opDeleteStudent:
+		for i := range *ss {
+			if (*ss)[i].ID == m.ID {
+				previous := (*ss)[i].clone()
+				*ss = append((*ss)[:i:i], (*ss)[i+1:]...)
+				// 学生的选课记录一并删除
+				enrollments := d.Enrollments[:0:0]
+				for _, e := range d.Enrollments {
+					if e.StudentID != m.ID {
+						enrollments = append(enrollments, e)
+					}
+				}
+				d.Enrollments = enrollments
+				d.record(m, Event{Type: StudentDeleted, StudentID: m.ID, PreviousStudent: &previous})
+				return nil
+			}
+		}
+		return fmt.Errorf("student with ID %d %w", m.ID, ErrNotFound)
+	case opAddGrade:
+		student, err := ss.GetByID(m.ID)
+		if err != nil {
+			return err
+		}
+		if err := d.checkEnrollment(student.ID, *m.Grade, "EnrollmentID"); err != nil {
+			return err
+		}
+		student.Grades = append(student.Grades, *m.Grade)
+		student.Version++
+		d.observeGrade(student.ID, m.Grade.ID)
+		g := *m.Grade
+		d.record(m, Event{Type: GradeAdded, StudentID: m.ID, GradeID: g.ID, New: &g})
+	case opReplaceGrade:
+		student, err := ss.GetByID(m.ID)
+		if err != nil {
+			return err
+		}
+		grade, err := student.GradeByID(m.Grade.ID)
+		if err != nil {
+			return err
+		}
+		if err := d.checkEnrollment(student.ID, *m.Grade, "EnrollmentID"); err != nil {
+			return err
+		}
+		previous, g := *grade, *m.Grade
+		*grade = *m.Grade
+		student.Version++
+		d.record(m, Event{Type: GradeUpdated, StudentID: m.ID, GradeID: g.ID, Previous: &previous, New: &g})
+	case opDeleteGrade:
+		student, err := ss.GetByID(m.ID)
+		if err != nil {
+			return err
+		}
+		for i := range student.Grades {
+			if student.Grades[i].ID == m.GradeID {
+				previous := student.Grades[i]
+				student.Grades = append(student.Grades[:i:i], student.Grades[i+1:]...)
+				student.Version++
+				d.record(m, Event{Type: GradeDeleted, StudentID: m.ID, GradeID: m.GradeID, Previous: &previous})
+				return nil
+			}
+		}
+		return fmt.Errorf("grade with ID %d of student %d %w", m.GradeID, m.ID, ErrNotFound)
+	case opBatch:
+		next := d.clone()
+		for _, sub := range m.Batch {
+			if err := next.apply(sub); err != nil {
+				return err
+			}
+		}
+		*d = next
+	case opCreateTerm:
+		d.Terms = append(d.Terms, *m.Term)
+	case opCreateCourse:
+		d.Courses = append(d.Courses, *m.Course)
+	case opCreateSection:
+		var verr ValidationError
+		if _, err := d.Course(m.Section.CourseID); err != nil {
+			verr.add("CourseID", "no such course")
+		}
+		if _, err := d.Term(m.Section.TermID); err != nil {
+			verr.add("TermID", "no such term")
+		}
+		if err := verr.err(); err != nil {
+			return err
+		}
+		d.Sections = append(d.Sections, *m.Section)
+	case opEnroll:
+		var verr ValidationError
+		if _, err := ss.GetByID(m.Enrollment.StudentID); err != nil {
+			return err
+		}
+		if _, err := d.Section(m.Enrollment.SectionID); err != nil {
+			verr.add("SectionID", "no such section")
+			return verr.err()
+		}
+		for _, e := range d.Enrollments {
+			if e.StudentID == m.Enrollment.StudentID && e.SectionID == m.Enrollment.SectionID {
+				return fmt.Errorf("student %d is already enrolled in section %d: %w", e.StudentID, e.SectionID, ErrConflict)
+			}
+		}
+		d.Enrollments = append(d.Enrollments, *m.Enrollment)
+	case opUnenroll:
+		for i, e := range d.Enrollments {
+			if e.ID != m.ID {
+				continue
+			}
+			if student, err := ss.GetByID(e.StudentID); err == nil {
+				for _, g := range student.Grades {
+					if g.EnrollmentID == e.ID {
+						return fmt.Errorf("enrollment %d still has grades: %w", e.ID, ErrConflict)
+					}
+				}
+			}
+			d.Enrollments = append(d.Enrollments[:i:i], d.Enrollments[i+1:]...)
+			return nil
+		}
+		return fmt.Errorf("enrollment with ID %d %w", m.ID, ErrNotFound)
+	default:
+		return fmt.Errorf("unknown operation %q", m.Op)
+	}
+	return nil
+}
+
+// replacement 返回替换学生 s.ID 时实际写入的学生：s.Grades 为 nil 时保留原有的成绩，
+// 否则为新的成绩分配不会与历史中的成绩重复的 ID
+func (d *dataset) replacement(s Student) (Student, error) {
+	current, err := d.Students.GetByID(s.ID)
+	if err != nil {
+		return Student{}, err
+	}
+	s = s.clone()
+	if s.Grades == nil {
+		s.Grades = current.clone().Grades
+		return s, nil
+	}
+	next := d.nextGradeID(current.ID)
+	for i := range s.Grades {
+		if s.Grades[i].ID == 0 {
+			s.Grades[i].ID = next
+			next++
+		}
+	}
+	return s, nil
+}
+
+// checkEnrollments 检查学生的成绩关联的选课记录都属于这个学生
+func (d *dataset) checkEnrollments(s Student) error {
+	for i, g := range s.Grades {
+		if err := d.checkEnrollment(s.ID, g, fmt.Sprintf("Grades[%d].EnrollmentID", i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkEnrollment 检查成绩 g 关联的选课记录属于学生 studentID，field 是错误中使用的字段名
+func (d *dataset) checkEnrollment(studentID int, g Grade, field string) error {
+	if g.EnrollmentID == 0 {
+		return nil
+	}
+	if e, err := d.Enrollment(g.EnrollmentID); err != nil || e.StudentID != studentID {
+		return &ValidationError{Fields: []FieldError{{Field: field, Message: "no such enrollment for this student"}}}
+	}
+	return nil
+}
+
+// clone 返回全部数据的深拷贝
+func (d dataset) clone() dataset {
+	lastGradeIDs := make(map[int]int, len(d.LastGradeIDs))
+	for id, last := range d.LastGradeIDs {
+		lastGradeIDs[id] = last
+	}
+	// 事件不会被修改，只会追加，可以与原数据共用
+	return dataset{Students: d.Students.clone(), Catalog: d.Catalog.clone(), Events: d.Events, LastStudentID: d.LastStudentID, LastGradeIDs: lastGradeIDs}
+}
+
+// record 追加一条由修改 m 产生的事件
+func (d *dataset) record(m mutation, e Event) {
+	e.Seq = uint64(len(d.Events)) + 1
+	e.Time, e.Actor, e.Reason = m.Time, m.Actor, m.Reason
+	d.Events = append(d.Events, e)
+}
+
+// nextStudentID 返回下一个可用的学生 ID，已删除的学生的 ID 不会被重用
+func (d *dataset) nextStudentID() int {
+	return d.LastStudentID + 1
+}
+
+// nextGradeID 返回学生 id 下一个可用的成绩 ID，已删除的成绩的 ID 不会被重用
+func (d *dataset) nextGradeID(id int) int {
+	return d.LastGradeIDs[id] + 1
+}
+
+// observe 把学生 s 和它的成绩的 ID 计入分配过的最大 ID
+func (d *dataset) observe(s Student) {
+	if s.ID > d.LastStudentID {
+		d.LastStudentID = s.ID
+	}
+	for _, g := range s.Grades {
+		d.observeGrade(s.ID, g.ID)
+	}
+}
+
+// observeGrade 把学生 id 的成绩 gradeID 计入分配过的最大 ID
+func (d *dataset) observeGrade(id, gradeID int) {
+	if d.LastGradeIDs == nil {
+		d.LastGradeIDs = make(map[int]int)
+	}
+	if gradeID > d.LastGradeIDs[id] {
+		d.LastGradeIDs[id] = gradeID
+	}
+}
+
+// observeEvent 把事件中出现过的 ID 计入分配过的最大 ID，用于加载没有记录最大 ID 的旧快照
+func (d *dataset) observeEvent(e Event) {
+	if e.StudentID > d.LastStudentID {
+		d.LastStudentID = e.StudentID
+	}
+	d.observeGrade(e.StudentID, e.GradeID)
+	for _, s := range []*Student{e.PreviousStudent, e.NewStudent} {
+		if s != nil {
+			d.observe(*s)
+		}
+	}
+}
+
+// nextGradeID 返回学生下一个可用的成绩 ID
+func (s Student) nextGradeID() int {
+	next := 1
+	for _, g := range s.Grades {
+		if g.ID >= next {
+			next = g.ID + 1
+		}
+	}
+	return next
+}
+
+// assignGradeIDs 为没有 ID 的成绩分配 ID，用于加载旧数据和示例数据
+func (ss Students) assignGradeIDs() {
+	for i := range ss {
+		ss[i].assignGradeIDs()
+	}
+}
+
+// assignGradeIDs 为学生没有 ID 的成绩分配 ID
+func (s *Student) assignGradeIDs() {
+	for i := range s.Grades {
+		if s.Grades[i].ID == 0 {
+			s.Grades[i].ID = s.nextGradeID()
+		}
+	}
+}
+
+// clone 返回学生数据的深拷贝
+func (ss Students) clone() Students {
+	if ss == nil {
+		return nil
+	}
+	result := make(Students, len(ss))
+	for i := range ss {
+		result[i] = ss[i].clone()
+	}
+	return result
+}
+
+// clone 返回学生的深拷贝
+func (s Student) clone() Student {
+	if s.Grades != nil {
+		s.Grades = append([]Grade{}, s.Grades...)
+	}
+	return s
+}
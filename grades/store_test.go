@@ -0,0 +1,332 @@
+package grades
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testSeed 是测试使用的初始数据
+func testSeed() Students {
+	return Students{
+		{ID: 1, FirstName: "Ada", LastName: "Lovelace", Grades: []Grade{{ID: 1, Title: "Quiz 1", Type: GradeQuiz, Score: 90}}},
+		{ID: 2, FirstName: "Alan", LastName: "Turing"},
+	}
+}
+
+// mutate 在 store 上执行一组覆盖各种修改的操作
+func mutate(t *testing.T, store Store) {
+	t.Helper()
+	ctx := WithChange(context.Background(), Change{Actor: "tester", Reason: "test"})
+	created, err := store.CreateStudent(ctx, Student{FirstName: "Grace", LastName: "Hopper"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := store.AddGrade(ctx, created.ID, Grade{Title: "Exam", Type: GradeExam, Score: 75})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.Score = 80
+	if _, err := store.ReplaceGrade(ctx, created.ID, g); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeleteGrade(ctx, 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeleteStudent(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	term, err := store.CreateTerm(Term{Name: "2026 秋"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	course, err := store.CreateCourse(Course{Code: "CS101", Title: "Programming"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	section, err := store.CreateSection(Section{CourseID: course.ID, TermID: term.ID, Name: "A"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Enroll(Enrollment{StudentID: created.ID, SectionID: section.ID}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// snapshot 把存储中的全部数据编码为 JSON，用于比较两个存储的内容
+func snapshot(t *testing.T, store Store) []byte {
+	t.Helper()
+	students, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	catalog, err := store.Catalog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	history := make(map[int][]Event)
+	for id := 1; id <= 3; id++ {
+		events, err := store.History(id)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			t.Fatal(err)
+		}
+		history[id] = events
+	}
+	data, err := json.Marshal(struct {
+		Students Students
+		Catalog  Catalog
+		History  map[int][]Event
+	}{students, catalog, history})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore(testSeed())
+	mutate(t, store)
+	if _, err := store.Get(2); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get of a deleted student returned %v, want ErrNotFound", err)
+	}
+	ada, err := store.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ada.Grades) != 0 {
+		t.Fatalf("deleted grade is still there: %+v", ada.Grades)
+	}
+	// 已删除的学生和成绩的 ID 不会被重用
+	ctx := context.Background()
+	created, err := store.CreateStudent(ctx, Student{FirstName: "Edsger", LastName: "Dijkstra"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.ID != 4 {
+		t.Fatalf("new student has ID %d, want 4", created.ID)
+	}
+	g, err := store.AddGrade(ctx, 1, Grade{Title: "Quiz 2", Type: GradeQuiz, Score: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.ID != 2 {
+		t.Fatalf("new grade has ID %d, want 2", g.ID)
+	}
+	// 返回的数据是副本
+	ada.Grades = append(ada.Grades, Grade{ID: 99})
+	if got, _ := store.Get(1); len(got.Grades) != 1 {
+		t.Fatalf("modifying a returned student changed the store: %+v", got.Grades)
+	}
+}
+
+func TestFileStoreReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "grades.journal")
+	store, err := OpenFileStore(path, testSeed())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mutate(t, store)
+	want := snapshot(t, store)
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.CreateStudent(context.Background(), Student{FirstName: "Late"}); err == nil {
+		t.Fatal("a closed file store accepted a change")
+	}
+
+	// 重新打开时 seed 被忽略，数据来自日志
+	store, err = OpenFileStore(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if got := snapshot(t, store); !bytes.Equal(got, want) {
+		t.Fatalf("reopened store has\n%s\nwant\n%s", got, want)
+	}
+	created, err := store.CreateStudent(context.Background(), Student{FirstName: "Edsger"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.ID != 4 {
+		t.Fatalf("new student after reopening has ID %d, want 4", created.ID)
+	}
+}
+
+// journalLines 返回日志文件的行数
+func journalLines(t *testing.T, path string) int {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Count(data, []byte("\n"))
+}
+
+func TestFileStoreCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "grades.journal")
+	store, err := OpenFileStore(path, testSeed())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	store.compactSize = 1
+	ctx := context.Background()
+	const writes = 50
+	compactions := 0
+	for i := 0; i < writes; i++ {
+		before := journalLines(t, path)
+		if _, err := store.AddGrade(ctx, 2, Grade{Title: "Quiz", Type: GradeQuiz, Score: float32(i)}); err != nil {
+			t.Fatal(err)
+		}
+		if journalLines(t, path) <= before {
+			compactions++
+		}
+	}
+	// 快照之后追加的内容超过快照本身时才压缩，而不是快照一旦超过 compactSize 就每次写入都压缩
+	if compactions == 0 || compactions > writes/4 {
+		t.Fatalf("the journal was compacted %d times in %d writes", compactions, writes)
+	}
+	if store.size-store.snapshotSize >= store.snapshotSize {
+		t.Fatalf("journal has %d bytes after a snapshot of %d bytes, it should have been compacted", store.size-store.snapshotSize, store.snapshotSize)
+	}
+	want := snapshot(t, store)
+	store.Close()
+
+	store, err = OpenFileStore(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := snapshot(t, store); !bytes.Equal(got, want) {
+		t.Fatalf("reopened compacted store has\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestFileStoreTornLastLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "grades.journal")
+	store, err := OpenFileStore(path, testSeed())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mutate(t, store)
+	want := snapshot(t, store)
+	store.Close()
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"Op":"createStudent","Student":{"FirstN`)
+	f.Close()
+
+	store, err = OpenFileStore(path, nil)
+	if err != nil {
+		t.Fatalf("open a journal with a torn last line: %v", err)
+	}
+	if got := snapshot(t, store); !bytes.Equal(got, want) {
+		t.Fatalf("store with a torn last line has\n%s\nwant\n%s", got, want)
+	}
+	// 之后的修改从新的一行开始，重新打开时可以读出
+	if _, err := store.CreateStudent(context.Background(), Student{FirstName: "Edsger"}); err != nil {
+		t.Fatal(err)
+	}
+	want = snapshot(t, store)
+	store.Close()
+	store, err = OpenFileStore(path, nil)
+	if err != nil {
+		t.Fatalf("reopen after writing past a torn line: %v", err)
+	}
+	defer store.Close()
+	if got := snapshot(t, store); !bytes.Equal(got, want) {
+		t.Fatalf("reopened store has\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestFileStoreCorruptLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "grades.journal")
+	store, err := OpenFileStore(path, testSeed())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("not json\n")
+	f.WriteString(`{"Op":"deleteStudent","ID":2}` + "\n")
+	f.Close()
+	if store, err := OpenFileStore(path, nil); err == nil {
+		store.Close()
+		t.Fatal("opened a journal with a corrupt line before other changes")
+	}
+}
+
+func TestFileStoreKeepsIDsAcrossCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "grades.journal")
+	store, err := OpenFileStore(path, testSeed())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mutate(t, store)
+	ctx := context.Background()
+	if err := store.DeleteStudent(ctx, 3); err != nil {
+		t.Fatal(err)
+	}
+	store.mutex.Lock()
+	err = store.compact(store.data)
+	store.mutex.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	store, err = OpenFileStore(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	created, err := store.CreateStudent(ctx, Student{FirstName: "Edsger"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.ID != 4 {
+		t.Fatalf("new student after compaction has ID %d, want 4", created.ID)
+	}
+	g, err := store.AddGrade(ctx, 1, Grade{Title: "Quiz 2", Type: GradeQuiz, Score: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.ID != 2 {
+		t.Fatalf("new grade after compaction has ID %d, want 2", g.ID)
+	}
+}
+
+func TestFileStoreLegacySnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "grades.journal")
+	// 记录最大 ID 之前的快照：学生 5 已被删除，学生 1 的成绩 3 已被删除
+	legacy := `{"op":"snapshot","time":"2026-01-01T00:00:00Z","students":[{"ID":1,"FirstName":"Ada","LastName":"Lovelace","Grades":[{"ID":1,"Title":"Quiz","Type":"Quiz","Score":90}]}],` +
+		`"events":[{"seq":1,"type":"gradeDeleted","studentId":1,"gradeId":3},{"seq":2,"type":"studentDeleted","studentId":5}]}` + "\n"
+	if err := os.WriteFile(path, []byte(legacy), 0600); err != nil {
+		t.Fatal(err)
+	}
+	store, err := OpenFileStore(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+	created, err := store.CreateStudent(ctx, Student{FirstName: "Edsger"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := store.AddGrade(ctx, 1, Grade{Title: "Quiz 2", Type: GradeQuiz, Score: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.ID != 6 || g.ID != 4 {
+		t.Fatalf("new IDs are student %d and grade %d, want 6 and 4", created.ID, g.ID)
+	}
+}
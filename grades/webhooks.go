@@ -0,0 +1,413 @@
+package grades
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 推送事件时使用的请求头
+const (
+	// SignatureHeader 的值为 "sha256=" 加上用订阅密钥对请求体计算的 HMAC-SHA256 的十六进制，见 VerifySignature
+	SignatureHeader = "X-Grades-Signature"
+	// EventHeader 是事件的类型
+	EventHeader = "X-Grades-Event"
+	// DeliveryHeader 是事件的序号，重试时保持不变，订阅者可以用它去重
+	DeliveryHeader = "X-Grades-Delivery"
+)
+
+// 推送失败时的重试策略
+const (
+	maxDeliveryAttempts = 5
+	initialBackoff      = 500 * time.Millisecond
+)
+
+// maxPendingEvents 是每个订阅者最多积压的事件数，超过时丢弃最早的等待推送的事件
+const maxPendingEvents = 1000
+
+// Subscription 是一个接收事件推送的 webhook
+type Subscription struct {
+	ID  int    `json:"id"`
+	URL string `json:"url"`
+	// Secret 是签名用的密钥，创建时为空则由服务生成。只在创建订阅的响应中返回。
+	Secret string `json:"secret,omitempty"`
+	// Types 是要接收的事件类型，为空时接收全部事件
+	Types []EventType `json:"types,omitempty"`
+}
+
+// Validate 检查订阅的地址和事件类型
+func (s Subscription) Validate() error {
+	verr := &ValidationError{}
+	if u, err := url.Parse(s.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		verr.add("url", "must be an absolute http or https URL")
+	}
+	for i, t := range s.Types {
+		switch t {
+		case StudentCreated, StudentUpdated, StudentDeleted, GradeAdded, GradeUpdated, GradeDeleted:
+		default:
+			verr.add(fmt.Sprintf("types[%d]", i), fmt.Sprintf("unknown event type %q", t))
+		}
+	}
+	return verr.err()
+}
+
+// wants 判断订阅是否接收类型为 t 的事件
+func (s Subscription) wants(t EventType) bool {
+	if len(s.Types) == 0 {
+		return true
+	}
+	for _, want := range s.Types {
+		if want == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Sign 返回用 secret 对 body 计算的签名，即 SignatureHeader 的值
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature 供订阅者检查推送请求的 SignatureHeader 是否由持有 secret 的成绩服务生成
+func VerifySignature(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
+
+// Publisher 把存储中的新事件推送给订阅者。
+// 同一订阅者收到的同一学生的事件与发生的顺序相同：前一个事件推送成功或放弃之后才会推送下一个，
+// 不同学生的事件并发推送。推送失败时以指数退避重试，最多尝试 5 次，仍然失败的事件会被记录并丢弃。
+// 每个订阅者最多积压 1000 个事件，订阅者长时间不可用时最早的等待推送的事件会被记录并丢弃。
+// 订阅只保存在内存中，服务重启后订阅者需要重新订阅。
+type Publisher struct {
+	mutex  sync.Mutex
+	nextID int
+	subs   map[int]*subscriber
+	client *http.Client
+	done   chan struct{}
+	bg     sync.WaitGroup
+	// maxPending 是每个订阅者最多积压的事件数
+	maxPending int
+}
+
+// subscriber 是一个订阅及其待推送的事件
+type subscriber struct {
+	Subscription
+	// pending 是每个学生待推送的事件，第一个是正在推送的事件
+	pending map[int][]Event
+	// queued 是 pending 中的事件总数
+	queued int
+}
+
+// dropOldest 丢弃等待推送的事件中最早的一个，正在推送的事件不会被丢弃。没有可以丢弃的事件时返回 false。
+func (s *subscriber) dropOldest() (Event, bool) {
+	oldest := -1
+	for studentID, queue := range s.pending {
+		if len(queue) > 1 && (oldest < 0 || queue[1].Seq < s.pending[oldest][1].Seq) {
+			oldest = studentID
+		}
+	}
+	if oldest < 0 {
+		return Event{}, false
+	}
+	queue := s.pending[oldest]
+	e := queue[1]
+	s.pending[oldest] = append(queue[:1], queue[2:]...)
+	s.queued--
+	return e, true
+}
+
+// NewPublisher 创建一个推送 store 中新事件的 Publisher
+func NewPublisher(store Store) *Publisher {
+	p := &Publisher{
+		subs:       make(map[int]*subscriber),
+		client:     &http.Client{Timeout: 5 * time.Second},
+		done:       make(chan struct{}),
+		maxPending: maxPendingEvents,
+	}
+	store.Watch(p.publish)
+	return p
+}
+
+// Subscribe 添加一个订阅，返回带有 ID 和密钥的订阅
+func (p *Publisher) Subscribe(s Subscription) (Subscription, error) {
+	if err := s.Validate(); err != nil {
+		return Subscription{}, err
+	}
+	if s.Secret == "" {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return Subscription{}, err
+		}
+		s.Secret = hex.EncodeToString(key)
+	}
+	s.Types = append([]EventType(nil), s.Types...)
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.nextID++
+	s.ID = p.nextID
+	p.subs[s.ID] = &subscriber{Subscription: s, pending: make(map[int][]Event)}
+	return s, nil
+}
+
+// Unsubscribe 删除 ID 为 id 的订阅，尚未推送的事件会被丢弃
+func (p *Publisher) Unsubscribe(id int) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if _, ok := p.subs[id]; !ok {
+		return fmt.Errorf("subscription with ID %d: %w", id, ErrNotFound)
+	}
+	delete(p.subs, id)
+	return nil
+}
+
+// Subscriptions 返回所有订阅，不包括密钥
+func (p *Publisher) Subscriptions() []Subscription {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	result := make([]Subscription, 0, len(p.subs))
+	for _, sub := range p.subs {
+		s := sub.Subscription
+		s.Secret = ""
+		result = append(result, s)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result
+}
+
+// Close 停止推送，尚未推送的事件会被丢弃
+func (p *Publisher) Close() {
+	p.mutex.Lock()
+	select {
+	case <-p.done:
+	default:
+		close(p.done)
+	}
+	p.mutex.Unlock()
+	p.bg.Wait()
+}
+
+// publish 把事件放入接收它的订阅者的队列，由存储在提交修改后调用。
+// 订阅者积压的事件达到上限时丢弃其中最早的等待推送的事件，全部是正在推送的事件时丢弃 e。
+func (p *Publisher) publish(e Event) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	select {
+	case <-p.done:
+		return
+	default:
+	}
+	for _, sub := range p.subs {
+		if !sub.wants(e.Type) {
+			continue
+		}
+		if sub.queued >= p.maxPending {
+			dropped, ok := sub.dropOldest()
+			if !ok {
+				dropped = e
+			}
+			log.Printf("subscription %d at %s has reached %d pending events, dropping event %d (%s) of student %d",
+				sub.ID, sub.URL, p.maxPending, dropped.Seq, dropped.Type, dropped.StudentID)
+			if !ok {
+				continue
+			}
+		}
+		queue := sub.pending[e.StudentID]
+		sub.pending[e.StudentID] = append(queue, e)
+		sub.queued++
+		if len(queue) == 0 {
+			// 该学生没有正在推送的事件，启动一个按顺序推送的任务
+			p.bg.Add(1)
+			go p.drain(sub, e.StudentID)
+		}
+	}
+}
+
+// drain 按顺序推送订阅者 sub 中学生 studentID 的事件，直到队列为空
+func (p *Publisher) drain(sub *subscriber, studentID int) {
+	defer p.bg.Done()
+	for {
+		p.mutex.Lock()
+		if p.subs[sub.ID] != sub {
+			p.mutex.Unlock()
+			return
+		}
+		e := sub.pending[studentID][0]
+		p.mutex.Unlock()
+		if !p.deliver(sub.Subscription, e) {
+			return
+		}
+		p.mutex.Lock()
+		queue := sub.pending[studentID][1:]
+		sub.queued--
+		if len(queue) == 0 {
+			delete(sub.pending, studentID)
+			p.mutex.Unlock()
+			return
+		}
+		sub.pending[studentID] = queue
+		p.mutex.Unlock()
+	}
+}
+
+// deliver 推送一个事件，失败时重试。Publisher 关闭或订阅被删除时返回 false。
+func (p *Publisher) deliver(s Subscription, e Event) bool {
+	data, err := json.Marshal(e)
+	if err != nil {
+		log.Println(err)
+		return true
+	}
+	backoff := initialBackoff
+	for attempt := 1; ; attempt++ {
+		if err = p.post(s, e, data); err == nil {
+			return true
+		}
+		if attempt == maxDeliveryAttempts {
+			break
+		}
+		select {
+		case <-p.done:
+			return false
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if !p.subscribed(s.ID) {
+			return false
+		}
+	}
+	log.Printf("failed to deliver event %d (%s) to subscription %d at %s: %v", e.Seq, e.Type, s.ID, s.URL, err)
+	return true
+}
+
+// subscribed 判断 ID 为 id 的订阅是否还存在
+func (p *Publisher) subscribed(id int) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	_, ok := p.subs[id]
+	return ok
+}
+
+// post 发送一次推送请求，订阅者返回 2xx 时视为成功
+func (p *Publisher) post(s Subscription, e Event, data []byte) error {
+	req, err := http.NewRequest(http.MethodPost, s.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, string(e.Type))
+	req.Header.Set(DeliveryHeader, strconv.FormatUint(e.Seq, 10))
+	req.Header.Set(SignatureHeader, Sign(s.Secret, data))
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("subscriber responded with %v", res.StatusCode)
+	}
+	return nil
+}
+
+// subscriptionsHandler 处理订阅接口，只有管理员可以访问：
+//
+//	GET, POST   /subscriptions
+//	GET, DELETE /subscriptions/{id}
+type subscriptionsHandler struct {
+	events *Publisher
+}
+
+func (sh subscriptionsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := requireRole(r, RoleAdmin); err != nil {
+		writeError(w, err)
+		return
+	}
+	segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(segments) == 1 && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, sh.events.Subscriptions())
+	case len(segments) == 1 && r.Method == http.MethodPost:
+		var s Subscription
+		if err := decodeBody(r, &s); err != nil {
+			writeError(w, err)
+			return
+		}
+		s, err := sh.events.Subscribe(s)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Location", fmt.Sprintf("/subscriptions/%d", s.ID))
+		writeJSON(w, http.StatusCreated, s)
+	case len(segments) == 2:
+		id, err := strconv.Atoi(segments[1])
+		if err != nil {
+			writeError(w, errRouteNotFound)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			for _, s := range sh.events.Subscriptions() {
+				if s.ID == id {
+					writeJSON(w, http.StatusOK, s)
+					return
+				}
+			}
+			writeError(w, fmt.Errorf("subscription with ID %d: %w", id, ErrNotFound))
+		case http.MethodDelete:
+			if err := sh.events.Unsubscribe(id); err != nil {
+				writeError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			writeError(w, errMethodNotAllowed)
+		}
+	case len(segments) == 1:
+		writeError(w, errMethodNotAllowed)
+	default:
+		writeError(w, errRouteNotFound)
+	}
+}
+
+// Subscribe 在 serviceURL 所在的成绩服务上订阅事件，返回带有 ID 和密钥的订阅。
+// 订阅者通常把 GradingService 列为依赖的服务，通过注册中心得到 serviceURL，例如 registry.GetProvider(registry.GradingService)。
+// token 为启用认证的成绩服务的管理员令牌，未启用认证时为空。
+func Subscribe(serviceURL, token string, s Subscription) (Subscription, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return Subscription{}, err
+	}
+	req, err := http.NewRequest(http.MethodPost, serviceURL+"/subscriptions", bytes.NewReader(data))
+	if err != nil {
+		return Subscription{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return Subscription{}, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusCreated {
+		return Subscription{}, fmt.Errorf("failed to subscribe. Grading service responded with %v", res.StatusCode)
+	}
+	var created Subscription
+	err = json.NewDecoder(res.Body).Decode(&created)
+	return created, err
+}
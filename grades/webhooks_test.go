@@ -0,0 +1,209 @@
+package grades
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testSubscriber 是记录收到的事件的订阅者
+type testSubscriber struct {
+	t      *testing.T
+	secret string
+	// handle 在校验签名之后被调用，返回订阅者的响应状态码
+	handle func(e Event) int
+
+	mutex    sync.Mutex
+	received map[int][]uint64
+	attempts map[uint64]int
+}
+
+func (s *testSubscriber) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		s.t.Error(err)
+		return
+	}
+	if !VerifySignature(s.secret, body, r.Header.Get(SignatureHeader)) {
+		s.t.Errorf("delivery %s has an invalid signature", r.Header.Get(DeliveryHeader))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	var e Event
+	if err := json.Unmarshal(body, &e); err != nil {
+		s.t.Error(err)
+		return
+	}
+	if got := r.Header.Get(DeliveryHeader); got != strconv.FormatUint(e.Seq, 10) {
+		s.t.Errorf("%s = %q for event %d", DeliveryHeader, got, e.Seq)
+	}
+	if got := r.Header.Get(EventHeader); got != string(e.Type) {
+		s.t.Errorf("%s = %q for a %s event", EventHeader, got, e.Type)
+	}
+	s.mutex.Lock()
+	s.attempts[e.Seq]++
+	s.mutex.Unlock()
+	status := http.StatusNoContent
+	if s.handle != nil {
+		status = s.handle(e)
+	}
+	if status < 300 {
+		s.mutex.Lock()
+		s.received[e.StudentID] = append(s.received[e.StudentID], e.Seq)
+		s.mutex.Unlock()
+	}
+	w.WriteHeader(status)
+}
+
+// delivered 返回学生 studentID 已经成功推送的事件序号
+func (s *testSubscriber) delivered(studentID int) []uint64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]uint64(nil), s.received[studentID]...)
+}
+
+// waitFor 等待直到 cond 为真
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// subscribe 创建一个订阅者并订阅 p 的事件
+func subscribe(t *testing.T, p *Publisher, handle func(Event) int) *testSubscriber {
+	t.Helper()
+	sub := &testSubscriber{t: t, secret: "s3cret", handle: handle, received: make(map[int][]uint64), attempts: make(map[uint64]int)}
+	srv := httptest.NewServer(sub)
+	t.Cleanup(srv.Close)
+	if _, err := p.Subscribe(Subscription{URL: srv.URL, Secret: sub.secret}); err != nil {
+		t.Fatal(err)
+	}
+	return sub
+}
+
+// addGrades 为学生 studentID 添加 n 个成绩，返回对应事件的序号
+func addGrades(t *testing.T, store Store, studentID, n int) []uint64 {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if _, err := store.AddGrade(context.Background(), studentID, Grade{Title: "Quiz", Type: GradeQuiz, Score: float32(60 + i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	events, err := store.History(studentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var seqs []uint64
+	for _, e := range events[len(events)-n:] {
+		seqs = append(seqs, e.Seq)
+	}
+	return seqs
+}
+
+func equalSeqs(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestVerifySignature(t *testing.T) {
+	body := []byte(`{"seq":1}`)
+	signature := Sign("s3cret", body)
+	if !VerifySignature("s3cret", body, signature) {
+		t.Fatal("signature does not verify")
+	}
+	if VerifySignature("other", body, signature) {
+		t.Fatal("signature verifies with another secret")
+	}
+	if VerifySignature("s3cret", []byte(`{"seq":2}`), signature) {
+		t.Fatal("signature verifies for another body")
+	}
+}
+
+func TestPublisherDelivery(t *testing.T) {
+	store := NewMemoryStore(testSeed())
+	p := NewPublisher(store)
+	defer p.Close()
+	// 学生 1 的第一个事件第一次推送时返回 500，之后的事件要等它重试成功
+	var failOnce sync.Once
+	sub := subscribe(t, p, func(e Event) int {
+		status := http.StatusNoContent
+		if e.StudentID == 1 {
+			failOnce.Do(func() { status = http.StatusInternalServerError })
+		}
+		return status
+	})
+
+	want1 := addGrades(t, store, 1, 2)
+	want2 := addGrades(t, store, 2, 3)
+	want1 = append(want1, addGrades(t, store, 1, 2)...)
+	waitFor(t, "all events", func() bool {
+		return len(sub.delivered(1)) == len(want1) && len(sub.delivered(2)) == len(want2)
+	})
+	if got := sub.delivered(1); !equalSeqs(got, want1) {
+		t.Fatalf("student 1 received %v, want %v", got, want1)
+	}
+	if got := sub.delivered(2); !equalSeqs(got, want2) {
+		t.Fatalf("student 2 received %v, want %v", got, want2)
+	}
+	sub.mutex.Lock()
+	defer sub.mutex.Unlock()
+	if sub.attempts[want1[0]] != 2 {
+		t.Fatalf("event %d was attempted %d times, want a retry after the 500", want1[0], sub.attempts[want1[0]])
+	}
+}
+
+func TestPublisherDropsOldestPending(t *testing.T) {
+	store := NewMemoryStore(testSeed())
+	p := NewPublisher(store)
+	p.maxPending = 3
+	release := make(chan struct{})
+	var releaseOnce sync.Once
+	releaseAll := func() { releaseOnce.Do(func() { close(release) }) }
+	sub := subscribe(t, p, func(e Event) int {
+		<-release
+		return http.StatusNoContent
+	})
+	defer p.Close()
+	defer releaseAll()
+
+	// 第一个事件正在推送，积压达到 3 个之后每个新事件都挤掉最早的等待推送的事件
+	seqs := addGrades(t, store, 1, 5)
+	// 学生 2 的事件挤掉学生 1 最早的等待推送的事件
+	seqs2 := addGrades(t, store, 2, 1)
+	p.mutex.Lock()
+	queued := p.subs[1].queued
+	p.mutex.Unlock()
+	if queued != 3 {
+		t.Fatalf("subscriber has %d pending events, want 3", queued)
+	}
+
+	releaseAll()
+	want := []uint64{seqs[0], seqs[4]}
+	waitFor(t, "pending events", func() bool {
+		return len(sub.delivered(1)) == len(want) && len(sub.delivered(2)) == 1
+	})
+	if got := sub.delivered(1); !equalSeqs(got, want) {
+		t.Fatalf("student 1 received %v, want %v", got, want)
+	}
+	if got := sub.delivered(2); !equalSeqs(got, seqs2) {
+		t.Fatalf("student 2 received %v, want %v", got, seqs2)
+	}
+}
@@ -0,0 +1,322 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	stlog "log"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// 告警规则的类型
+const (
+	// AlertMatch 在收到满足条件的记录时告警
+	AlertMatch = "match"
+	// AlertRate 在 Window 内满足条件的记录超过 Threshold 条时告警
+	AlertRate = "rate"
+	// AlertAbsence 在 Window 内没有收到满足条件的记录时告警，再次收到时发送恢复通知
+	AlertAbsence = "absence"
+)
+
+// AlertRule 是一条告警规则。条件字段的含义与 /log/query 的参数相同，为空时匹配所有记录。
+type AlertRule struct {
+	Name      string            `json:"name"`
+	Kind      string            `json:"kind"`
+	Service   string            `json:"service,omitempty"`
+	Level     Level             `json:"level,omitempty"`
+	Contains  string            `json:"contains,omitempty"`
+	Regex     string            `json:"regex,omitempty"`
+	Fields    map[string]string `json:"fields,omitempty"`
+	Threshold int               `json:"threshold,omitempty"`
+	Window    Duration          `json:"window,omitempty"`
+	// Cooldown 是同一规则两次告警之间的最短间隔，期间触发的告警会被合并，默认 5 分钟
+	Cooldown Duration `json:"cooldown,omitempty"`
+	Webhooks []string `json:"webhooks"`
+}
+
+// Alert 是发送到 webhook 的告警通知
+type Alert struct {
+	Rule    string    `json:"rule"`
+	Kind    string    `json:"kind"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+	// Count 是触发告警时窗口内匹配的记录数
+	Count int `json:"count,omitempty"`
+	// Suppressed 是上一次通知之后由于冷却而被合并的告警次数
+	Suppressed int `json:"suppressed,omitempty"`
+	// Resolved 为 true 表示 absence 规则期待的记录重新出现了
+	Resolved bool `json:"resolved,omitempty"`
+	// Sample 是触发告警的一条记录
+	Sample *Record `json:"sample,omitempty"`
+}
+
+// ruleState 是一条规则的运行状态
+type ruleState struct {
+	rule       AlertRule
+	filter     Filter
+	hits       []time.Time // rate 规则窗口内匹配的时间
+	lastMatch  time.Time
+	lastFired  time.Time
+	firing     bool // absence 规则已告警且尚未恢复
+	suppressed int
+}
+
+// alerter 在记录到达时评估告警规则，并异步地把告警发送到 webhook
+type alerter struct {
+	mutex  sync.Mutex
+	rules  []*ruleState
+	queue  chan delivery
+	done   chan struct{}
+	bg     sync.WaitGroup
+	client *http.Client
+}
+
+// delivery 是一次待发送的通知
+type delivery struct {
+	url   string
+	alert Alert
+}
+
+// newAlerter 检查规则并启动后台任务，没有规则时返回 nil
+func newAlerter(rules []AlertRule) (*alerter, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	a := &alerter{
+		queue:  make(chan delivery, 1000),
+		done:   make(chan struct{}),
+		client: &http.Client{Timeout: 5 * time.Second},
+	}
+	names := make(map[string]bool)
+	now := time.Now()
+	for _, rule := range rules {
+		if rule.Name == "" || names[rule.Name] {
+			return nil, fmt.Errorf("alert rule name %q is empty or duplicated", rule.Name)
+		}
+		names[rule.Name] = true
+		switch rule.Kind {
+		case AlertMatch:
+		case AlertRate:
+			if rule.Threshold <= 0 || rule.Window <= 0 {
+				return nil, fmt.Errorf("alert rule %q needs a threshold and a window", rule.Name)
+			}
+		case AlertAbsence:
+			if rule.Window <= 0 {
+				return nil, fmt.Errorf("alert rule %q needs a window", rule.Name)
+			}
+		default:
+			return nil, fmt.Errorf("alert rule %q has unknown kind %q", rule.Name, rule.Kind)
+		}
+		if len(rule.Webhooks) == 0 {
+			return nil, fmt.Errorf("alert rule %q has no webhooks", rule.Name)
+		}
+		if rule.Cooldown <= 0 {
+			rule.Cooldown = Duration(5 * time.Minute)
+		}
+		f := Filter{Service: rule.Service, Contains: rule.Contains, Fields: rule.Fields}
+		if rule.Level != "" {
+			level, err := ParseLevel(string(rule.Level))
+			if err != nil {
+				return nil, err
+			}
+			f.Level = level
+		}
+		if rule.Regex != "" {
+			pattern, err := regexp.Compile(rule.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("alert rule %q: %w", rule.Name, err)
+			}
+			f.Pattern = pattern
+		}
+		a.rules = append(a.rules, &ruleState{rule: rule, filter: f, lastMatch: now})
+	}
+	a.bg.Add(2)
+	go a.deliver()
+	go a.watchAbsence()
+	return a, nil
+}
+
+// observe 用新到达的记录评估所有规则
+func (a *alerter) observe(records []Record) {
+	if a == nil {
+		return
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	now := time.Now()
+	for _, st := range a.rules {
+		for i := range records {
+			rec := records[i]
+			if !st.filter.Match(rec) {
+				continue
+			}
+			st.lastMatch = now
+			switch st.rule.Kind {
+			case AlertMatch:
+				a.fire(st, now, Alert{Message: rec.Message, Count: 1, Sample: &rec})
+			case AlertRate:
+				window := time.Duration(st.rule.Window)
+				st.hits = append(st.hits, now)
+				for len(st.hits) > 0 && now.Sub(st.hits[0]) > window {
+					st.hits = st.hits[1:]
+				}
+				if len(st.hits) > st.rule.Threshold {
+					a.fire(st, now, Alert{
+						Message: fmt.Sprintf("%d matching records in %v, threshold %d", len(st.hits), window, st.rule.Threshold),
+						Count:   len(st.hits),
+						Sample:  &rec,
+					})
+				}
+			case AlertAbsence:
+				if st.firing {
+					st.firing = false
+					a.notify(st, Alert{Message: "expected records are arriving again", Resolved: true, Time: now, Sample: &rec})
+				}
+			}
+		}
+	}
+}
+
+// watchAbsence 定期检查 absence 规则
+func (a *alerter) watchAbsence() {
+	defer a.bg.Done()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.done:
+			return
+		case now := <-ticker.C:
+			a.mutex.Lock()
+			for _, st := range a.rules {
+				window := time.Duration(st.rule.Window)
+				if st.rule.Kind != AlertAbsence || st.firing || now.Sub(st.lastMatch) < window {
+					continue
+				}
+				st.firing = true
+				a.fire(st, now, Alert{Message: fmt.Sprintf("no matching records in the last %v", window)})
+			}
+			a.mutex.Unlock()
+		}
+	}
+}
+
+// fire 在冷却期之外发送告警，冷却期内的告警只计数，调用者需要持有 a.mutex
+func (a *alerter) fire(st *ruleState, now time.Time, alert Alert) {
+	if !st.lastFired.IsZero() && now.Sub(st.lastFired) < time.Duration(st.rule.Cooldown) {
+		st.suppressed++
+		return
+	}
+	st.lastFired = now
+	alert.Time = now
+	alert.Suppressed = st.suppressed
+	st.suppressed = 0
+	a.notify(st, alert)
+}
+
+// notify 把告警放入发送队列，队列已满时丢弃，调用者需要持有 a.mutex
+func (a *alerter) notify(st *ruleState, alert Alert) {
+	alert.Rule = st.rule.Name
+	alert.Kind = st.rule.Kind
+	for _, url := range st.rule.Webhooks {
+		select {
+		case a.queue <- delivery{url: url, alert: alert}:
+		default:
+			stlog.Printf("alert queue is full, dropping alert %s for %s", alert.Rule, url)
+		}
+	}
+}
+
+// deliver 把告警发送到 webhook，失败时最多重试 3 次
+func (a *alerter) deliver() {
+	defer a.bg.Done()
+	for {
+		select {
+		case <-a.done:
+			return
+		case d := <-a.queue:
+			data, err := json.Marshal(d.alert)
+			if err != nil {
+				stlog.Println(err)
+				continue
+			}
+			backoff := 500 * time.Millisecond
+			for attempt := 0; attempt < 3; attempt++ {
+				if err = a.post(d.url, data); err == nil {
+					break
+				}
+				select {
+				case <-a.done:
+					return
+				case <-time.After(backoff):
+				}
+				backoff *= 2
+			}
+			if err != nil {
+				stlog.Printf("failed to deliver alert %s to %s: %v", d.alert.Rule, d.url, err)
+			}
+		}
+	}
+}
+
+// post 发送一次 webhook 请求
+func (a *alerter) post(url string, data []byte) error {
+	res, err := a.client.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with %v", res.StatusCode)
+	}
+	return nil
+}
+
+// AlertStatus 是 GET /log/alerts 返回的一条规则的状态
+type AlertStatus struct {
+	AlertRule
+	LastMatch time.Time `json:"lastMatch"`
+	LastFired time.Time `json:"lastFired,omitempty"`
+	Firing    bool      `json:"firing,omitempty"`
+}
+
+// status 返回所有规则的状态
+func (a *alerter) status() []AlertStatus {
+	result := make([]AlertStatus, 0)
+	if a == nil {
+		return result
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	for _, st := range a.rules {
+		result = append(result, AlertStatus{
+			AlertRule: st.rule,
+			LastMatch: st.lastMatch,
+			LastFired: st.lastFired,
+			Firing:    st.firing,
+		})
+	}
+	return result
+}
+
+// Close 停止后台任务，队列中尚未发送的告警会被丢弃
+func (a *alerter) Close() {
+	if a == nil {
+		return
+	}
+	close(a.done)
+	a.bg.Wait()
+}
+
+// alertsHandler 处理 GET /log/alerts，返回告警规则及其状态
+func (s *Server) alertsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.alerts.status())
+}
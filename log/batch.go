@@ -0,0 +1,420 @@
+package log
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"go-distributed/registry"
+	stlog "log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ClientConfig 配置批量异步的日志客户端，零值字段使用默认值
+type ClientConfig struct {
+	// BatchSize 是一批日志的最大条数，默认 100
+	BatchSize int
+	// FlushInterval 是定时发送的周期，默认 1 秒
+	FlushInterval time.Duration
+	// QueueSize 是内存队列的长度，队列满时新的日志会被丢弃而不是阻塞调用者，默认 10000
+	QueueSize int
+	// InitialBackoff 和 MaxBackoff 是发送失败后重试间隔的初始值和上限，默认 100ms 和 30s
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// SpoolDir 是日志服务不可用时暂存日志的目录，默认为临时目录下按服务名和实例（没有 Instance 时按进程号）区分的子目录，
+	// 同一主机上的多个实例不会共用一个暂存文件
+	SpoolDir string
+	// MaxSpoolSize 是暂存文件的最大字节数，超过后丢弃新的日志，默认 64MB
+	MaxSpoolSize int64
+	// Resolve 返回当前可用的日志服务地址，默认使用 registry.GetProvider(registry.LogService)
+	Resolve func() (string, error)
+	// Level 是默认的最低日志级别，默认 INFO
+	Level Level
+	// Instance 是本服务实例的标识（通常是它的 URL），用于按实例集中配置日志级别
+	Instance string
+	// LevelPollInterval 是从日志服务获取集中配置的级别的周期，默认 10 秒，负数表示不获取
+	LevelPollInterval time.Duration
+	// Timeout 是发送一批日志的超时，默认 10 秒。超时的批次和发送失败的一样暂存并重试
+	Timeout time.Duration
+}
+
+// Client 是批量异步的日志客户端，实现了 io.Writer，可以作为标准库日志记录器的输出。
+// 日志先进入内存队列，由后台协程按条数或时间批量发送；发送失败时按退避策略重试，
+// 期间的日志暂存到磁盘，日志服务恢复后按顺序重放。
+type Client struct {
+	service  string
+	cfg      ClientConfig
+	http     *http.Client
+	queue    chan Record
+	flushReq chan chan struct{}
+	done     chan struct{}
+	// stopped 在后台发送协程处理完剩余的日志并退出后关闭
+	stopped chan struct{}
+	// closeMutex 保证 Close 关闭 done 之后不会再有日志进入队列
+	closeMutex sync.RWMutex
+	spool      *spool
+	dropped    int64
+	level      atomic.Value
+
+	// 以下字段只由后台协程访问
+	backoff time.Duration
+	retryAt time.Time
+}
+
+// NewClient 创建 service 服务使用的日志客户端并启动后台发送协程
+func NewClient(service string, cfg ClientConfig) *Client {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 100
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = time.Second
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 10000
+	}
+	if cfg.InitialBackoff <= 0 {
+		cfg.InitialBackoff = 100 * time.Millisecond
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = 30 * time.Second
+	}
+	if cfg.SpoolDir == "" {
+		cfg.SpoolDir = defaultSpoolDir("go-distributed-spool", service, cfg.Instance)
+	}
+	if cfg.MaxSpoolSize <= 0 {
+		cfg.MaxSpoolSize = 64 << 20
+	}
+	if cfg.Level == "" {
+		cfg.Level = LevelInfo
+	}
+	if cfg.LevelPollInterval == 0 {
+		cfg.LevelPollInterval = 10 * time.Second
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = requestTimeout
+	}
+	if cfg.Resolve == nil {
+		cfg.Resolve = func() (string, error) {
+			return registry.GetProvider(registry.LogService)
+		}
+	}
+	c := &Client{
+		service:  service,
+		cfg:      cfg,
+		http:     &http.Client{Timeout: cfg.Timeout},
+		queue:    make(chan Record, cfg.QueueSize),
+		flushReq: make(chan chan struct{}),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+		spool:    newSpool(filepath.Join(cfg.SpoolDir, "spool.jsonl"), cfg.MaxSpoolSize),
+	}
+	c.level.Store(cfg.Level)
+	go c.run()
+	go c.pollLevel()
+	return c
+}
+
+// Write 把一行文本日志放入发送队列，从不阻塞，也从不返回错误
+func (c *Client) Write(data []byte) (int, error) {
+	if !c.Enabled(LevelInfo) {
+		return len(data), nil
+	}
+	rec := parseText(string(data))
+	if rec.Service == "" {
+		rec.Service = c.service
+	}
+	c.Log(rec)
+	return len(data), nil
+}
+
+// Log 把一条结构化日志放入发送队列，低于最低级别、队列已满或客户端已关闭时丢弃该日志
+func (c *Client) Log(rec Record) {
+	if !c.Enabled(rec.Level) {
+		return
+	}
+	c.closeMutex.RLock()
+	defer c.closeMutex.RUnlock()
+	select {
+	case <-c.done:
+		atomic.AddInt64(&c.dropped, 1)
+		return
+	default:
+	}
+	if rec.Instance == "" {
+		rec.Instance = c.cfg.Instance
+	}
+	if rec.Time.IsZero() {
+		rec.Time = time.Now()
+	}
+	if rec.Service == "" {
+		rec.Service = c.service
+	}
+	select {
+	case c.queue <- rec:
+	default:
+		atomic.AddInt64(&c.dropped, 1)
+	}
+}
+
+// Dropped 返回由于队列已满、暂存文件已满或客户端已关闭而被丢弃的日志条数
+func (c *Client) Dropped() int64 {
+	return atomic.LoadInt64(&c.dropped)
+}
+
+// Flush 立即发送队列中已有的日志，等待发送（或暂存）完成
+func (c *Client) Flush() {
+	ack := make(chan struct{})
+	select {
+	case c.flushReq <- ack:
+		<-ack
+	case <-c.done:
+	}
+}
+
+// Close 停止接收新的日志，等待后台协程发送（或暂存）队列中剩余的全部日志后返回
+func (c *Client) Close() {
+	c.closeMutex.Lock()
+	select {
+	case <-c.done:
+	default:
+		close(c.done)
+	}
+	c.closeMutex.Unlock()
+	<-c.stopped
+}
+
+// run 是后台发送协程
+func (c *Client) run() {
+	defer close(c.stopped)
+	ticker := time.NewTicker(c.cfg.FlushInterval)
+	defer ticker.Stop()
+	pending := make([]Record, 0, c.cfg.BatchSize)
+	for {
+		select {
+		case <-c.done:
+			// Close 之前已经进入队列的日志都要发送，Log 在 done 关闭后不再入队
+			for n := len(c.queue); n > 0; n-- {
+				pending = append(pending, <-c.queue)
+			}
+			c.flush(pending)
+			return
+		case rec := <-c.queue:
+			pending = append(pending, rec)
+			if len(pending) >= c.cfg.BatchSize {
+				c.flush(pending)
+				pending = pending[:0]
+			}
+		case <-ticker.C:
+			c.flush(pending)
+			pending = pending[:0]
+		case ack := <-c.flushReq:
+			for n := len(c.queue); n > 0; n-- {
+				pending = append(pending, <-c.queue)
+			}
+			c.flush(pending)
+			pending = pending[:0]
+			close(ack)
+		}
+	}
+}
+
+// flush 发送一批日志。暂存文件中还有日志时先重放它们以保持顺序；
+// 处于退避期或发送失败时把这批日志写入暂存文件。
+func (c *Client) flush(records []Record) {
+	if len(records) == 0 && c.spool.empty() {
+		return
+	}
+	var b batch
+	if len(records) > 0 {
+		b = batch{ID: newBatchID(), Records: append([]Record(nil), records...)}
+	}
+	if time.Now().Before(c.retryAt) {
+		c.stash(b)
+		return
+	}
+	err := c.replay()
+	if err == nil && len(b.Records) > 0 {
+		err = c.send(b)
+	}
+	if err != nil {
+		c.stash(b)
+		c.fail()
+		return
+	}
+	c.backoff = 0
+	c.retryAt = time.Time{}
+}
+
+// fail 记录一次发送失败，按指数退避推迟下一次发送
+func (c *Client) fail() {
+	if c.backoff == 0 {
+		c.backoff = c.cfg.InitialBackoff
+	} else if c.backoff *= 2; c.backoff > c.cfg.MaxBackoff {
+		c.backoff = c.cfg.MaxBackoff
+	}
+	c.retryAt = time.Now().Add(c.backoff)
+}
+
+// stash 把一批日志写入暂存文件，暂存文件已满时丢弃
+func (c *Client) stash(b batch) {
+	if len(b.Records) == 0 {
+		return
+	}
+	if err := c.spool.append([]batch{b}); err != nil {
+		atomic.AddInt64(&c.dropped, int64(len(b.Records)))
+	}
+}
+
+// send 把一批日志发送到当前可用的日志服务，直到收到确认才算成功
+func (c *Client) send(b batch) error {
+	url, err := c.cfg.Resolve()
+	if err != nil {
+		return err
+	}
+	_, err = sendBatch(c.http, url, b.ID, b.Records, c.service, c.cfg.Instance)
+	return err
+}
+
+// replay 按顺序重放暂存文件中的批次，批次 ID 保持不变，因此重发已被接收的批次不会重复写入。
+// 全部发送成功后清空暂存文件，失败时保留尚未确认的部分。
+func (c *Client) replay() error {
+	if c.spool.empty() {
+		return nil
+	}
+	batches, err := c.spool.load()
+	if err != nil {
+		return err
+	}
+	for i, b := range batches {
+		if err := c.send(b); err != nil {
+			if rerr := c.spool.reset(batches[i:]); rerr != nil {
+				stlog.Println(rerr)
+			}
+			return err
+		}
+	}
+	return c.spool.reset(nil)
+}
+
+// batch 是一批带有批次 ID 的日志
+type batch struct {
+	ID      string   `json:"id"`
+	Records []Record `json:"records"`
+}
+
+// defaultSpoolDir 返回临时目录下 base/name 中的暂存目录。instance 不为空时按实例区分，重启后可以重放上次暂存的日志；
+// 否则按进程号区分。暂存文件不能由多个进程同时读写。
+func defaultSpoolDir(base, name, instance string) string {
+	key := "pid-" + strconv.Itoa(os.Getpid())
+	if instance != "" {
+		key = strings.Map(func(r rune) rune {
+			if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '.' {
+				return r
+			}
+			return '_'
+		}, instance)
+	}
+	return filepath.Join(os.TempDir(), base, name, key)
+}
+
+// spool 是一个有大小上限的磁盘暂存文件，每行一个 JSON 格式的批次
+type spool struct {
+	path    string
+	maxSize int64
+	mutex   sync.Mutex
+	size    int64
+}
+
+// newSpool 打开 path 处的暂存文件，之前进程遗留的日志会在日志服务可用时被重放
+func newSpool(path string, maxSize int64) *spool {
+	s := &spool{path: path, maxSize: maxSize}
+	if info, err := os.Stat(path); err == nil {
+		s.size = info.Size()
+	}
+	return s
+}
+
+// empty 判断暂存文件中是否没有日志
+func (s *spool) empty() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.size == 0
+}
+
+// errSpoolFull 表示暂存文件已达到大小上限
+var errSpoolFull = errors.New("log spool is full")
+
+// append 把批次追加到暂存文件末尾
+func (s *spool) append(batches []batch) error {
+	var buf []byte
+	for _, b := range batches {
+		line, err := json.Marshal(b)
+		if err != nil {
+			return err
+		}
+		buf = append(buf, line...)
+		buf = append(buf, '\n')
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.size+int64(len(buf)) > s.maxSize {
+		return errSpoolFull
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	n, err := f.Write(buf)
+	s.size += int64(n)
+	return err
+}
+
+// load 读取暂存文件中的全部批次，无法解析的行会被跳过
+func (s *spool) load() ([]batch, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var batches []batch
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var b batch
+		if err := json.Unmarshal(scanner.Bytes(), &b); err != nil || b.ID == "" {
+			continue
+		}
+		batches = append(batches, b)
+	}
+	return batches, scanner.Err()
+}
+
+// reset 用 batches 替换暂存文件的内容，batches 为空时删除暂存文件
+func (s *spool) reset(batches []batch) error {
+	s.mutex.Lock()
+	if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+		s.mutex.Unlock()
+		return err
+	}
+	s.size = 0
+	s.mutex.Unlock()
+	if len(batches) == 0 {
+		return nil
+	}
+	return s.append(batches)
+}
@@ -0,0 +1,164 @@
+package log
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeLogService 记录收到的日志，down 为 true 时返回 503
+type fakeLogService struct {
+	mutex    sync.Mutex
+	down     bool
+	messages []string
+	batchIDs []string
+}
+
+func (f *fakeLogService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.down {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	var records []Record
+	if err := json.NewDecoder(r.Body).Decode(&records); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	id := r.Header.Get(BatchIDHeader)
+	f.batchIDs = append(f.batchIDs, id)
+	for _, rec := range records {
+		f.messages = append(f.messages, rec.Message)
+	}
+	json.NewEncoder(w).Encode(Ack{BatchID: id, Seq: uint64(len(f.batchIDs))})
+}
+
+func (f *fakeLogService) setDown(down bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.down = down
+}
+
+func (f *fakeLogService) received() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]string(nil), f.messages...)
+}
+
+// newTestClient 创建发送到 f 的日志客户端，暂存目录为 spoolDir
+func newTestClient(t *testing.T, f *fakeLogService, spoolDir string) *Client {
+	t.Helper()
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return NewClient("test", ClientConfig{
+		FlushInterval:     time.Hour,
+		InitialBackoff:    time.Millisecond,
+		MaxBackoff:        time.Millisecond,
+		SpoolDir:          spoolDir,
+		LevelPollInterval: -1,
+		Resolve:           func() (string, error) { return srv.URL, nil },
+	})
+}
+
+func logMessages(c *Client, from, to int) []string {
+	var messages []string
+	for i := from; i < to; i++ {
+		msg := fmt.Sprintf("message %d", i)
+		c.Log(Record{Level: LevelInfo, Message: msg})
+		messages = append(messages, msg)
+	}
+	return messages
+}
+
+func TestClientSpoolsWhileServiceIsDown(t *testing.T) {
+	f := &fakeLogService{down: true}
+	c := newTestClient(t, f, t.TempDir())
+	defer c.Close()
+	want := logMessages(c, 0, 3)
+	c.Flush()
+	if c.spool.empty() {
+		t.Fatal("records were not spooled while the log service was down")
+	}
+
+	f.setDown(false)
+	time.Sleep(2 * time.Millisecond)
+	want = append(want, logMessages(c, 3, 5)...)
+	c.Flush()
+	if got := f.received(); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("received %q, want %q in order", got, want)
+	}
+	if !c.spool.empty() {
+		t.Fatal("spool was not cleared after a successful replay")
+	}
+	if c.Dropped() != 0 {
+		t.Fatalf("dropped %d records", c.Dropped())
+	}
+}
+
+func TestClientReplaysSpoolFromPreviousProcess(t *testing.T) {
+	dir := t.TempDir()
+	down := &fakeLogService{down: true}
+	c := newTestClient(t, down, dir)
+	want := logMessages(c, 0, 3)
+	c.Close()
+
+	up := &fakeLogService{}
+	c = newTestClient(t, up, dir)
+	defer c.Close()
+	c.Flush()
+	if got := up.received(); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("received %q after restart, want %q", got, want)
+	}
+}
+
+func TestClientCloseSendsRecordsLoggedAfterFlush(t *testing.T) {
+	f := &fakeLogService{}
+	c := newTestClient(t, f, t.TempDir())
+	want := logMessages(c, 0, 2)
+	c.Flush()
+	want = append(want, logMessages(c, 2, 4)...)
+	c.Close()
+	if got := f.received(); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("received %q, want %q", got, want)
+	}
+	c.Log(Record{Level: LevelInfo, Message: "late"})
+	if c.Dropped() != 1 {
+		t.Fatalf("a record logged after Close was not counted as dropped")
+	}
+	c.Close()
+}
+
+func TestClientTimesOutHungService(t *testing.T) {
+	hang := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-hang }))
+	defer srv.Close()
+	defer close(hang)
+	c := NewClient("test", ClientConfig{
+		FlushInterval:     time.Hour,
+		SpoolDir:          t.TempDir(),
+		LevelPollInterval: -1,
+		Timeout:           50 * time.Millisecond,
+		Resolve:           func() (string, error) { return srv.URL, nil },
+	})
+	defer c.Close()
+	logMessages(c, 0, 1)
+	done := make(chan struct{})
+	go func() {
+		c.Flush()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Flush blocked on a log service that never responds")
+	}
+	if c.spool.empty() {
+		t.Fatal("the timed out batch was not spooled")
+	}
+}
@@ -1,38 +1,224 @@
 package log
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"go-distributed/registry"
 	stlog "log"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 )
 
+// requestTimeout 是向日志服务发送一次请求的默认超时
+const requestTimeout = 10 * time.Second
+
+// httpClient 是 Tail 以外的请求使用的 http.Client，日志服务接受连接但不响应时请求会超时
+var httpClient = &http.Client{Timeout: requestTimeout}
+
+// tailClient 是 Tail 使用的 http.Client，只限制等待响应头的时间，之后的推送可以持续任意长
+var tailClient = &http.Client{Transport: tailTransport()}
+
+func tailTransport() http.RoundTripper {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.ResponseHeaderTimeout = requestTimeout
+	return t
+}
+
+// defaultClient 是 SetClientLogger 创建的日志客户端
+var defaultClient *Client
+
 // SetClientLogger 是一个用于设置客户端日志记录器的函数。
 // 它将客户端服务的名称作为前缀设置到日志记录器中，并禁用所有标记设置。
-// 它还将日志记录器的输出设置为 clientLogger 结构体的实例。
+// 它还将日志记录器的输出设置为批量异步的日志客户端：日志发送到注册中心发现的日志服务，
+// 没有发现任何日志服务时发送到 serviceURL。
 func SetClientLogger(serviceURL string, clientService registry.ServiceName) {
+	SetClientLoggerWithConfig(serviceURL, clientService, ClientConfig{})
+}
+
+// SetClientLoggerWithConfig 与 SetClientLogger 相同，但使用 cfg 配置日志客户端
+func SetClientLoggerWithConfig(serviceURL string, clientService registry.ServiceName, cfg ClientConfig) {
+	if cfg.Resolve == nil {
+		cfg.Resolve = func() (string, error) {
+			if url, err := registry.GetProvider(registry.LogService); err == nil {
+				return url, nil
+			}
+			return serviceURL, nil
+		}
+	}
+	if defaultClient != nil {
+		defaultClient.Close()
+	}
+	defaultClient = NewClient(string(clientService), cfg)
 	stlog.SetPrefix(fmt.Sprintf("[%v] -", clientService)) // 将日志前缀设置为客户端服务名称，用方括号括起来。
 	stlog.SetFlags(0)                                     // 禁用所有标准标记设置。
-	stlog.SetOutput(&clientLogger{url: serviceURL})       // 将日志记录器的输出设置为 clientLogger 结构体的实例，其中 url 字段为 serviceURL。
+	stlog.SetOutput(defaultClient)                        // 将日志记录器的输出设置为日志客户端。
+}
+
+// CloseClientLogger 发送 SetClientLogger 创建的日志客户端中剩余的日志并停止它
+func CloseClientLogger() {
+	if defaultClient != nil {
+		defaultClient.Close()
+	}
+}
+
+// SendRecords 把结构化日志记录以 JSON 数组的形式发送到 serviceURL 所在的日志服务
+func SendRecords(serviceURL string, records ...Record) error {
+	_, err := SendBatch(serviceURL, newBatchID(), records)
+	return err
+}
+
+// SendBatch 把一批记录连同批次 ID 发送到 serviceURL 所在的日志服务，返回日志服务的确认。
+// 用相同的 ID 重发没有收到确认的批次不会造成重复写入。
+func SendBatch(serviceURL, id string, records []Record) (Ack, error) {
+	return sendBatch(httpClient, serviceURL, id, records, "", "")
+}
+
+// sendBatch 与 SendBatch 相同，但使用 hc 发送请求；service 和 instance 非空时通过请求头告诉日志服务发送者是谁
+func sendBatch(hc *http.Client, serviceURL, id string, records []Record, service, instance string) (Ack, error) {
+	var ack Ack
+	data, err := json.Marshal(records)
+	if err != nil {
+		return ack, err
+	}
+	req, err := http.NewRequest(http.MethodPost, serviceURL+"/log", bytes.NewReader(data))
+	if err != nil {
+		return ack, err
+	}
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add(BatchIDHeader, id)
+	if service != "" {
+		req.Header.Add(ServiceHeader, service)
+	}
+	if instance != "" {
+		req.Header.Add(InstanceHeader, instance)
+	}
+	res, err := hc.Do(req)
+	if err != nil {
+		return ack, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return ack, fmt.Errorf("failed to send log records. Service responded with %v", res.StatusCode)
+	}
+	err = json.NewDecoder(res.Body).Decode(&ack)
+	if err == nil && ack.BatchID != id {
+		err = fmt.Errorf("log service acknowledged batch %q, want %q", ack.BatchID, id)
+	}
+	return ack, err
+}
+
+// Query 调用 serviceURL 所在日志服务的 GET /log/query，params 为过滤和分页参数
+func Query(serviceURL string, params url.Values) (QueryResult, error) {
+	var result QueryResult
+	res, err := httpClient.Get(serviceURL + "/log/query?" + params.Encode())
+	if err != nil {
+		return result, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return result, fmt.Errorf("failed to query logs. Service responded with %v", res.StatusCode)
+	}
+	err = json.NewDecoder(res.Body).Decode(&result)
+	return result, err
+}
+
+// Tail 调用 serviceURL 所在日志服务的 GET /log/tail，对收到的每条新记录调用 fn，直到 ctx 结束或连接断开
+func Tail(ctx context.Context, serviceURL string, params url.Values, fn func(Record)) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, serviceURL+"/log/tail?"+params.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	res, err := tailClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to tail logs. Service responded with %v", res.StatusCode)
+	}
+	// 一条记录就是一行，单条日志可能超过 bufio.Scanner 默认的 64KB
+	scanner := bufio.NewScanner(res.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal([]byte(data), &rec); err != nil {
+			return err
+		}
+		fn(rec)
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	return scanner.Err()
+}
+
+// SetLevel 在 serviceURL 所在的日志服务上集中配置最低日志级别
+func SetLevel(serviceURL string, setting LevelSetting) error {
+	data, err := json.Marshal(setting)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPut, serviceURL+"/log/levels", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Content-Type", "application/json")
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to set log level. Service responded with %v", res.StatusCode)
+	}
+	return nil
 }
 
-// clientLogger 是一个包含服务 URL 的结构体。
-type clientLogger struct {
-	url string
+// ClearLevel 删除 serviceURL 所在日志服务上为 service 的 instance 实例配置的最低日志级别
+func ClearLevel(serviceURL, service, instance string) error {
+	params := url.Values{"service": {service}}
+	if instance != "" {
+		params.Set("instance", instance)
+	}
+	req, err := http.NewRequest(http.MethodDelete, serviceURL+"/log/levels?"+params.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to clear log level. Service responded with %v", res.StatusCode)
+	}
+	return nil
 }
 
-// Write 是 clientLogger 上的一个方法，它将日志数据写入服务 URL。
-// 它将字节数组作为输入，返回 (int, error)。
-func (cl clientLogger) Write(data []byte) (int, error) {
-	//TODO implement me
-	b := bytes.NewBuffer([]byte(data))                    // 从字节数组中创建一个新的缓冲区。
-	res, err := http.Post(cl.url+"/log", "text/plain", b) // 将缓冲区数据作为 HTTP POST 请求发送到 cl.url + "/log"。
+// Sources 调用 serviceURL 所在日志服务的 GET /log/sources，service 非空时只返回该服务的来源
+func Sources(serviceURL, service string) ([]SourceStats, error) {
+	var result []SourceStats
+	params := url.Values{}
+	if service != "" {
+		params.Set("service", service)
+	}
+	res, err := httpClient.Get(serviceURL + "/log/sources?" + params.Encode())
 	if err != nil {
-		return 0, err // 如果有错误，返回 0 和错误。
+		return nil, err
 	}
+	defer res.Body.Close()
 	if res.StatusCode != http.StatusOK {
-		return 0, fmt.Errorf("failed to send log message. Service responded with %v", res.StatusCode) // 如果响应状态码不是 OK，则返回 0 和错误信息。
+		return nil, fmt.Errorf("failed to get log sources. Service responded with %v", res.StatusCode)
 	}
-	return len(data), nil // 如果成功，则返回数据长度和无错误信息。
+	err = json.NewDecoder(res.Body).Decode(&result)
+	return result, err
 }
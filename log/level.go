@@ -0,0 +1,356 @@
+package log
+
+import (
+	"encoding/json"
+	"fmt"
+	stlog "log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ParseLevel 把不区分大小写的级别名称转换为 Level
+func ParseLevel(s string) (Level, error) {
+	l := Level(strings.ToUpper(s))
+	if !l.valid() {
+		return "", fmt.Errorf("unknown log level %q", s)
+	}
+	return l, nil
+}
+
+// Fields 是附加在日志记录上的结构化字段
+type Fields map[string]interface{}
+
+// Logger 是分级的结构化日志记录器，日志通过 Client 发送到日志服务。
+// 没有 Client 时日志写入标准库的默认日志记录器。
+type Logger struct {
+	client  *Client
+	fields  Fields
+	traceID string
+}
+
+// NewLogger 创建一个通过 c 发送日志的记录器
+func NewLogger(c *Client) *Logger {
+	return &Logger{client: c}
+}
+
+// Default 返回通过 SetClientLogger 创建的日志客户端发送日志的记录器
+func Default() *Logger {
+	return NewLogger(defaultClient)
+}
+
+// DefaultClient 返回 SetClientLogger 创建的日志客户端，尚未创建时返回 nil
+func DefaultClient() *Client {
+	return defaultClient
+}
+
+// With 返回一个附加了 fields 的新记录器
+func (l *Logger) With(fields Fields) *Logger {
+	merged := make(Fields, len(l.fields)+len(fields))
+	for k, v := range l.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return &Logger{client: l.client, fields: merged, traceID: l.traceID}
+}
+
+// WithTrace 返回一个为所有日志设置了追踪 ID 的新记录器
+func (l *Logger) WithTrace(traceID string) *Logger {
+	return &Logger{client: l.client, fields: l.fields, traceID: traceID}
+}
+
+// Debug 记录一条 DEBUG 级别的日志
+func (l *Logger) Debug(msg string, fields ...Fields) { l.log(LevelDebug, msg, fields) }
+
+// Info 记录一条 INFO 级别的日志
+func (l *Logger) Info(msg string, fields ...Fields) { l.log(LevelInfo, msg, fields) }
+
+// Warn 记录一条 WARN 级别的日志
+func (l *Logger) Warn(msg string, fields ...Fields) { l.log(LevelWarn, msg, fields) }
+
+// Error 记录一条 ERROR 级别的日志
+func (l *Logger) Error(msg string, fields ...Fields) { l.log(LevelError, msg, fields) }
+
+// Enabled 判断 level 级别的日志是否会被记录
+func (l *Logger) Enabled(level Level) bool {
+	return l.client == nil || l.client.Enabled(level)
+}
+
+// log 组装日志记录并交给日志客户端
+func (l *Logger) log(level Level, msg string, fields []Fields) {
+	if !l.Enabled(level) {
+		return
+	}
+	rec := Record{Level: level, Message: msg, TraceID: l.traceID}
+	if len(l.fields) > 0 || len(fields) > 0 {
+		rec.Fields = make(map[string]interface{})
+		for k, v := range l.fields {
+			rec.Fields[k] = v
+		}
+		for _, fs := range fields {
+			for k, v := range fs {
+				rec.Fields[k] = v
+			}
+		}
+	}
+	if l.client == nil {
+		stlog.Println(formatRecord(rec))
+		return
+	}
+	l.client.Log(rec)
+}
+
+// formatRecord 把日志记录格式化为一行文本
+func formatRecord(rec Record) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s", rec.Level, rec.Message)
+	keys := make([]string, 0, len(rec.Fields))
+	for k := range rec.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, " %s=%v", k, rec.Fields[k])
+	}
+	if rec.TraceID != "" {
+		fmt.Fprintf(&b, " trace=%s", rec.TraceID)
+	}
+	return b.String()
+}
+
+// Level 返回客户端当前的最低日志级别
+func (c *Client) Level() Level {
+	return c.level.Load().(Level)
+}
+
+// SetLevel 设置客户端的最低日志级别，低于该级别的日志会被直接丢弃
+func (c *Client) SetLevel(level Level) {
+	c.level.Store(level)
+}
+
+// Enabled 判断 level 级别的日志是否达到客户端的最低级别
+func (c *Client) Enabled(level Level) bool {
+	if level == "" {
+		level = LevelInfo
+	}
+	return level.rank() >= c.Level().rank()
+}
+
+// LevelHandler 返回一个用于查看和修改客户端最低级别的 http 处理器：
+// GET 返回 {"level": "..."}，PUT 的请求体为 {"level": "DEBUG"}
+func (c *Client) LevelHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			var body struct{ Level string }
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			level, err := ParseLevel(body.Level)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			c.SetLevel(level)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Level Level `json:"level"`
+		}{c.Level()})
+	})
+}
+
+// pollLevel 定期从日志服务获取集中配置的最低级别。
+// 只有集中配置发生变化时才会覆盖本地设置的级别；配置被删除时恢复为 ClientConfig.Level。
+func (c *Client) pollLevel() {
+	if c.cfg.LevelPollInterval < 0 {
+		return
+	}
+	ticker := time.NewTicker(c.cfg.LevelPollInterval)
+	defer ticker.Stop()
+	httpClient := &http.Client{Timeout: 5 * time.Second}
+	var central Level
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+		serviceURL, err := c.cfg.Resolve()
+		if err != nil {
+			continue
+		}
+		level, err := fetchLevel(httpClient, serviceURL, c.service, c.cfg.Instance)
+		if err != nil {
+			continue
+		}
+		if level == central {
+			continue
+		}
+		central = level
+		if level == "" {
+			level = c.cfg.Level
+		}
+		c.SetLevel(level)
+	}
+}
+
+// fetchLevel 查询日志服务为 service 的 instance 实例配置的级别，没有配置时返回空字符串
+func fetchLevel(httpClient *http.Client, serviceURL, service, instance string) (Level, error) {
+	params := url.Values{"service": {service}}
+	if instance != "" {
+		params.Set("instance", instance)
+	}
+	res, err := httpClient.Get(serviceURL + "/log/levels?" + params.Encode())
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return "", nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to fetch log level. Service responded with %v", res.StatusCode)
+	}
+	var setting LevelSetting
+	if err := json.NewDecoder(res.Body).Decode(&setting); err != nil {
+		return "", err
+	}
+	return ParseLevel(string(setting.Level))
+}
+
+// LevelSetting 是日志服务上集中配置的一条最低级别，Instance 为空时对该服务的所有实例生效
+type LevelSetting struct {
+	Service  string `json:"service"`
+	Instance string `json:"instance,omitempty"`
+	Level    Level  `json:"level"`
+}
+
+// levelStore 保存集中配置的最低级别
+type levelStore struct {
+	mutex    sync.RWMutex
+	settings map[LevelSetting]struct{}
+}
+
+// key 返回用于查找配置的键，Level 字段被清空
+func (s LevelSetting) key() LevelSetting {
+	return LevelSetting{Service: s.Service, Instance: s.Instance}
+}
+
+// set 添加或替换一条配置
+func (ls *levelStore) set(s LevelSetting) {
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+	if ls.settings == nil {
+		ls.settings = make(map[LevelSetting]struct{})
+	}
+	for existing := range ls.settings {
+		if existing.key() == s.key() {
+			delete(ls.settings, existing)
+		}
+	}
+	ls.settings[s] = struct{}{}
+}
+
+// remove 删除一条配置，返回配置是否存在
+func (ls *levelStore) remove(key LevelSetting) bool {
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+	for existing := range ls.settings {
+		if existing.key() == key {
+			delete(ls.settings, existing)
+			return true
+		}
+	}
+	return false
+}
+
+// effective 返回 service 的 instance 实例生效的配置，实例级别的配置优先于服务级别的配置
+func (ls *levelStore) effective(service, instance string) (LevelSetting, bool) {
+	ls.mutex.RLock()
+	defer ls.mutex.RUnlock()
+	var found LevelSetting
+	ok := false
+	for s := range ls.settings {
+		if s.Service != service {
+			continue
+		}
+		if instance != "" && s.Instance == instance {
+			return s, true
+		}
+		if s.Instance == "" {
+			found, ok = s, true
+		}
+	}
+	return found, ok
+}
+
+// list 返回所有配置，按服务和实例排序
+func (ls *levelStore) list() []LevelSetting {
+	ls.mutex.RLock()
+	defer ls.mutex.RUnlock()
+	result := make([]LevelSetting, 0, len(ls.settings))
+	for s := range ls.settings {
+		result = append(result, s)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Service != result[j].Service {
+			return result[i].Service < result[j].Service
+		}
+		return result[i].Instance < result[j].Instance
+	})
+	return result
+}
+
+// levelsHandler 处理 /log/levels：
+// GET 不带 service 参数时列出所有配置，带 service（和 instance）参数时返回生效的配置，没有配置时返回 404；
+// PUT 的请求体为 LevelSetting；DELETE 通过 service 和 instance 参数删除配置
+func (s *Server) levelsHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	switch r.Method {
+	case http.MethodGet:
+		var body interface{} = s.levels.list()
+		if service := q.Get("service"); service != "" {
+			setting, ok := s.levels.effective(service, q.Get("instance"))
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			body = setting
+		}
+		w.Header().Add("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(body)
+	case http.MethodPut:
+		var setting LevelSetting
+		if err := json.NewDecoder(r.Body).Decode(&setting); err != nil || setting.Service == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		level, err := ParseLevel(string(setting.Level))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		setting.Level = level
+		s.levels.set(setting)
+		stlog.Printf("log level of %s %s set to %s", setting.Service, setting.Instance, setting.Level)
+	case http.MethodDelete:
+		key := LevelSetting{Service: q.Get("service"), Instance: q.Get("instance")}
+		if !s.levels.remove(key) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
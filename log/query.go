@@ -0,0 +1,300 @@
+package log
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	stlog "log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultQueryLimit = 100
+	maxQueryLimit     = 1000
+)
+
+// rank 返回日志级别的严重程度，用于按最低级别过滤
+func (l Level) rank() int {
+	switch l {
+	case LevelDebug:
+		return 0
+	case LevelInfo:
+		return 1
+	case LevelWarn:
+		return 2
+	case LevelError:
+		return 3
+	}
+	return -1
+}
+
+// Filter 描述了查询和跟踪日志时的过滤条件，零值匹配所有记录
+type Filter struct {
+	Service  string
+	Level    Level // 最低级别，例如 WARN 会匹配 WARN 和 ERROR
+	Since    time.Time
+	Until    time.Time
+	Contains string
+	Pattern  *regexp.Regexp
+	Fields   map[string]string
+}
+
+// ParseFilter 从查询参数中解析过滤条件。支持的参数：
+// service、level、since、until（RFC3339）、q（子串）、regex、field（key:value，可重复）
+func ParseFilter(q url.Values) (Filter, error) {
+	var f Filter
+	var err error
+	f.Service = q.Get("service")
+	if level := q.Get("level"); level != "" {
+		f.Level = Level(strings.ToUpper(level))
+		if !f.Level.valid() {
+			return f, fmt.Errorf("unknown log level %q", level)
+		}
+	}
+	if since := q.Get("since"); since != "" {
+		if f.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			return f, fmt.Errorf("invalid since: %w", err)
+		}
+	}
+	if until := q.Get("until"); until != "" {
+		if f.Until, err = time.Parse(time.RFC3339, until); err != nil {
+			return f, fmt.Errorf("invalid until: %w", err)
+		}
+	}
+	f.Contains = q.Get("q")
+	if pattern := q.Get("regex"); pattern != "" {
+		if f.Pattern, err = regexp.Compile(pattern); err != nil {
+			return f, fmt.Errorf("invalid regex: %w", err)
+		}
+	}
+	for _, field := range q["field"] {
+		key, value, ok := strings.Cut(field, ":")
+		if !ok {
+			return f, fmt.Errorf("invalid field filter %q, want key:value", field)
+		}
+		if f.Fields == nil {
+			f.Fields = make(map[string]string)
+		}
+		f.Fields[key] = value
+	}
+	return f, nil
+}
+
+// Match 判断 rec 是否满足过滤条件
+func (f Filter) Match(rec Record) bool {
+	if f.Service != "" && rec.Service != f.Service {
+		return false
+	}
+	if f.Level != "" && rec.Level.rank() < f.Level.rank() {
+		return false
+	}
+	if !f.Since.IsZero() && rec.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !rec.Time.Before(f.Until) {
+		return false
+	}
+	if f.Contains != "" && !strings.Contains(rec.Message, f.Contains) {
+		return false
+	}
+	if f.Pattern != nil && !f.Pattern.MatchString(rec.Message) {
+		return false
+	}
+	for key, want := range f.Fields {
+		value, ok := rec.Fields[key]
+		if !ok || fmt.Sprint(value) != want {
+			return false
+		}
+	}
+	return true
+}
+
+// QueryResult 是 GET /log/query 的响应
+type QueryResult struct {
+	Records []Record `json:"records"`
+	Total   int      `json:"total"`
+	// NextOffset 是下一页的 offset，没有更多记录时为 0
+	NextOffset int `json:"nextOffset,omitempty"`
+}
+
+// scanRecords 依次解析 r 中的 JSON 行并对每条记录调用 fn，无法解析的行（例如旧格式的纯文本日志）会被跳过
+func scanRecords(r io.Reader, fn func(Record)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			continue
+		}
+		fn(rec)
+	}
+	return scanner.Err()
+}
+
+// errNoPrimaryFile 表示日志服务没有配置可供查询的 file 目标
+var errNoPrimaryFile = errors.New("no file sink to query")
+
+// query 在主目标的日志文件（包括轮转后的文件）中查找满足 f 的记录，返回从 offset 开始的最多 limit 条
+func (s *Server) query(f Filter, offset, limit int) (QueryResult, error) {
+	result := QueryResult{Records: make([]Record, 0)}
+	out := s.router.primaryFile
+	if out == nil {
+		return result, errNoPrimaryFile
+	}
+	if err := out.Flush(); err != nil {
+		return result, err
+	}
+	segments, err := out.segments()
+	if err != nil {
+		return result, err
+	}
+	for _, path := range append(segments, out.path) {
+		file, err := openSegment(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return result, err
+		}
+		err = scanRecords(file, func(rec Record) {
+			if !f.Match(rec) {
+				return
+			}
+			if result.Total >= offset && len(result.Records) < limit {
+				result.Records = append(result.Records, rec)
+			}
+			result.Total++
+		})
+		file.Close()
+		if err != nil {
+			return result, err
+		}
+	}
+	if offset+len(result.Records) < result.Total {
+		result.NextOffset = offset + len(result.Records)
+	}
+	return result, nil
+}
+
+// queryHandler 处理 GET /log/query，分页参数为 offset 和 limit
+func (s *Server) queryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	f, err := ParseFilter(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	offset, limit := 0, defaultQueryLimit
+	if v := q.Get("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			http.Error(w, "invalid offset", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		if limit > maxQueryLimit {
+			limit = maxQueryLimit
+		}
+	}
+	result, err := s.query(f, offset, limit)
+	if err == errNoPrimaryFile {
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		stlog.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// subscribe 注册一个接收新日志记录的通道，返回的函数用于取消订阅
+func (s *Server) subscribe() (<-chan Record, func()) {
+	ch := make(chan Record, 256)
+	s.mutex.Lock()
+	if s.subscribers == nil {
+		s.subscribers = make(map[chan Record]struct{})
+	}
+	s.subscribers[ch] = struct{}{}
+	s.mutex.Unlock()
+	return ch, func() {
+		s.mutex.Lock()
+		delete(s.subscribers, ch)
+		s.mutex.Unlock()
+	}
+}
+
+// publish 把刚写入的记录交给告警规则评估，并发送给所有订阅者，订阅者处理不过来时丢弃记录而不阻塞写入。
+// 调用者需要持有 s.mutex。
+func (s *Server) publish(records []Record) {
+	s.alerts.observe(records)
+	for ch := range s.subscribers {
+		for _, rec := range records {
+			select {
+			case ch <- rec:
+			default:
+			}
+		}
+	}
+}
+
+// tailHandler 处理 GET /log/tail，以 Server-Sent Events 的形式持续推送满足过滤条件的新记录
+func (s *Server) tailHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	f, err := ParseFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	records, cancel := s.subscribe()
+	defer cancel()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case rec := <-records:
+			if !f.Match(rec) {
+				continue
+			}
+			data, err := json.Marshal(rec)
+			if err != nil {
+				stlog.Println(err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
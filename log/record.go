@@ -0,0 +1,96 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Level 是日志的级别
+type Level string
+
+const (
+	LevelDebug = Level("DEBUG")
+	LevelInfo  = Level("INFO")
+	LevelWarn  = Level("WARN")
+	LevelError = Level("ERROR")
+)
+
+// valid 判断 l 是否为已知的日志级别
+func (l Level) valid() bool {
+	switch l {
+	case LevelDebug, LevelInfo, LevelWarn, LevelError:
+		return true
+	}
+	return false
+}
+
+// Record 是一条结构化日志，日志服务以 JSON 行的形式保存它
+type Record struct {
+	Time     time.Time              `json:"time"`
+	Level    Level                  `json:"level"`
+	Service  string                 `json:"service,omitempty"`
+	Instance string                 `json:"instance,omitempty"`
+	Message  string                 `json:"message"`
+	Fields   map[string]interface{} `json:"fields,omitempty"`
+	TraceID  string                 `json:"traceId,omitempty"`
+}
+
+// normalize 为缺省的时间和级别填充默认值，并检查级别是否合法
+func (rec *Record) normalize(now time.Time) error {
+	if rec.Time.IsZero() {
+		rec.Time = now
+	}
+	if rec.Level == "" {
+		rec.Level = LevelInfo
+	}
+	rec.Level = Level(strings.ToUpper(string(rec.Level)))
+	if !rec.Level.valid() {
+		return fmt.Errorf("unknown log level %q", rec.Level)
+	}
+	if rec.Message == "" {
+		return fmt.Errorf("log record has no message")
+	}
+	return nil
+}
+
+// decodeRecords 解析 JSON 格式的请求体，既可以是单条记录，也可以是记录数组
+func decodeRecords(data []byte) ([]Record, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		var records []Record
+		if err := json.Unmarshal(data, &records); err != nil {
+			return nil, err
+		}
+		return records, nil
+	}
+	var rec Record
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, err
+	}
+	return []Record{rec}, nil
+}
+
+// parseText 把旧的纯文本日志转换为记录。
+// SetClientLogger 产生的 "[服务名] -" 前缀会被解析为 Service 字段。
+func parseText(msg string) Record {
+	msg = strings.TrimRight(msg, "\r\n")
+	var rec Record
+	if strings.HasPrefix(msg, "[") {
+		if end := strings.Index(msg, "] -"); end > 0 {
+			rec.Service = msg[1:end]
+			msg = strings.TrimSpace(msg[end+len("] -"):])
+		}
+	}
+	rec.Message = msg
+	return rec
+}
+
+// errInvalidRecord 表示请求中有不合法的日志记录
+var errInvalidRecord = errors.New("invalid log record")
+
+// errEmptyBatch 表示请求中的记录数组为空
+var errEmptyBatch = errors.New("empty log batch")
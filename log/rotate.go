@@ -0,0 +1,410 @@
+package log
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"io"
+	stlog "log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SyncPolicy 决定日志文件何时刷新缓冲区并调用 fsync
+type SyncPolicy string
+
+const (
+	// SyncInterval 每隔 FileConfig.SyncInterval 刷新并同步一次，这是默认策略
+	SyncInterval = SyncPolicy("interval")
+	// SyncAlways 每次写入后立即刷新并同步
+	SyncAlways = SyncPolicy("always")
+	// SyncNever 只在缓冲区写满、轮转或关闭时刷新，从不主动同步
+	SyncNever = SyncPolicy("never")
+)
+
+// rotatedLayout 是轮转后文件名中的时间戳格式，按字典序排列即按时间排列
+const rotatedLayout = "20060102-150405.000000"
+
+// errFileClosed 表示日志文件已经被关闭
+var errFileClosed = errors.New("log file is closed")
+
+// FileConfig 配置日志文件的轮转、压缩、保留和同步策略，零值表示不轮转、不清理
+type FileConfig struct {
+	// MaxSize 是单个文件的最大字节数，超过后轮转，0 表示不按大小轮转
+	MaxSize int64
+	// RotateInterval 是按时间轮转的周期，0 表示不按时间轮转
+	RotateInterval time.Duration
+	// Compress 为 true 时轮转后的文件会被 gzip 压缩
+	Compress bool
+	// MaxAge 是轮转文件的最长保留时间，0 表示不限制
+	MaxAge time.Duration
+	// MaxTotalSize 是轮转文件的总大小上限，超过时从最旧的文件开始删除，0 表示不限制
+	MaxTotalSize int64
+	// BufferSize 是写缓冲区的大小，默认 64KB
+	BufferSize int
+	// Sync 是同步策略，默认 SyncInterval
+	Sync SyncPolicy
+	// SyncInterval 是 SyncInterval 策略下的同步周期，默认 1 秒
+	SyncInterval time.Duration
+}
+
+// rotatingFile 持有一个打开的日志文件，带缓冲地写入，并按配置轮转、压缩和清理旧文件
+type rotatingFile struct {
+	path   string
+	cfg    FileConfig
+	mutex  sync.Mutex
+	file   *os.File
+	w      *bufio.Writer
+	size   int64
+	opened time.Time
+	closed bool
+	// rotated 是等待后台任务压缩和清理的轮转文件
+	rotated []string
+	// wake 通知后台任务有新的轮转文件
+	wake chan struct{}
+	done chan struct{}
+	bg   sync.WaitGroup
+}
+
+// newRotatingFile 创建写入 path 的日志文件，文件在第一次写入时才会被打开
+func newRotatingFile(path string, cfg FileConfig) *rotatingFile {
+	if cfg.BufferSize <= 0 {
+		cfg.BufferSize = 64 * 1024
+	}
+	if cfg.Sync == "" {
+		cfg.Sync = SyncInterval
+	}
+	if cfg.SyncInterval <= 0 {
+		cfg.SyncInterval = time.Second
+	}
+	rf := &rotatingFile{path: path, cfg: cfg, wake: make(chan struct{}, 1), done: make(chan struct{})}
+	rf.bg.Add(1)
+	go rf.loop()
+	return rf
+}
+
+// loop 定期同步文件并检查是否需要按时间轮转，轮转后的文件也由它依次压缩和清理，
+// 因此同一时间只有一个协程在处理旧文件
+func (rf *rotatingFile) loop() {
+	defer rf.bg.Done()
+	tick := rf.cfg.SyncInterval
+	if rf.cfg.RotateInterval > 0 && rf.cfg.RotateInterval < tick {
+		tick = rf.cfg.RotateInterval
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-rf.done:
+			rf.maintain()
+			return
+		case <-rf.wake:
+			rf.maintain()
+		case <-ticker.C:
+			rf.mutex.Lock()
+			var err error
+			if rf.file != nil && rf.cfg.RotateInterval > 0 && time.Since(rf.opened) >= rf.cfg.RotateInterval {
+				err = rf.rotate()
+			} else if rf.cfg.Sync == SyncInterval {
+				err = rf.sync()
+			}
+			rf.mutex.Unlock()
+			if err != nil {
+				stlog.Println(err)
+			}
+		}
+	}
+}
+
+// maintain 压缩等待处理的轮转文件并清理超出保留策略的文件
+func (rf *rotatingFile) maintain() {
+	rf.mutex.Lock()
+	rotated := rf.rotated
+	rf.rotated = nil
+	rf.mutex.Unlock()
+	if len(rotated) == 0 {
+		return
+	}
+	if rf.cfg.Compress {
+		for _, path := range rotated {
+			if err := compressFile(path); err != nil {
+				stlog.Println(err)
+			}
+		}
+	}
+	if err := rf.enforceRetention(); err != nil {
+		stlog.Println(err)
+	}
+}
+
+// open 打开（或创建）日志文件，调用者需要持有 rf.mutex
+func (rf *rotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.file = f
+	rf.size = info.Size()
+	rf.opened = time.Now()
+	if rf.w == nil {
+		rf.w = bufio.NewWriterSize(f, rf.cfg.BufferSize)
+	} else {
+		rf.w.Reset(f)
+	}
+	return nil
+}
+
+// Write 把 data 写入缓冲区，必要时先轮转文件。文件关闭后返回错误。
+func (rf *rotatingFile) Write(data []byte) (int, error) {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+	if rf.closed {
+		return 0, errFileClosed
+	}
+	if rf.file == nil {
+		if err := rf.open(); err != nil {
+			return 0, err
+		}
+	}
+	if rf.cfg.MaxSize > 0 && rf.size > 0 && rf.size+int64(len(data)) > rf.cfg.MaxSize {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.w.Write(data)
+	rf.size += int64(n)
+	if err != nil {
+		return n, err
+	}
+	if rf.cfg.Sync == SyncAlways {
+		err = rf.sync()
+	}
+	return n, err
+}
+
+// sync 刷新缓冲区并把文件同步到磁盘，调用者需要持有 rf.mutex
+func (rf *rotatingFile) sync() error {
+	if rf.file == nil {
+		return nil
+	}
+	if err := rf.w.Flush(); err != nil {
+		return err
+	}
+	if rf.cfg.Sync == SyncNever {
+		return nil
+	}
+	return rf.file.Sync()
+}
+
+// Flush 把缓冲区中的数据写入文件，使其对读取者可见
+func (rf *rotatingFile) Flush() error {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+	if rf.file == nil {
+		return nil
+	}
+	return rf.w.Flush()
+}
+
+// Sync 刷新缓冲区并把文件同步到磁盘
+func (rf *rotatingFile) Sync() error {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+	if rf.file == nil {
+		return nil
+	}
+	if err := rf.w.Flush(); err != nil {
+		return err
+	}
+	return rf.file.Sync()
+}
+
+// closeFile 刷新并关闭当前文件，调用者需要持有 rf.mutex
+func (rf *rotatingFile) closeFile() error {
+	if rf.file == nil {
+		return nil
+	}
+	err := rf.sync()
+	if closeErr := rf.file.Close(); err == nil {
+		err = closeErr
+	}
+	rf.file = nil
+	return err
+}
+
+// Reopen 关闭并重新打开日志文件，用于配合外部的日志轮转工具（例如收到 SIGHUP 时）
+func (rf *rotatingFile) Reopen() error {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+	if rf.closed {
+		return errFileClosed
+	}
+	if err := rf.closeFile(); err != nil {
+		return err
+	}
+	return rf.open()
+}
+
+// rotate 把当前文件重命名为带时间戳的文件并打开新文件，然后通知后台任务压缩和清理旧文件。
+// 调用者需要持有 rf.mutex。
+func (rf *rotatingFile) rotate() error {
+	if err := rf.closeFile(); err != nil {
+		return err
+	}
+	rotated := rf.path + "." + time.Now().Format(rotatedLayout)
+	if err := os.Rename(rf.path, rotated); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := rf.open(); err != nil {
+		return err
+	}
+	rf.rotated = append(rf.rotated, rotated)
+	select {
+	case rf.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Close 刷新并关闭文件，等待后台任务处理完已经轮转的文件后停止。之后的写入都会返回错误。
+func (rf *rotatingFile) Close() error {
+	rf.mutex.Lock()
+	if !rf.closed {
+		rf.closed = true
+		close(rf.done)
+	}
+	err := rf.closeFile()
+	rf.mutex.Unlock()
+	rf.bg.Wait()
+	return err
+}
+
+// segments 返回所有轮转后的文件路径，按从旧到新排列
+func (rf *rotatingFile) segments() ([]string, error) {
+	matches, err := filepath.Glob(rf.path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	var result []string
+	for _, m := range matches {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(m, rf.path+"."), ".gz")
+		if _, err := time.Parse(rotatedLayout, stamp); err == nil {
+			result = append(result, m)
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// enforceRetention 删除超过 MaxAge 的轮转文件，并在总大小超过 MaxTotalSize 时从最旧的文件开始删除
+func (rf *rotatingFile) enforceRetention() error {
+	if rf.cfg.MaxAge <= 0 && rf.cfg.MaxTotalSize <= 0 {
+		return nil
+	}
+	segments, err := rf.segments()
+	if err != nil {
+		return err
+	}
+	type segment struct {
+		path string
+		size int64
+	}
+	var kept []segment
+	var total int64
+	for _, path := range segments {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if rf.cfg.MaxAge > 0 && time.Since(info.ModTime()) > rf.cfg.MaxAge {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+			continue
+		}
+		kept = append(kept, segment{path, info.Size()})
+		total += info.Size()
+	}
+	for i := 0; rf.cfg.MaxTotalSize > 0 && total > rf.cfg.MaxTotalSize && i < len(kept); i++ {
+		if err := os.Remove(kept[i].path); err != nil {
+			return err
+		}
+		total -= kept[i].size
+	}
+	return nil
+}
+
+// compressFile 把 path 压缩为 path.gz，成功后删除原文件
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	tmp := path + ".gz.tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if err == nil {
+		err = zw.Close()
+	}
+	if err == nil {
+		err = dst.Sync()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path+".gz"); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// openSegment 打开一个日志文件用于读取，压缩文件会被透明解压。
+// 如果未压缩的文件在读取前刚好被压缩，则改为读取压缩后的文件。
+func openSegment(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) && !strings.HasSuffix(path, ".gz") {
+		path += ".gz"
+		f, err = os.Open(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(path, ".gz") {
+		return f, nil
+	}
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return gzipReadCloser{zr, f}, nil
+}
+
+// gzipReadCloser 在关闭时同时关闭 gzip 读取器和底层文件
+type gzipReadCloser struct {
+	*gzip.Reader
+	f *os.File
+}
+
+func (g gzipReadCloser) Close() error {
+	g.Reader.Close()
+	return g.f.Close()
+}
@@ -0,0 +1,158 @@
+package log
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readSegments 按从旧到新的顺序读出所有轮转文件和当前文件的内容
+func readSegments(t *testing.T, rf *rotatingFile) []string {
+	t.Helper()
+	segments, err := rf.segments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var contents []string
+	for _, path := range append(segments, rf.path) {
+		r, err := openSegment(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents = append(contents, string(data))
+	}
+	return contents
+}
+
+func write(t *testing.T, rf *rotatingFile, lines ...string) {
+	t.Helper()
+	for _, line := range lines {
+		if _, err := rf.Write([]byte(line + "\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRotateBySize(t *testing.T) {
+	rf := newRotatingFile(filepath.Join(t.TempDir(), "app.log"), FileConfig{MaxSize: 8})
+	write(t, rf, "first", "second", "third")
+	if err := rf.Close(); err != nil {
+		t.Fatal(err)
+	}
+	got := readSegments(t, rf)
+	want := []string{"first\n", "second\n", "third\n"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("segments are %q, want %q", got, want)
+	}
+}
+
+func TestRotateByTime(t *testing.T) {
+	rf := newRotatingFile(filepath.Join(t.TempDir(), "app.log"), FileConfig{RotateInterval: 20 * time.Millisecond})
+	defer rf.Close()
+	write(t, rf, "old")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		segments, err := rf.segments()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(segments) > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the file was not rotated after its interval")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	write(t, rf, "new")
+	if err := rf.Close(); err != nil {
+		t.Fatal(err)
+	}
+	got := readSegments(t, rf)
+	if got[0] != "old\n" || got[len(got)-1] != "new\n" {
+		t.Fatalf("segments are %q, want old first and new in the current file", got)
+	}
+}
+
+func TestRotateCompress(t *testing.T) {
+	rf := newRotatingFile(filepath.Join(t.TempDir(), "app.log"), FileConfig{MaxSize: 8, Compress: true})
+	write(t, rf, "first", "second", "third")
+	if err := rf.Close(); err != nil {
+		t.Fatal(err)
+	}
+	segments, err := rf.segments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 2 {
+		t.Fatalf("rotated files are %v, want two", segments)
+	}
+	for _, path := range segments {
+		if !strings.HasSuffix(path, ".gz") {
+			t.Fatalf("rotated file %s was not compressed", path)
+		}
+	}
+	if got := readSegments(t, rf); strings.Join(got, "") != "first\nsecond\nthird\n" {
+		t.Fatalf("decompressed contents are %q", got)
+	}
+}
+
+func TestRotateRetention(t *testing.T) {
+	rf := newRotatingFile(filepath.Join(t.TempDir(), "app.log"), FileConfig{MaxSize: 8, MaxTotalSize: 14})
+	write(t, rf, "line-1", "line-2", "line-3", "line-4", "line-5")
+	if err := rf.Close(); err != nil {
+		t.Fatal(err)
+	}
+	got := readSegments(t, rf)
+	want := []string{"line-3\n", "line-4\n", "line-5\n"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("segments after retention are %q, want %q", got, want)
+	}
+}
+
+func TestRotateMaxAge(t *testing.T) {
+	rf := newRotatingFile(filepath.Join(t.TempDir(), "app.log"), FileConfig{MaxSize: 8, MaxAge: time.Hour})
+	write(t, rf, "first", "second")
+	rf.Flush()
+	segments, _ := rf.segments()
+	for _, path := range segments {
+		old := time.Now().Add(-2 * time.Hour)
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(t, rf, "third")
+	if err := rf.Close(); err != nil {
+		t.Fatal(err)
+	}
+	got := readSegments(t, rf)
+	want := []string{"second\n", "third\n"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("segments after retention are %q, want %q", got, want)
+	}
+}
+
+func TestWriteAfterClose(t *testing.T) {
+	rf := newRotatingFile(filepath.Join(t.TempDir(), "app.log"), FileConfig{MaxSize: 8})
+	write(t, rf, "first")
+	if err := rf.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rf.Write([]byte("late\n")); err != errFileClosed {
+		t.Fatalf("Write after Close returned %v, want %v", err, errFileClosed)
+	}
+	if err := rf.Reopen(); err != errFileClosed {
+		t.Fatalf("Reopen after Close returned %v, want %v", err, errFileClosed)
+	}
+	if err := rf.Close(); err != nil {
+		t.Fatalf("second Close returned %v", err)
+	}
+}
@@ -1,49 +1,228 @@
 package log
 
 import (
+	"encoding/json"
 	"io/ioutil"
 	stlog "log"
+	"mime"
 	"net/http"
-	"os"
+	"sync"
+	"time"
 )
 
-// 声明一个变量log指向*stlog.Logger类型的指针
-var log *stlog.Logger
+// 声明一个变量log指向默认的日志服务，由 Run 初始化
+var log *Server
 
-// 声明一个类型fileLog是一个字符串类型
-type fileLog string
+// Server 是日志服务的一个实例，按路由规则把收到的日志写入一个或多个输出目标。
+// 同一进程中可以创建多个互不影响的实例。
+type Server struct {
+	router      *router
+	ingest      *ingest
+	alerts      *alerter
+	sources     sourceTracker
+	mutex       sync.Mutex
+	subscribers map[chan Record]struct{}
+	levels      levelStore
+	// retry 是已经写入预写日志、但写入主目标失败的批次，由检查点任务重新写入主目标。
+	// 其他目标在第一次写入时已经成功或者已经放弃，重试时不再写入，以免重复。
+	retry []walEntry
+	done  chan struct{}
+	bg    sync.WaitGroup
+}
+
+// NewServer 创建一个写入 destination 文件的日志服务实例，使用默认的文件配置
+func NewServer(destination string) (*Server, error) {
+	return NewServerWithConfig(destination, FileConfig{})
+}
 
-// 为fileLog类型添加Write方法
-func (fl fileLog) Write(data []byte) (int, error) {
+// NewServerWithConfig 创建一个写入 destination 文件的日志服务实例，并按 cfg 轮转和清理日志文件。
+// destination 为空或者无法打开时返回错误。
+func NewServerWithConfig(destination string, cfg FileConfig) (*Server, error) {
+	return NewServerWithSinks(SingleFileConfig(destination, cfg))
+}
 
-	//打开文件，如果不存在则创建，并且以追加的模式写入，权限为0600
-	f, err := os.OpenFile(string(fl), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+// NewServerWithSinks 创建一个按 cfg 中的路由规则写入多个输出目标的日志服务实例。
+// 配置了预写日志时，上次退出前已确认但尚未写入输出目标的批次会先被重放。
+func NewServerWithSinks(cfg Config) (*Server, error) {
+	rt, err := newRouter(cfg)
+	if err != nil {
+		return nil, err
+	}
+	alerts, err := newAlerter(cfg.Alerts)
+	if err != nil {
+		rt.Close()
+		return nil, err
+	}
+	in, pending, err := newIngest(cfg.WAL, cfg.WALMaxSize)
 	if err != nil {
-		return 0, err
+		alerts.Close()
+		rt.Close()
+		return nil, err
+	}
+	s := &Server{router: rt, ingest: in, alerts: alerts, done: make(chan struct{})}
+	for _, e := range pending {
+		if err := rt.write(e.Records); err != nil {
+			s.retry = append(s.retry, e)
+		}
 	}
-	defer f.Close()
-	//将data写入打开的文件中
-	return f.Write(data)
+	if in.file != nil {
+		interval := time.Duration(cfg.CheckpointInterval)
+		if interval <= 0 {
+			interval = time.Second
+		}
+		s.bg.Add(1)
+		go s.checkpointLoop(interval)
+	}
+	return s, nil
+}
+
+// SingleFileConfig 返回只写入一个文件的配置
+func SingleFileConfig(destination string, cfg FileConfig) Config {
+	return Config{Sinks: []SinkConfig{{
+		Name:           "file",
+		Type:           SinkFile,
+		Path:           destination,
+		MaxSize:        cfg.MaxSize,
+		RotateInterval: Duration(cfg.RotateInterval),
+		Compress:       cfg.Compress,
+		MaxAge:         Duration(cfg.MaxAge),
+		MaxTotalSize:   cfg.MaxTotalSize,
+		Sync:           string(cfg.Sync),
+		SyncInterval:   Duration(cfg.SyncInterval),
+	}}}
 }
 
 // Run函数，用于初始化log
-func Run(destination string) {
-	log = stlog.New(fileLog(destination), "go ", stlog.LstdFlags)
+func Run(destination string) error {
+	return RunWithConfig(destination, FileConfig{})
+}
+
+// RunWithConfig 使用 cfg 初始化默认的日志服务
+func RunWithConfig(destination string, cfg FileConfig) error {
+	s, err := NewServerWithConfig(destination, cfg)
+	if err != nil {
+		return err
+	}
+	log = s
+	return nil
+}
+
+// RunWithSinks 使用多个输出目标初始化默认的日志服务
+func RunWithSinks(cfg Config) error {
+	s, err := NewServerWithSinks(cfg)
+	if err != nil {
+		return err
+	}
+	log = s
+	return nil
+}
+
+// Reopen 重新打开默认日志服务的日志文件
+func Reopen() error {
+	return log.Reopen()
+}
+
+// Close 关闭默认日志服务
+func Close() error {
+	return log.Close()
+}
+
+// Reopen 重新打开所有日志文件，用于配合外部的日志轮转工具
+func (s *Server) Reopen() error {
+	return s.router.reopen()
 }
 
-// RegisterHandlers函数，用于注册http请求处理器
+// Close 刷新并关闭所有输出目标和预写日志，停止后台的同步和轮转任务
+func (s *Server) Close() error {
+	select {
+	case <-s.done:
+		return nil
+	default:
+		close(s.done)
+	}
+	s.bg.Wait()
+	s.alerts.Close()
+	err := s.checkpoint()
+	if closeErr := s.router.Close(); err == nil {
+		err = closeErr
+	}
+	s.mutex.Lock()
+	closeErr := s.ingest.Close()
+	s.mutex.Unlock()
+	if err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// RegisterHandlers函数，用于在默认的 ServeMux 上注册http请求处理器
 func RegisterHandlers() {
-	http.HandleFunc("/log", func(w http.ResponseWriter, r *http.Request) {
+	log.RegisterHandlers(http.DefaultServeMux)
+}
+
+// RegisterHandlers 在 mux 上注册日志服务的http请求处理器。
+// POST /log 接受 application/json 格式的单条记录或非空的记录数组，其他内容类型按纯文本处理；
+// GET /log/query 查询已保存的记录，GET /log/tail 持续推送新记录，/log/levels 集中配置各服务的最低级别，
+// GET /log/alerts 查看告警规则的状态，GET /log/sources 查看各来源的统计信息。
+func (s *Server) RegisterHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/log/query", s.queryHandler)
+	mux.HandleFunc("/log/tail", s.tailHandler)
+	mux.HandleFunc("/log/levels", s.levelsHandler)
+	mux.HandleFunc("/log/alerts", s.alertsHandler)
+	mux.HandleFunc("/log/sources", s.sourcesHandler)
+	mux.HandleFunc("/log", func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodPost:
+			src := requestSource(r)
 			//读取请求体
 			msg, err := ioutil.ReadAll(r.Body)
 			if err != nil || len(msg) == 0 {
+				s.sources.reject(src)
 				w.WriteHeader(http.StatusBadRequest)
 				return
 			}
-			//调用write函数写入日志
-			write(string(msg))
+			var records []Record
+			if isJSON(r) {
+				records, err = decodeRecords(msg)
+				if err == nil && len(records) == 0 {
+					err = errEmptyBatch
+				}
+				if err != nil {
+					stlog.Println(err)
+					s.sources.reject(src)
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+			} else {
+				records = []Record{parseText(string(msg))}
+			}
+			// 请求头中的来源补全记录中缺少的服务名和实例
+			for i := range records {
+				if records[i].Service == "" {
+					records[i].Service = src.service
+				}
+				if records[i].Instance == "" {
+					records[i].Instance = src.instance
+				}
+			}
+			//调用write函数写入日志，持久化后返回确认
+			ack, err := s.write(r.Header.Get(BatchIDHeader), records)
+			if err == errInvalidRecord {
+				s.sources.reject(src)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if err != nil {
+				stlog.Println(err)
+				s.sources.reject(src)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			if !ack.Duplicate {
+				s.sources.observe(src, records, len(msg))
+			}
+			w.Header().Add("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(ack)
 		default:
 			w.WriteHeader(http.StatusMethodNotAllowed)
 			return
@@ -51,7 +230,49 @@ func RegisterHandlers() {
 	})
 }
 
-// write函数，用于写入日志
-func write(msg string) {
-	log.Printf("%v\n", msg)
+// isJSON 判断请求体是否为 JSON 格式
+func isJSON(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/json"
+}
+
+// write函数，用于把一批记录按路由规则写入输出目标，并返回确认。
+// 批次 ID 之前出现过时不再重复写入。配置了预写日志时，批次先写入预写日志，
+// 确认在预写日志 fsync 之后才返回；写入输出目标失败的批次由检查点任务重试。
+func (s *Server) write(id string, records []Record) (Ack, error) {
+	now := time.Now()
+	for i := range records {
+		if err := records[i].normalize(now); err != nil {
+			stlog.Println(err)
+			return Ack{}, errInvalidRecord
+		}
+	}
+	s.mutex.Lock()
+	if seq, ok := s.ingest.lookup(id); ok {
+		s.mutex.Unlock()
+		return Ack{BatchID: id, Seq: seq, Duplicate: true}, s.ingest.waitDurable(seq)
+	}
+	if s.ingest.file == nil {
+		// 没有预写日志时，只有成功写入主目标的批次才会被记录用于去重
+		if err := s.router.write(records); err != nil {
+			s.mutex.Unlock()
+			return Ack{}, err
+		}
+	}
+	seq, err := s.ingest.append(id, records)
+	if err != nil {
+		s.mutex.Unlock()
+		return Ack{}, err
+	}
+	if s.ingest.file != nil {
+		if err := s.router.write(records); err != nil {
+			s.retry = append(s.retry, walEntry{Seq: seq, ID: id, Records: records})
+		} else {
+			s.publish(records)
+		}
+	} else {
+		s.publish(records)
+	}
+	s.mutex.Unlock()
+	return Ack{BatchID: id, Seq: seq}, s.ingest.waitDurable(seq)
 }
@@ -0,0 +1,57 @@
+package log
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestServer 创建一个写入临时目录的日志服务，并在测试结束时关闭
+func newTestServer(t *testing.T) (*Server, *http.ServeMux) {
+	t.Helper()
+	s, err := NewServer(filepath.Join(t.TempDir(), "test.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	mux := http.NewServeMux()
+	s.RegisterHandlers(mux)
+	return s, mux
+}
+
+func TestNewServerWithoutDestination(t *testing.T) {
+	if s, err := NewServer(""); err == nil {
+		s.Close()
+		t.Fatal("created a log server without a destination")
+	}
+}
+
+func TestPostLog(t *testing.T) {
+	_, mux := newTestServer(t)
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		status      int
+	}{
+		{"record", "application/json", `{"message":"hello"}`, http.StatusOK},
+		{"batch", "application/json", `[{"message":"a"},{"message":"b"}]`, http.StatusOK},
+		{"text", "text/plain", "hello", http.StatusOK},
+		{"empty body", "application/json", "", http.StatusBadRequest},
+		{"empty batch", "application/json", "[]", http.StatusBadRequest},
+		{"malformed", "application/json", `{"message":`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/log", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Fatalf("POST /log %q responded with %v, want %v", tt.body, w.Code, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,537 @@
+package log
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	stlog "log"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Sink 是日志服务的一个输出目标
+type Sink interface {
+	Write(records []Record) error
+	Close() error
+}
+
+// Duration 是可以在 JSON 中写作 "24h" 这类字符串的时长
+type Duration time.Duration
+
+// UnmarshalJSON 解析 time.ParseDuration 格式的字符串
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalJSON 把时长输出为字符串
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// 支持的输出目标类型
+const (
+	SinkFile         = "file"
+	SinkServiceFiles = "service-files"
+	SinkStdout       = "stdout"
+	SinkSyslog       = "syslog"
+	SinkForward      = "forward"
+)
+
+// SinkConfig 描述一个输出目标
+type SinkConfig struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// Primary 为 true 的 file 目标是 /log/query 和 /log/tail 的数据来源，
+	// 没有指定时使用第一个 file 目标。只有主目标写入失败时 POST /log 才会返回错误。
+	Primary bool `json:"primary,omitempty"`
+
+	// file 目标的文件路径
+	Path string `json:"path,omitempty"`
+	// service-files 目标的目录，每个服务写入该目录下的 <服务名>.log
+	Dir string `json:"dir,omitempty"`
+	// file 和 service-files 目标的轮转、保留和同步策略
+	MaxSize        int64    `json:"maxSize,omitempty"`
+	RotateInterval Duration `json:"rotateInterval,omitempty"`
+	Compress       bool     `json:"compress,omitempty"`
+	MaxAge         Duration `json:"maxAge,omitempty"`
+	MaxTotalSize   int64    `json:"maxTotalSize,omitempty"`
+	Sync           string   `json:"sync,omitempty"`
+	SyncInterval   Duration `json:"syncInterval,omitempty"`
+
+	// syslog 目标的网络类型（默认 unixgram）、地址（默认 /dev/log）和标签（默认 go-distributed）
+	Network string `json:"network,omitempty"`
+	Address string `json:"address,omitempty"`
+	Tag     string `json:"tag,omitempty"`
+
+	// forward 目标转发到的日志服务地址
+	URL string `json:"url,omitempty"`
+}
+
+// fileConfig 返回文件类目标的 FileConfig
+func (sc SinkConfig) fileConfig() FileConfig {
+	return FileConfig{
+		MaxSize:        sc.MaxSize,
+		RotateInterval: time.Duration(sc.RotateInterval),
+		Compress:       sc.Compress,
+		MaxAge:         time.Duration(sc.MaxAge),
+		MaxTotalSize:   sc.MaxTotalSize,
+		Sync:           SyncPolicy(sc.Sync),
+		SyncInterval:   time.Duration(sc.SyncInterval),
+	}
+}
+
+// RouteConfig 是一条路由规则：满足条件的记录除主目标外还写入 Sinks 中的每个目标。
+// 条件字段为空时匹配所有记录；Final 为 true 时匹配后不再检查后面的规则。
+// 所有记录都写入主目标，因此不会因为没有匹配的规则而丢失，也都可以通过 /log/query 查到。
+type RouteConfig struct {
+	Sinks   []string          `json:"sinks"`
+	Service string            `json:"service,omitempty"`
+	Level   Level             `json:"level,omitempty"`
+	Fields  map[string]string `json:"fields,omitempty"`
+	Final   bool              `json:"final,omitempty"`
+}
+
+// Config 是日志服务的输出目标和路由配置
+type Config struct {
+	Sinks  []SinkConfig  `json:"sinks"`
+	Routes []RouteConfig `json:"routes"`
+	// WAL 是预写日志的路径，为空时不使用预写日志，确认只表示批次已交给输出目标
+	WAL string `json:"wal,omitempty"`
+	// WALMaxSize 是预写日志在检查点时被压缩的大小阈值，默认 64MB
+	WALMaxSize int64 `json:"walMaxSize,omitempty"`
+	// CheckpointInterval 是同步输出目标并写入检查点的周期，默认 1 秒
+	CheckpointInterval Duration `json:"checkpointInterval,omitempty"`
+	// Alerts 是在记录到达时评估的告警规则
+	Alerts []AlertRule `json:"alerts,omitempty"`
+}
+
+// LoadConfig 从 JSON 文件中读取配置
+func LoadConfig(path string) (Config, error) {
+	var cfg Config
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	err = json.Unmarshal(data, &cfg)
+	return cfg, err
+}
+
+// route 是解析后的路由规则
+type route struct {
+	sinks  []string
+	filter Filter
+	final  bool
+}
+
+// router 按路由规则把记录分发到各个输出目标
+type router struct {
+	sinks   map[string]Sink
+	order   []string
+	routes  []route
+	primary string
+	// primaryFile 是主目标的文件，供查询使用
+	primaryFile *rotatingFile
+}
+
+// newRouter 根据配置创建所有输出目标
+func newRouter(cfg Config) (*router, error) {
+	rt := &router{sinks: make(map[string]Sink)}
+	for _, sc := range cfg.Sinks {
+		if sc.Name == "" {
+			rt.Close()
+			return nil, fmt.Errorf("sink of type %q has no name", sc.Type)
+		}
+		if _, ok := rt.sinks[sc.Name]; ok {
+			rt.Close()
+			return nil, fmt.Errorf("duplicate sink %q", sc.Name)
+		}
+		sink, err := newSink(sc)
+		if err != nil {
+			rt.Close()
+			return nil, fmt.Errorf("sink %q: %w", sc.Name, err)
+		}
+		rt.sinks[sc.Name] = sink
+		rt.order = append(rt.order, sc.Name)
+		if fs, ok := sink.(*fileSink); ok && (sc.Primary || rt.primaryFile == nil) {
+			rt.primary = sc.Name
+			rt.primaryFile = fs.file
+		}
+	}
+	if len(rt.order) == 0 {
+		return nil, errors.New("no sinks configured")
+	}
+	if rt.primary == "" {
+		rt.primary = rt.order[0]
+	}
+	for _, rc := range cfg.Routes {
+		for _, name := range rc.Sinks {
+			if _, ok := rt.sinks[name]; !ok {
+				rt.Close()
+				return nil, fmt.Errorf("route refers to unknown sink %q", name)
+			}
+		}
+		f := Filter{Service: rc.Service, Fields: rc.Fields}
+		if rc.Level != "" {
+			level, err := ParseLevel(string(rc.Level))
+			if err != nil {
+				rt.Close()
+				return nil, err
+			}
+			f.Level = level
+		}
+		rt.routes = append(rt.routes, route{sinks: rc.Sinks, filter: f, final: rc.Final})
+	}
+	return rt, nil
+}
+
+// write 把记录写入主目标，并按路由规则写入其他目标。其他目标的错误只记录下来，只返回主目标的错误。
+func (rt *router) write(records []Record) error {
+	batches := make(map[string][]Record)
+	for _, rec := range records {
+		targets := map[string]bool{rt.primary: true}
+		batches[rt.primary] = append(batches[rt.primary], rec)
+		for _, r := range rt.routes {
+			if !r.filter.Match(rec) {
+				continue
+			}
+			for _, name := range r.sinks {
+				if !targets[name] {
+					targets[name] = true
+					batches[name] = append(batches[name], rec)
+				}
+			}
+			if r.final {
+				break
+			}
+		}
+	}
+	var primaryErr error
+	for _, name := range rt.order {
+		batch, ok := batches[name]
+		if !ok {
+			continue
+		}
+		if err := rt.sinks[name].Write(batch); err != nil {
+			stlog.Printf("sink %s: %v", name, err)
+			if name == rt.primary {
+				primaryErr = err
+			}
+		}
+	}
+	return primaryErr
+}
+
+// writePrimary 只把记录写入主目标，用于重试写入主目标失败的批次
+func (rt *router) writePrimary(records []Record) error {
+	if err := rt.sinks[rt.primary].Write(records); err != nil {
+		stlog.Printf("sink %s: %v", rt.primary, err)
+		return err
+	}
+	return nil
+}
+
+// sync 把所有文件类目标同步到磁盘
+func (rt *router) sync() error {
+	for _, name := range rt.order {
+		if s, ok := rt.sinks[name].(interface{ Sync() error }); ok {
+			if err := s.Sync(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// reopen 重新打开所有文件类目标
+func (rt *router) reopen() error {
+	var first error
+	for _, name := range rt.order {
+		if r, ok := rt.sinks[name].(interface{ Reopen() error }); ok {
+			if err := r.Reopen(); err != nil && first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+// Close 关闭所有目标
+func (rt *router) Close() error {
+	var first error
+	for _, name := range rt.order {
+		if err := rt.sinks[name].Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// newSink 根据配置创建一个输出目标
+func newSink(sc SinkConfig) (Sink, error) {
+	switch sc.Type {
+	case SinkFile:
+		if sc.Path == "" {
+			return nil, fmt.Errorf("file sink needs a path")
+		}
+		return &fileSink{file: newRotatingFile(sc.Path, sc.fileConfig())}, nil
+	case SinkServiceFiles:
+		if sc.Dir == "" {
+			return nil, fmt.Errorf("service-files sink needs a dir")
+		}
+		if err := os.MkdirAll(sc.Dir, 0700); err != nil {
+			return nil, err
+		}
+		return &serviceFilesSink{dir: sc.Dir, cfg: sc.fileConfig(), files: make(map[string]*rotatingFile)}, nil
+	case SinkStdout:
+		return &streamSink{w: os.Stdout}, nil
+	case SinkSyslog:
+		return newSyslogSink(sc)
+	case SinkForward:
+		if sc.URL == "" {
+			return nil, fmt.Errorf("forward sink needs a url")
+		}
+		url := sc.URL
+		return &forwardSink{client: NewClient("LogService", ClientConfig{
+			Resolve:           func() (string, error) { return url, nil },
+			SpoolDir:          defaultSpoolDir("go-distributed-forward", sc.Name, ""),
+			Level:             LevelDebug,
+			LevelPollInterval: -1,
+		})}, nil
+	}
+	return nil, fmt.Errorf("unknown sink type %q", sc.Type)
+}
+
+// encodeLines 把记录编码为 JSON 行
+func encodeLines(records []Record) ([]byte, error) {
+	var buf []byte
+	for _, rec := range records {
+		line, err := json.Marshal(rec)
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, line...)
+		buf = append(buf, '\n')
+	}
+	return buf, nil
+}
+
+// fileSink 把记录以 JSON 行的形式写入一个文件
+type fileSink struct {
+	file *rotatingFile
+}
+
+func (fs *fileSink) Write(records []Record) error {
+	buf, err := encodeLines(records)
+	if err != nil {
+		return err
+	}
+	_, err = fs.file.Write(buf)
+	return err
+}
+
+func (fs *fileSink) Sync() error   { return fs.file.Sync() }
+func (fs *fileSink) Reopen() error { return fs.file.Reopen() }
+func (fs *fileSink) Close() error  { return fs.file.Close() }
+
+// unsafeFileChars 匹配不能出现在文件名中的字符
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// serviceFilesSink 把每个服务的记录写入各自的文件
+type serviceFilesSink struct {
+	dir   string
+	cfg   FileConfig
+	mutex sync.Mutex
+	files map[string]*rotatingFile
+}
+
+func (ss *serviceFilesSink) Write(records []Record) error {
+	byService := make(map[string][]Record)
+	var order []string
+	for _, rec := range records {
+		name := rec.Service
+		if name == "" {
+			name = "unknown"
+		}
+		if _, ok := byService[name]; !ok {
+			order = append(order, name)
+		}
+		byService[name] = append(byService[name], rec)
+	}
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	for _, name := range order {
+		f, ok := ss.files[name]
+		if !ok {
+			f = newRotatingFile(filepath.Join(ss.dir, unsafeFileChars.ReplaceAllString(name, "_")+".log"), ss.cfg)
+			ss.files[name] = f
+		}
+		buf, err := encodeLines(byService[name])
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ss *serviceFilesSink) Sync() error {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	for _, f := range ss.files {
+		if err := f.Sync(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ss *serviceFilesSink) Reopen() error {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	for _, f := range ss.files {
+		if err := f.Reopen(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ss *serviceFilesSink) Close() error {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	var first error
+	for _, f := range ss.files {
+		if err := f.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// streamSink 把记录格式化为文本行写入 w，例如标准输出
+type streamSink struct {
+	w io.Writer
+}
+
+func (ss *streamSink) Write(records []Record) error {
+	for _, rec := range records {
+		line := rec.Time.Format(time.RFC3339) + " "
+		if rec.Service != "" {
+			line += "[" + rec.Service + "] "
+		}
+		if _, err := fmt.Fprintln(ss.w, line+formatRecord(rec)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ss *streamSink) Close() error { return nil }
+
+// syslogSink 以 RFC 3164 格式把记录发送到本地 syslog 套接字
+type syslogSink struct {
+	network string
+	address string
+	tag     string
+	mutex   sync.Mutex
+	conn    net.Conn
+}
+
+// newSyslogSink 创建 syslog 目标，连接在第一次写入时建立
+func newSyslogSink(sc SinkConfig) (*syslogSink, error) {
+	s := &syslogSink{network: sc.Network, address: sc.Address, tag: sc.Tag}
+	if s.network == "" {
+		s.network = "unixgram"
+	}
+	if s.address == "" {
+		s.address = "/dev/log"
+	}
+	if s.tag == "" {
+		s.tag = "go-distributed"
+	}
+	return s, nil
+}
+
+// syslogSeverity 把日志级别转换为 syslog 的严重程度
+func syslogSeverity(l Level) int {
+	switch l {
+	case LevelDebug:
+		return 7
+	case LevelWarn:
+		return 4
+	case LevelError:
+		return 3
+	}
+	return 6
+}
+
+func (s *syslogSink) Write(records []Record) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.conn == nil {
+		conn, err := net.Dial(s.network, s.address)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	for _, rec := range records {
+		// facility 1 为 user-level messages
+		pri := 1*8 + syslogSeverity(rec.Level)
+		tag := s.tag
+		if rec.Service != "" {
+			tag += "/" + rec.Service
+		}
+		msg := fmt.Sprintf("<%d>%s %s: %s", pri, rec.Time.Format(time.Stamp), tag, formatRecord(rec))
+		if _, err := s.conn.Write([]byte(msg)); err != nil {
+			s.conn.Close()
+			s.conn = nil
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *syslogSink) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// forwardSink 通过批量异步的日志客户端把记录转发到另一个日志服务实例
+type forwardSink struct {
+	client *Client
+}
+
+func (fs *forwardSink) Write(records []Record) error {
+	for _, rec := range records {
+		fs.client.Log(rec)
+	}
+	return nil
+}
+
+func (fs *forwardSink) Close() error {
+	fs.client.Close()
+	return nil
+}
@@ -0,0 +1,188 @@
+package log
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 日志客户端用于标识自己的请求头
+const (
+	ServiceHeader  = "X-Log-Service"
+	InstanceHeader = "X-Log-Instance"
+)
+
+// rateBuckets 是计算速率的滑动窗口的秒数
+const rateBuckets = 60
+
+// source 标识一个发送日志的服务实例
+type source struct {
+	service  string
+	instance string
+}
+
+// sourceStats 是一个来源的统计信息
+type sourceStats struct {
+	requests     int64
+	records      int64
+	bytes        int64
+	errorRecords int64
+	rejected     int64
+	firstSeen    time.Time
+	lastSeen     time.Time
+	// buckets 是最近 rateBuckets 秒内每秒收到的记录数，下标为 Unix 秒数对 rateBuckets 取模
+	buckets     [rateBuckets]int64
+	bucketTimes [rateBuckets]int64
+}
+
+// SourceStats 是 GET /log/sources 返回的一个来源的统计信息
+type SourceStats struct {
+	Service       string    `json:"service"`
+	Instance      string    `json:"instance,omitempty"`
+	Requests      int64     `json:"requests"`
+	Records       int64     `json:"records"`
+	Bytes         int64     `json:"bytes"`
+	RecordsPerSec float64   `json:"recordsPerSec"`
+	ErrorRecords  int64     `json:"errorRecords"`
+	Rejected      int64     `json:"rejected"`
+	FirstSeen     time.Time `json:"firstSeen"`
+	LastSeen      time.Time `json:"lastSeen"`
+	IdleSeconds   float64   `json:"idleSeconds"`
+}
+
+// sourceTracker 记录每个来源的统计信息
+type sourceTracker struct {
+	mutex   sync.Mutex
+	sources map[source]*sourceStats
+}
+
+// requestSource 从请求头中获取来源，没有请求头时返回空的来源
+func requestSource(r *http.Request) source {
+	return source{service: r.Header.Get(ServiceHeader), instance: r.Header.Get(InstanceHeader)}
+}
+
+// get 返回来源的统计信息，不存在时创建，调用者需要持有 t.mutex
+func (t *sourceTracker) get(src source, now time.Time) *sourceStats {
+	if t.sources == nil {
+		t.sources = make(map[source]*sourceStats)
+	}
+	st, ok := t.sources[src]
+	if !ok {
+		st = &sourceStats{firstSeen: now}
+		t.sources[src] = st
+	}
+	st.lastSeen = now
+	return st
+}
+
+// observe 记录一次成功的请求。请求头中没有来源时按每条记录的 Service 和 Instance 字段统计，
+// 字节数按记录的 JSON 编码长度估算。
+func (t *sourceTracker) observe(src source, records []Record, size int) {
+	now := time.Now()
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if src.service != "" {
+		st := t.get(src, now)
+		st.requests++
+		st.bytes += int64(size)
+		for _, rec := range records {
+			st.add(rec, now)
+		}
+		return
+	}
+	counted := make(map[source]bool)
+	for _, rec := range records {
+		recSrc := source{service: rec.Service, instance: rec.Instance}
+		st := t.get(recSrc, now)
+		if !counted[recSrc] {
+			st.requests++
+			counted[recSrc] = true
+		}
+		if data, err := json.Marshal(rec); err == nil {
+			st.bytes += int64(len(data))
+		}
+		st.add(rec, now)
+	}
+}
+
+// reject 记录一次被拒绝的请求
+func (t *sourceTracker) reject(src source) {
+	now := time.Now()
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	st := t.get(src, now)
+	st.requests++
+	st.rejected++
+}
+
+// add 记录一条日志
+func (st *sourceStats) add(rec Record, now time.Time) {
+	st.records++
+	if rec.Level == LevelError {
+		st.errorRecords++
+	}
+	sec := now.Unix()
+	i := sec % rateBuckets
+	if st.bucketTimes[i] != sec {
+		st.bucketTimes[i] = sec
+		st.buckets[i] = 0
+	}
+	st.buckets[i]++
+}
+
+// rate 返回最近 rateBuckets 秒内平均每秒收到的记录数
+func (st *sourceStats) rate(now time.Time) float64 {
+	var total int64
+	sec := now.Unix()
+	for i := range st.buckets {
+		if sec-st.bucketTimes[i] < rateBuckets {
+			total += st.buckets[i]
+		}
+	}
+	return float64(total) / rateBuckets
+}
+
+// list 返回所有来源的统计信息，service 非空时只返回该服务的来源
+func (t *sourceTracker) list(service string) []SourceStats {
+	now := time.Now()
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	result := make([]SourceStats, 0, len(t.sources))
+	for src, st := range t.sources {
+		if service != "" && src.service != service {
+			continue
+		}
+		result = append(result, SourceStats{
+			Service:       src.service,
+			Instance:      src.instance,
+			Requests:      st.requests,
+			Records:       st.records,
+			Bytes:         st.bytes,
+			RecordsPerSec: st.rate(now),
+			ErrorRecords:  st.errorRecords,
+			Rejected:      st.rejected,
+			FirstSeen:     st.firstSeen,
+			LastSeen:      st.lastSeen,
+			IdleSeconds:   now.Sub(st.lastSeen).Seconds(),
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Service != result[j].Service {
+			return result[i].Service < result[j].Service
+		}
+		return result[i].Instance < result[j].Instance
+	})
+	return result
+}
+
+// sourcesHandler 处理 GET /log/sources，可以用 service 参数只查看一个服务
+func (s *Server) sourcesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.sources.list(r.URL.Query().Get("service")))
+}
@@ -0,0 +1,347 @@
+package log
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	stlog "log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BatchIDHeader 是 POST /log 请求中携带客户端批次 ID 的请求头。
+// 日志服务按批次 ID 去重，客户端可以安全地重发没有收到确认的批次。
+const BatchIDHeader = "X-Log-Batch-ID"
+
+// Ack 是 POST /log 的响应，表示批次已经持久化
+type Ack struct {
+	BatchID string `json:"batchId,omitempty"`
+	// Seq 是日志服务为该批次分配的序号
+	Seq uint64 `json:"seq"`
+	// Duplicate 为 true 表示该批次之前已经被接收过，这次没有重复写入
+	Duplicate bool `json:"duplicate,omitempty"`
+}
+
+// maxTrackedBatches 是用于去重的最近批次 ID 的数量
+const maxTrackedBatches = 100000
+
+// newBatchID 生成一个随机的批次 ID
+func newBatchID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// walEntry 是预写日志中的一行，三种形式之一：
+// 一个批次（Seq、ID、Records）；一个检查点（Checkpoint，表示之前的批次都已写入输出目标）；
+// 压缩后的快照（Seq、IDs，记录序号和最近的批次 ID）
+type walEntry struct {
+	Seq        uint64            `json:"seq,omitempty"`
+	ID         string            `json:"id,omitempty"`
+	Records    []Record          `json:"records,omitempty"`
+	Checkpoint uint64            `json:"checkpoint,omitempty"`
+	IDs        map[string]uint64 `json:"ids,omitempty"`
+}
+
+// ingest 为批次分配序号并按批次 ID 去重。配置了预写日志时，
+// 批次在确认前先写入预写日志并 fsync，多个并发请求共享一次 fsync。
+// 除 waitDurable 外的方法都需要调用者持有 Server.mutex。
+type ingest struct {
+	seq     uint64
+	ids     map[string]uint64
+	idOrder []string
+
+	path      string
+	file      *os.File
+	size      int64
+	maxSize   int64
+	applied   uint64 // 最后一个检查点的序号
+	written   uint64 // 已写入预写日志（但未必已 fsync）的最大序号，原子访问
+	syncMutex sync.Mutex
+	synced    uint64 // 已 fsync 的最大序号，由 syncMutex 保护
+}
+
+// newIngest 创建去重和序号分配器，path 非空时打开预写日志，
+// 并返回上次退出时已确认但尚未写入输出目标的批次
+func newIngest(path string, maxSize int64) (*ingest, []walEntry, error) {
+	in := &ingest{ids: make(map[string]uint64), path: path, maxSize: maxSize}
+	if path == "" {
+		return in, nil, nil
+	}
+	if in.maxSize <= 0 {
+		in.maxSize = 64 << 20
+	}
+	pending, err := in.load()
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	in.file = f
+	in.size = info.Size()
+	in.written = in.seq
+	in.synced = in.seq
+	return in, pending, nil
+}
+
+// load 读取预写日志，恢复序号和去重信息，返回最后一个检查点之后的批次。
+// 崩溃时写了一半的最后一行会被截掉；其他无法解析的行表示已确认的批次丢失了，返回错误。
+func (in *ingest) load() ([]walEntry, error) {
+	f, err := os.Open(in.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var batches []walEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	line := 0
+	// valid 是可以解析的行的总长度；torn 是无法解析的那一行的行号，只有它是最后一行时才能忽略
+	var valid int64
+	torn := 0
+	var tornErr error
+	for scanner.Scan() {
+		if torn != 0 {
+			return nil, fmt.Errorf("%s:%d: %w", in.path, torn, tornErr)
+		}
+		line++
+		var e walEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			torn, tornErr = line, err
+			continue
+		}
+		valid += int64(len(scanner.Bytes()) + 1)
+		switch {
+		case e.IDs != nil:
+			for id, seq := range e.IDs {
+				in.track(id, seq)
+			}
+			if e.Seq > in.seq {
+				in.seq = e.Seq
+			}
+			in.applied = in.seq
+		case e.Checkpoint > 0:
+			in.applied = e.Checkpoint
+		default:
+			in.track(e.ID, e.Seq)
+			if e.Seq > in.seq {
+				in.seq = e.Seq
+			}
+			batches = append(batches, e)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if torn != 0 {
+		// 截掉写了一半的行，之后追加的批次从新的一行开始
+		if err := os.Truncate(in.path, valid); err != nil {
+			return nil, err
+		}
+	}
+	var pending []walEntry
+	for _, e := range batches {
+		if e.Seq > in.applied {
+			pending = append(pending, e)
+		}
+	}
+	return pending, nil
+}
+
+// track 记录一个批次 ID，超过上限时忘记最旧的 ID
+func (in *ingest) track(id string, seq uint64) {
+	if id == "" {
+		return
+	}
+	if _, ok := in.ids[id]; ok {
+		return
+	}
+	in.ids[id] = seq
+	in.idOrder = append(in.idOrder, id)
+	if len(in.idOrder) > maxTrackedBatches {
+		delete(in.ids, in.idOrder[0])
+		in.idOrder = in.idOrder[1:]
+	}
+}
+
+// lookup 返回之前接收过的批次的序号
+func (in *ingest) lookup(id string) (uint64, bool) {
+	if id == "" {
+		return 0, false
+	}
+	seq, ok := in.ids[id]
+	return seq, ok
+}
+
+// append 为批次分配序号，配置了预写日志时把批次写入预写日志（不 fsync）
+func (in *ingest) append(id string, records []Record) (uint64, error) {
+	seq := in.seq + 1
+	if in.file != nil {
+		line, err := json.Marshal(walEntry{Seq: seq, ID: id, Records: records})
+		if err != nil {
+			return 0, err
+		}
+		n, err := in.file.Write(append(line, '\n'))
+		if err != nil {
+			// 截掉写了一半的行，否则之后追加的批次会接在它后面，预写日志无法再被读取
+			if n > 0 {
+				if terr := in.file.Truncate(in.size); terr != nil {
+					return 0, fmt.Errorf("%v; truncate %s: %v", err, in.path, terr)
+				}
+			}
+			return 0, err
+		}
+		in.size += int64(n)
+	}
+	in.seq = seq
+	in.track(id, seq)
+	atomic.StoreUint64(&in.written, seq)
+	return seq, nil
+}
+
+// waitDurable 等待序号不大于 seq 的批次都已 fsync。
+// 并发的调用者排队等待，排在前面的一次 fsync 会覆盖所有已写入的批次。
+// 调用者不能持有 Server.mutex。
+func (in *ingest) waitDurable(seq uint64) error {
+	// file 会被 Close 和压缩替换，需要在 syncMutex 下读取
+	in.syncMutex.Lock()
+	defer in.syncMutex.Unlock()
+	if in.file == nil || in.synced >= seq {
+		return nil
+	}
+	target := atomic.LoadUint64(&in.written)
+	if err := in.file.Sync(); err != nil {
+		return err
+	}
+	in.synced = target
+	return nil
+}
+
+// checkpoint 记录序号不大于 in.seq 的批次都已写入并同步到输出目标；
+// 预写日志超过大小上限时把它压缩为只包含序号和最近批次 ID 的快照
+func (in *ingest) checkpoint() error {
+	if in.file == nil || in.applied == in.seq {
+		return nil
+	}
+	in.syncMutex.Lock()
+	defer in.syncMutex.Unlock()
+	if in.size >= in.maxSize {
+		if err := in.compact(); err != nil {
+			return err
+		}
+	} else {
+		line, err := json.Marshal(walEntry{Checkpoint: in.seq})
+		if err != nil {
+			return err
+		}
+		n, err := in.file.Write(append(line, '\n'))
+		in.size += int64(n)
+		if err == nil {
+			err = in.file.Sync()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	in.applied = in.seq
+	in.synced = in.seq
+	return nil
+}
+
+// compact 用快照替换预写日志，调用者需要持有 syncMutex
+func (in *ingest) compact() error {
+	ids := make(map[string]uint64, len(in.ids))
+	for id, seq := range in.ids {
+		ids[id] = seq
+	}
+	line, err := json.Marshal(walEntry{Seq: in.seq, IDs: ids})
+	if err != nil {
+		return err
+	}
+	tmp := in.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(line, '\n'))
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, in.path); err != nil {
+		f.Close()
+		return err
+	}
+	in.file.Close()
+	in.file = f
+	in.size = int64(len(line) + 1)
+	return nil
+}
+
+// Close 关闭预写日志
+func (in *ingest) Close() error {
+	in.syncMutex.Lock()
+	defer in.syncMutex.Unlock()
+	if in.file == nil {
+		return nil
+	}
+	err := in.file.Close()
+	in.file = nil
+	return err
+}
+
+// checkpointLoop 定期把输出目标同步到磁盘并写入检查点，直到 done 被关闭
+func (s *Server) checkpointLoop(interval time.Duration) {
+	defer s.bg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if err := s.checkpoint(); err != nil {
+				stlog.Println(err)
+			}
+		}
+	}
+}
+
+// checkpoint 重试之前写入主目标失败的批次，同步所有输出目标并在预写日志中记录检查点。
+// 还有批次没能写入主目标时不记录检查点，这样重启后它们会从预写日志中重放。
+func (s *Server) checkpoint() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.ingest.file == nil || s.ingest.applied == s.ingest.seq {
+		return nil
+	}
+	for len(s.retry) > 0 {
+		if err := s.router.writePrimary(s.retry[0].Records); err != nil {
+			return err
+		}
+		s.publish(s.retry[0].Records)
+		s.retry = s.retry[1:]
+	}
+	if err := s.router.sync(); err != nil {
+		return err
+	}
+	return s.ingest.checkpoint()
+}
@@ -0,0 +1,164 @@
+package log
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// walConfig 返回写入 dir/app.log、预写日志为 dir/ingest.wal 的配置
+func walConfig(dir string) Config {
+	cfg := SingleFileConfig(filepath.Join(dir, "app.log"), FileConfig{})
+	cfg.WAL = filepath.Join(dir, "ingest.wal")
+	return cfg
+}
+
+// countLines 返回日志文件中包含 message 的行数
+func countLines(t *testing.T, path, message string) int {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return strings.Count(string(data), `"message":"`+message+`"`)
+}
+
+// crashAfterAppending 把批次写入预写日志并 fsync，但不写入输出目标就关闭，模拟确认之后崩溃的进程
+func crashAfterAppending(t *testing.T, path string, batches map[string]string) {
+	t.Helper()
+	in, _, err := newIngest(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, message := range batches {
+		seq, err := in.append(id, []Record{{Message: message, Level: LevelInfo}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := in.waitDurable(seq); err != nil {
+			t.Fatal(err)
+		}
+	}
+	in.Close()
+}
+
+func TestWALReplayAfterCrash(t *testing.T) {
+	dir := t.TempDir()
+	cfg := walConfig(dir)
+	crashAfterAppending(t, cfg.WAL, map[string]string{"a": "acked-1", "b": "acked-2"})
+
+	s, err := NewServerWithSinks(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, message := range []string{"acked-1", "acked-2"} {
+		if n := countLines(t, filepath.Join(dir, "app.log"), message); n != 1 {
+			t.Fatalf("%s was written %d times after replay, want once", message, n)
+		}
+	}
+
+	// 检查点之后重启不会再次重放
+	s, err = NewServerWithSinks(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	if n := countLines(t, filepath.Join(dir, "app.log"), "acked-1"); n != 1 {
+		t.Fatalf("acked-1 was written %d times after a clean restart, want once", n)
+	}
+}
+
+func TestWALDeduplicatesBatchIDs(t *testing.T) {
+	dir := t.TempDir()
+	cfg := walConfig(dir)
+	s, err := NewServerWithSinks(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := s.write("batch-1", []Record{{Message: "once"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := s.write("batch-1", []Record{{Message: "once"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Duplicate || !again.Duplicate || again.Seq != first.Seq {
+		t.Fatalf("acks are %+v and %+v, want the second to be a duplicate of the first", first, again)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 重启后仍然记得之前的批次 ID
+	s, err = NewServerWithSinks(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ack, err := s.write("batch-1", []Record{{Message: "once"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	if !ack.Duplicate || ack.Seq != first.Seq {
+		t.Fatalf("ack after restart is %+v, want a duplicate of %+v", ack, first)
+	}
+	if n := countLines(t, filepath.Join(dir, "app.log"), "once"); n != 1 {
+		t.Fatalf("the batch was written %d times, want once", n)
+	}
+}
+
+func TestWALTornLastLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ingest.wal")
+	crashAfterAppending(t, path, map[string]string{"a": "complete"})
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"seq":2,"id":"b","records":[{"mess`)
+	f.Close()
+
+	in, pending, err := newIngest(path, 0)
+	if err != nil {
+		t.Fatalf("open WAL with a torn last line: %v", err)
+	}
+	if len(pending) != 1 || pending[0].ID != "a" {
+		t.Fatalf("pending batches are %+v, want only a", pending)
+	}
+	if _, ok := in.lookup("b"); ok {
+		t.Fatal("the torn batch was tracked as received")
+	}
+	// 截掉的行之后追加的批次在下次打开时可以读出
+	if _, err := in.append("c", []Record{{Message: "after"}}); err != nil {
+		t.Fatal(err)
+	}
+	in.Close()
+	in, pending, err = newIngest(path, 0)
+	if err != nil {
+		t.Fatalf("reopen WAL after appending past a torn line: %v", err)
+	}
+	in.Close()
+	if len(pending) != 2 || pending[1].ID != "c" {
+		t.Fatalf("pending batches are %+v, want a and c", pending)
+	}
+}
+
+func TestWALCorruptLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ingest.wal")
+	crashAfterAppending(t, path, map[string]string{"a": "first"})
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("not json\n")
+	f.WriteString(`{"seq":2,"id":"b","records":[{"message":"second"}]}` + "\n")
+	f.Close()
+	if in, _, err := newIngest(path, 0); err == nil {
+		in.Close()
+		t.Fatal("opened a WAL with a corrupt line before acknowledged batches")
+	}
+}
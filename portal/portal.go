@@ -0,0 +1,329 @@
+// Package portal 是面向教师的网页服务。它通过注册中心发现成绩服务，在服务器端渲染 HTML：
+// 学生列表、学生的成绩和总评，以及添加成绩的表单。对成绩服务的调用都通过 grades/client 进行。
+//
+// 用户用自己在成绩服务的令牌登录，门户代表用户用这个令牌访问成绩服务，
+// 因此用户在门户中能看到和修改的数据与直接调用成绩服务时相同。所有表单都带有防止跨站请求伪造的令牌。
+package portal
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"go-distributed/grades"
+	"go-distributed/grades/client"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+//go:embed templates/*.html
+var templateFiles embed.FS
+
+// templates 是门户的全部页面模板
+var templates = template.Must(template.ParseFS(templateFiles, "templates/*.html"))
+
+// actor 是门户修改成绩时记录在历史中的操作者，成绩服务启用认证时以令牌对应的调用者为准
+const actor = "teacherportal"
+
+// defaultServer 是 RegisterHandlers 使用的门户，通过默认的注册中心客户端发现成绩服务
+var defaultServer = NewServer(client.New(client.Config{}))
+
+// RegisterHandlers 在默认的 ServeMux 上注册门户的http请求处理器
+func RegisterHandlers() {
+	defaultServer.RegisterHandlers(http.DefaultServeMux)
+}
+
+// Server 是门户的一个实例，通过成绩服务客户端读取和修改成绩
+type Server struct {
+	grades   *client.Client
+	sessions sessions
+}
+
+// NewServer 创建一个使用成绩服务客户端 c 的门户实例，c 中的令牌会被登录用户的令牌代替
+func NewServer(c *client.Client) *Server {
+	return &Server{grades: c, sessions: sessions{byID: make(map[string]session)}}
+}
+
+// RegisterHandlers 在 mux 上注册门户的http请求处理器：
+//
+//	GET, POST /login
+//	POST      /logout
+//	GET       /students
+//	GET       /students/{id}
+//	POST      /students/{id}/grades
+//
+// 除登录页面外都需要先登录，POST 请求还需要提交会话的 csrf 令牌。
+func (s *Server) RegisterHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			s.renderError(w, http.StatusNotFound, "页面不存在")
+			return
+		}
+		http.Redirect(w, r, "/students", http.StatusFound)
+	})
+	mux.HandleFunc("/login", s.login)
+	mux.HandleFunc("/logout", s.authenticated(s.logout))
+	mux.HandleFunc("/students", s.authenticated(s.studentsPage))
+	mux.HandleFunc("/students/", s.authenticated(s.studentRoutes))
+}
+
+// user 是发出当前请求的登录用户
+type user struct {
+	// sessionID 是用户的会话
+	sessionID string
+	// csrf 是用户的表单需要提交的令牌
+	csrf string
+	// grades 是使用用户令牌的成绩服务客户端
+	grades *client.Client
+}
+
+// authenticated 要求请求来自登录的用户：没有登录时重定向到登录页面，POST 请求的 csrf 令牌不正确时返回 403
+func (s *Server) authenticated(h func(w http.ResponseWriter, r *http.Request, u user)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, sess, ok := s.sessions.get(r)
+		if !ok {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+		if r.Method == http.MethodPost {
+			if err := r.ParseForm(); err != nil {
+				s.renderError(w, http.StatusBadRequest, "无法解析表单")
+				return
+			}
+			if !sess.validCSRF(r) {
+				s.renderError(w, http.StatusForbidden, "表单已失效，请刷新页面后重试")
+				return
+			}
+		}
+		h(w, r, user{sessionID: id, csrf: sess.csrf, grades: s.grades.WithToken(sess.token)})
+	}
+}
+
+// page 是所有页面共有的数据
+type page struct {
+	Title string
+	Error string
+	// CSRF 是登录用户的表单需要提交的令牌，为空表示没有登录
+	CSRF string
+}
+
+// login 处理 GET /login 和 POST /login。用户提交成绩服务的令牌，门户用它访问一次成绩服务确认令牌有效后创建会话。
+// 成绩服务没有启用认证时任何令牌都可以登录。
+func (s *Server) login(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.render(w, http.StatusOK, "login", page{Title: "登录"})
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			s.renderError(w, http.StatusBadRequest, "无法解析表单")
+			return
+		}
+		token := strings.TrimSpace(r.PostForm.Get("token"))
+		if token == "" {
+			s.render(w, http.StatusUnprocessableEntity, "login", page{Title: "登录", Error: "请输入令牌"})
+			return
+		}
+		_, err := s.grades.WithToken(token).ListStudents(r.Context(), grades.StudentQuery{Limit: 1})
+		if errors.Is(err, grades.ErrUnauthorized) {
+			s.render(w, http.StatusUnauthorized, "login", page{Title: "登录", Error: "令牌无效或已过期"})
+			return
+		}
+		if err != nil && !errors.Is(err, grades.ErrForbidden) {
+			s.renderGradingError(w, err)
+			return
+		}
+		id, _, err := s.sessions.create(token)
+		if err != nil {
+			log.Println(err)
+			s.renderError(w, http.StatusInternalServerError, "无法创建会话，请稍后重试")
+			return
+		}
+		setSessionCookie(w, r, id)
+		http.Redirect(w, r, "/students", http.StatusSeeOther)
+	default:
+		s.renderError(w, http.StatusMethodNotAllowed, "不支持的请求方法")
+	}
+}
+
+// logout 处理 POST /logout
+func (s *Server) logout(w http.ResponseWriter, r *http.Request, u user) {
+	if r.Method != http.MethodPost {
+		s.renderError(w, http.StatusMethodNotAllowed, "不支持的请求方法")
+		return
+	}
+	s.sessions.delete(u.sessionID)
+	setSessionCookie(w, r, "")
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// studentsPage 处理 GET /students，q 参数按姓名搜索，offset 参数是当前页的起始位置
+func (s *Server) studentsPage(w http.ResponseWriter, r *http.Request, u user) {
+	if r.Method != http.MethodGet {
+		s.renderError(w, http.StatusMethodNotAllowed, "不支持的请求方法")
+		return
+	}
+	query := r.URL.Query().Get("q")
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	if offset < 0 {
+		offset = 0
+	}
+	students, err := u.grades.ListStudents(r.Context(), grades.StudentQuery{Search: query, Offset: offset})
+	if err != nil {
+		s.renderGradingError(w, err)
+		return
+	}
+	s.render(w, http.StatusOK, "students", struct {
+		page
+		Query      string
+		Students   grades.Students
+		Total      int
+		NextOffset int
+	}{page: page{Title: "学生列表", CSRF: u.csrf}, Query: query, Students: students.Students, Total: students.Total, NextOffset: students.NextOffset})
+}
+
+// studentRoutes 分发 /students/{id} 和 /students/{id}/grades
+func (s *Server) studentRoutes(w http.ResponseWriter, r *http.Request, u user) {
+	segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(segments) < 2 {
+		s.renderError(w, http.StatusNotFound, "页面不存在")
+		return
+	}
+	id, err := strconv.Atoi(segments[1])
+	if err != nil {
+		s.renderError(w, http.StatusNotFound, "页面不存在")
+		return
+	}
+	switch {
+	case len(segments) == 2 && r.Method == http.MethodGet:
+		s.studentPage(w, r, u, id, gradeForm{Type: grades.GradeQuiz}, nil, http.StatusOK)
+	case len(segments) == 3 && segments[2] == "grades" && r.Method == http.MethodPost:
+		s.addGrade(w, r, u, id)
+	case len(segments) == 2 || (len(segments) == 3 && segments[2] == "grades"):
+		s.renderError(w, http.StatusMethodNotAllowed, "不支持的请求方法")
+	default:
+		s.renderError(w, http.StatusNotFound, "页面不存在")
+	}
+}
+
+// gradeForm 是添加成绩表单中填写的内容，校验失败时原样显示
+type gradeForm struct {
+	Title string
+	Type  grades.GradeType
+	Score string
+	// EnrollmentID 是成绩所属的选课记录，"0" 表示不属于任何课程，为空时默认选中第一门课程
+	EnrollmentID string
+}
+
+// studentPage 渲染学生 id 的成绩、总评和选修的课程，fields 是上一次提交表单的校验错误
+func (s *Server) studentPage(w http.ResponseWriter, r *http.Request, u user, id int, form gradeForm, fields []grades.FieldError, status int) {
+	student, err := u.grades.GetStudent(r.Context(), id)
+	if err != nil {
+		s.renderGradingError(w, err)
+		return
+	}
+	courses, err := u.grades.StudentCourses(r.Context(), id)
+	if err != nil {
+		s.renderGradingError(w, err)
+		return
+	}
+	if form.EnrollmentID == "" && len(courses) > 0 {
+		form.EnrollmentID = strconv.Itoa(courses[0].Enrollment.ID)
+	}
+	title := fmt.Sprintf("%s %s", student.FirstName, student.LastName)
+	s.render(w, status, "student", struct {
+		page
+		Student grades.Student
+		Summary grades.Summary
+		Courses []grades.CourseEnrollment
+		Form    gradeForm
+		Fields  []grades.FieldError
+		Types   []grades.GradeType
+	}{
+		page:    page{Title: title, CSRF: u.csrf},
+		Student: student.Student,
+		Summary: student.Summary,
+		Courses: courses,
+		Form:    form,
+		Fields:  fields,
+		Types:   []grades.GradeType{grades.GradeQuiz, grades.GradeTest, grades.GradeExam},
+	})
+}
+
+// addGrade 处理 POST /students/{id}/grades 的表单，成功后重定向到学生页面，校验失败时重新显示表单
+func (s *Server) addGrade(w http.ResponseWriter, r *http.Request, u user, id int) {
+	form := gradeForm{
+		Title:        strings.TrimSpace(r.PostForm.Get("Title")),
+		Type:         grades.GradeType(r.PostForm.Get("Type")),
+		Score:        strings.TrimSpace(r.PostForm.Get("Score")),
+		EnrollmentID: r.PostForm.Get("EnrollmentID"),
+	}
+	var fields []grades.FieldError
+	score, err := strconv.ParseFloat(form.Score, 32)
+	if err != nil {
+		fields = append(fields, grades.FieldError{Field: "Score", Message: "must be a number"})
+	}
+	enrollmentID, err := strconv.Atoi(form.EnrollmentID)
+	if err != nil || enrollmentID < 0 {
+		fields = append(fields, grades.FieldError{Field: "EnrollmentID", Message: "must be one of the student's courses"})
+	}
+	if len(fields) > 0 {
+		s.studentPage(w, r, u, id, form, fields, http.StatusUnprocessableEntity)
+		return
+	}
+	g := grades.Grade{Title: form.Title, Type: form.Type, Score: float32(score), EnrollmentID: enrollmentID}
+	ctx := grades.WithChange(r.Context(), grades.Change{Actor: actor})
+	_, err = u.grades.AddGrade(ctx, id, g)
+	var verr *grades.ValidationError
+	if errors.As(err, &verr) {
+		s.studentPage(w, r, u, id, form, verr.Fields, http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		s.renderGradingError(w, err)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/students/%d", id), http.StatusSeeOther)
+}
+
+// renderGradingError 把调用成绩服务的错误渲染为错误页面
+func (s *Server) renderGradingError(w http.ResponseWriter, err error) {
+	var cerr *client.Error
+	switch {
+	case errors.Is(err, grades.ErrNotFound):
+		s.renderError(w, http.StatusNotFound, "学生不存在")
+		return
+	case errors.Is(err, grades.ErrUnauthorized):
+		s.renderError(w, http.StatusUnauthorized, "登录已失效，请重新登录")
+		return
+	case errors.Is(err, grades.ErrForbidden):
+		s.renderError(w, http.StatusForbidden, "你没有权限进行这个操作")
+		return
+	case errors.As(err, &cerr):
+		log.Println(err)
+		s.renderError(w, http.StatusBadGateway, "成绩服务出错，请稍后重试")
+		return
+	}
+	log.Println(err)
+	s.renderError(w, http.StatusServiceUnavailable, "成绩服务暂时不可用，请稍后重试")
+}
+
+// renderError 渲染只有错误信息的页面
+func (s *Server) renderError(w http.ResponseWriter, status int, message string) {
+	s.render(w, status, "error", page{Title: http.StatusText(status), Error: message})
+}
+
+// render 渲染模板 name，先渲染到缓冲区，模板出错时不会输出半个页面
+func (s *Server) render(w http.ResponseWriter, status int, name string, data interface{}) {
+	var buf bytes.Buffer
+	if err := templates.ExecuteTemplate(&buf, name, data); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	buf.WriteTo(w)
+}
@@ -0,0 +1,211 @@
+package portal
+
+import (
+	"go-distributed/grades"
+	"go-distributed/grades/client"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+// portalFixture 是连接到启用认证的成绩服务的门户：学生 1 选修教师 grace 负责的课程 1
+type portalFixture struct {
+	url        string
+	store      *grades.MemoryStore
+	enrollment int
+}
+
+func newPortalFixture(t *testing.T) portalFixture {
+	t.Helper()
+	store := grades.NewMemoryStore(grades.Students{
+		{ID: 1, FirstName: "Ada", LastName: "Lovelace"},
+		{ID: 2, FirstName: "Alan", LastName: "Turing"},
+	})
+	term, err := store.CreateTerm(grades.Term{Name: "2026 秋"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	course, err := store.CreateCourse(grades.Course{Code: "CS101", Title: "Programming"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	section, err := store.CreateSection(grades.Section{CourseID: course.ID, TermID: term.ID, Name: "A"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := store.Enroll(grades.Enrollment{StudentID: 1, SectionID: section.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := grades.NewAuthenticator(nil)
+	auth.AddToken("teacher-token", grades.Principal{Name: "grace", Role: grades.RoleTeacher, Courses: []int{course.ID}})
+	auth.AddToken("student-token", grades.Principal{Name: "ada", Role: grades.RoleStudent, StudentID: 1})
+	gs := grades.NewServer(store)
+	gs.SetAuthenticator(auth)
+	gmux := http.NewServeMux()
+	gs.RegisterHandlers(gmux)
+	g := httptest.NewServer(gmux)
+	t.Cleanup(g.Close)
+
+	pmux := http.NewServeMux()
+	NewServer(client.New(client.Config{BaseURL: g.URL})).RegisterHandlers(pmux)
+	p := httptest.NewServer(pmux)
+	t.Cleanup(p.Close)
+	return portalFixture{url: p.URL, store: store, enrollment: e.ID}
+}
+
+// browser 返回保存 cookie、不跟随重定向的客户端
+func browser(t *testing.T) *http.Client {
+	t.Helper()
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Client{
+		Jar:           jar,
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+}
+
+var csrfInput = regexp.MustCompile(`name="csrf" value="([0-9a-f]+)"`)
+
+// login 用 token 登录，返回已登录的客户端和页面中的 csrf 令牌
+func (f portalFixture) login(t *testing.T, token string) (*http.Client, string) {
+	t.Helper()
+	c := browser(t)
+	res, err := c.PostForm(f.url+"/login", url.Values{"token": {token}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusSeeOther || res.Header.Get("Location") != "/students" {
+		t.Fatalf("POST /login with %s = %d to %q, want a redirect to /students", token, res.StatusCode, res.Header.Get("Location"))
+	}
+	res, err = c.Get(f.url + "/students/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := csrfInput.FindSubmatch(body)
+	if res.StatusCode != http.StatusOK || m == nil {
+		t.Fatalf("GET /students/1 = %d without a csrf field:\n%s", res.StatusCode, body)
+	}
+	return c, string(m[1])
+}
+
+// postGrade 提交添加成绩的表单，csrf 为空时不提交 csrf 字段
+func (f portalFixture) postGrade(t *testing.T, c *http.Client, csrf string) *http.Response {
+	t.Helper()
+	form := url.Values{
+		"Title":        {"Midterm"},
+		"Type":         {string(grades.GradeExam)},
+		"Score":        {"88"},
+		"EnrollmentID": {strconv.Itoa(f.enrollment)},
+	}
+	if csrf != "" {
+		form.Set("csrf", csrf)
+	}
+	res, err := c.PostForm(f.url+"/students/1/grades", form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	return res
+}
+
+// gradeCount 返回学生 1 在成绩服务中的成绩数
+func (f portalFixture) gradeCount(t *testing.T) int {
+	t.Helper()
+	s, err := f.store.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(s.Grades)
+}
+
+func TestRequiresSession(t *testing.T) {
+	f := newPortalFixture(t)
+	c := browser(t)
+	res, err := c.Get(f.url + "/students")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusSeeOther || res.Header.Get("Location") != "/login" {
+		t.Fatalf("GET /students without a session = %d to %q, want a redirect to /login", res.StatusCode, res.Header.Get("Location"))
+	}
+	if res := f.postGrade(t, c, "anything"); res.StatusCode != http.StatusSeeOther || res.Header.Get("Location") != "/login" {
+		t.Fatalf("POST grade without a session = %d to %q, want a redirect to /login", res.StatusCode, res.Header.Get("Location"))
+	}
+
+	res, err = c.PostForm(f.url+"/login", url.Values{"token": {"guess"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("POST /login with an unknown token = %d, want 401", res.StatusCode)
+	}
+}
+
+func TestCSRF(t *testing.T) {
+	f := newPortalFixture(t)
+	c, csrf := f.login(t, "teacher-token")
+	for _, token := range []string{"", csrf[1:] + "0"} {
+		if res := f.postGrade(t, c, token); res.StatusCode != http.StatusForbidden {
+			t.Fatalf("POST grade with csrf %q = %d, want 403", token, res.StatusCode)
+		}
+	}
+	if n := f.gradeCount(t); n != 0 {
+		t.Fatalf("rejected forms added %d grades", n)
+	}
+	if res := f.postGrade(t, c, csrf); res.StatusCode != http.StatusSeeOther || res.Header.Get("Location") != "/students/1" {
+		t.Fatalf("POST grade with the session's csrf = %d to %q, want a redirect to /students/1", res.StatusCode, res.Header.Get("Location"))
+	}
+	if n := f.gradeCount(t); n != 1 {
+		t.Fatalf("student has %d grades, want 1", n)
+	}
+}
+
+func TestUsesUserToken(t *testing.T) {
+	f := newPortalFixture(t)
+
+	// 教师的令牌被转发给成绩服务，修改记录在教师名下
+	teacher, csrf := f.login(t, "teacher-token")
+	if res := f.postGrade(t, teacher, csrf); res.StatusCode != http.StatusSeeOther {
+		t.Fatalf("teacher POST grade = %d, want 303", res.StatusCode)
+	}
+	events, err := f.store.History(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := events[len(events)-1]; last.Type != grades.GradeAdded || last.Actor != "grace" {
+		t.Fatalf("last event is %s by %q, want GradeAdded by grace", last.Type, last.Actor)
+	}
+
+	// 学生的令牌只能读取自己，成绩服务拒绝学生添加成绩
+	student, csrf := f.login(t, "student-token")
+	if res := f.postGrade(t, student, csrf); res.StatusCode != http.StatusForbidden {
+		t.Fatalf("student POST grade = %d, want 403", res.StatusCode)
+	}
+	res, err := student.Get(f.url + "/students/2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusForbidden {
+		t.Fatalf("student GET /students/2 = %d, want 403", res.StatusCode)
+	}
+	if n := f.gradeCount(t); n != 1 {
+		t.Fatalf("student has %d grades, want 1", n)
+	}
+}
@@ -0,0 +1,112 @@
+package portal
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// sessionCookie 是保存会话 ID 的 cookie
+	sessionCookie = "portal_session"
+	// sessionTTL 是会话的有效期，过期后需要重新登录
+	sessionTTL = 8 * time.Hour
+	// csrfField 是表单中防止跨站请求伪造的令牌字段
+	csrfField = "csrf"
+)
+
+// session 是一个登录的门户用户
+type session struct {
+	// token 是用户登录时提供的成绩服务令牌，门户用它代表用户访问成绩服务
+	token string
+	// csrf 是该会话中的表单需要提交的令牌
+	csrf    string
+	expires time.Time
+}
+
+// sessions 是保存在内存中的会话，门户重启后用户需要重新登录
+type sessions struct {
+	mutex sync.Mutex
+	byID  map[string]session
+}
+
+// create 为令牌 token 创建一个会话，返回会话 ID
+func (ss *sessions) create(token string) (string, session, error) {
+	id, err := randomToken()
+	if err != nil {
+		return "", session{}, err
+	}
+	csrf, err := randomToken()
+	if err != nil {
+		return "", session{}, err
+	}
+	sess := session{token: token, csrf: csrf, expires: time.Now().Add(sessionTTL)}
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	now := time.Now()
+	for other, s := range ss.byID {
+		if now.After(s.expires) {
+			delete(ss.byID, other)
+		}
+	}
+	ss.byID[id] = sess
+	return id, sess, nil
+}
+
+// get 返回请求的 cookie 中未过期的会话
+func (ss *sessions) get(r *http.Request) (string, session, bool) {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return "", session{}, false
+	}
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	sess, ok := ss.byID[cookie.Value]
+	if !ok || time.Now().After(sess.expires) {
+		delete(ss.byID, cookie.Value)
+		return "", session{}, false
+	}
+	return cookie.Value, sess, true
+}
+
+// delete 删除会话 id
+func (ss *sessions) delete(id string) {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	delete(ss.byID, id)
+}
+
+// validCSRF 判断表单中提交的令牌是否与会话的令牌一致，调用者需要先调用 r.ParseForm
+func (sess session) validCSRF(r *http.Request) bool {
+	return subtle.ConstantTimeCompare([]byte(r.PostForm.Get(csrfField)), []byte(sess.csrf)) == 1
+}
+
+// setSessionCookie 在响应中设置会话 cookie，id 为空时删除 cookie
+func setSessionCookie(w http.ResponseWriter, r *http.Request, id string) {
+	cookie := &http.Cookie{
+		Name:     sessionCookie,
+		Value:    id,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if id == "" {
+		cookie.MaxAge = -1
+	} else {
+		cookie.Expires = time.Now().Add(sessionTTL)
+	}
+	http.SetCookie(w, cookie)
+}
+
+// randomToken 返回一个随机的十六进制字符串
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -11,13 +11,41 @@ import (
 	"sync"
 )
 
+// Client 是注册中心的客户端，保存注册中心的地址以及本服务所依赖服务的提供者列表。
+// 包级别的 RegisterService、ShutDownService、GetProvider 使用默认客户端 defaultClient。
+type Client struct {
+	ServicesURL string
+	prov        *providers
+}
+
+// NewClient 创建一个指向 servicesURL 所在注册中心的客户端，拥有独立的提供者列表
+func NewClient(servicesURL string) *Client {
+	return &Client{
+		ServicesURL: servicesURL,
+		prov: &providers{
+			services: make(map[ServiceName][]string),
+			mutex:    new(sync.RWMutex),
+		},
+	}
+}
+
+// 默认客户端，指向 ServicesUrl，并使用包级别的 prov
+var defaultClient = &Client{
+	ServicesURL: ServicesUrl,
+	prov:        &prov,
+}
+
 func RegisterService(r Registration) error { // 定义RegisterService函数并接收Registration作为参数
+	return defaultClient.RegisterService(http.DefaultServeMux, r)
+}
 
+// RegisterService 在 mux 上注册心跳和服务更新的处理器，然后向注册中心注册服务
+func (c *Client) RegisterService(mux *http.ServeMux, r Registration) error {
 	heartbeatURL, err := url.Parse(r.HeartBeatURL)
 	if err != nil {
 		return err
 	}
-	http.HandleFunc(heartbeatURL.Path, func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc(heartbeatURL.Path, func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	})
 
@@ -25,7 +53,7 @@ func RegisterService(r Registration) error { // 定义RegisterService函数并
 	if err != nil {
 		return err
 	}
-	http.Handle(serviceUpdateURL.Path, &serviceUpdateHandler{})
+	mux.Handle(serviceUpdateURL.Path, &serviceUpdateHandler{prov: c.prov})
 
 	buf := new(bytes.Buffer)    // 创建一个新的Buffer类型变量buf
 	enc := json.NewEncoder(buf) // 创建一个新的json编码器 enc 并将其设置为 buf 的输出
@@ -33,10 +61,11 @@ func RegisterService(r Registration) error { // 定义RegisterService函数并
 	if err != nil {             // 如果出错，返回err
 		return err
 	}
-	res, err := http.Post(ServicesUrl, "application/json", buf) // 向ServicesUrl发起POST请求并向其发布buf内容，返回响应和错误
-	if err != nil {                                             // 如果出错，返回err
+	res, err := http.Post(c.ServicesURL, "application/json", buf) // 向ServicesURL发起POST请求并向其发布buf内容，返回响应和错误
+	if err != nil {                                               // 如果出错，返回err
 		return err
 	}
+	defer res.Body.Close()
 	if res.StatusCode != http.StatusOK { // 如果响应状态码不为200
 		return fmt.Errorf("failed to register service. Registry service "+"responded with code %v", res.StatusCode) // 抛出一个新的错误
 	}
@@ -44,6 +73,7 @@ func RegisterService(r Registration) error { // 定义RegisterService函数并
 }
 
 type serviceUpdateHandler struct {
+	prov *providers
 }
 
 // 定义Struct：serviceUpdateHandler
@@ -68,13 +98,18 @@ func (suh serviceUpdateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request
 	// 打印更新的内容，以及更新内容（变量p）的值
 	fmt.Printf("updated received %v\n", p)
 	// 调用prov.Update()，传递变量p作为参数
-	prov.Update(p)
+	suh.prov.Update(p)
 }
 
 // ShutDownService 是一个函数，将以text/plain内容类型为参数发送DELETE请求来注销服务。
 func ShutDownService(url string) error {
+	return defaultClient.ShutDownService(url)
+}
+
+// ShutDownService 向客户端所指向的注册中心注销 url 对应的服务
+func (c *Client) ShutDownService(url string) error {
 	// 创建一个新的DELETE http请求，url为参数，并带有text/plain消息体。
-	req, err := http.NewRequest(http.MethodDelete, ServicesUrl, bytes.NewBuffer([]byte(url)))
+	req, err := http.NewRequest(http.MethodDelete, c.ServicesURL, bytes.NewBuffer([]byte(url)))
 	if err != nil {
 		return err
 	}
@@ -85,6 +120,7 @@ func ShutDownService(url string) error {
 	if err != nil {
 		return err
 	}
+	defer res.Body.Close()
 	// 检查响应状态码是否不等于200 OK。
 	if res.StatusCode != http.StatusOK {
 		// 返回一个错误，其中包含格式化后的消息指示失败。
@@ -108,6 +144,9 @@ func (p *providers) Update(pat patch) { // 定义一个方法 Update，并传入
 		if _, ok := p.services[patchEntry.Name]; !ok { // 如果 services 中没有名称为 patchEntry.Name 的服务，则将其初始化为一个空的切片
 			p.services[patchEntry.Name] = make([]string, 0)
 		}
+		if containsURL(p.services[patchEntry.Name], patchEntry.URL) { // 已经存在的提供者不重复添加
+			continue
+		}
 		p.services[patchEntry.Name] = append(p.services[patchEntry.Name], patchEntry.URL) // 将 patchEntry.URL 添加到对应服务的切片中
 	}
 
@@ -117,18 +156,31 @@ func (p *providers) Update(pat patch) { // 定义一个方法 Update，并传入
 			for i := range providerURLs {
 				if providerURLs[i] == patchEntry.URL { // 如果找到了对应的 URL，则从切片中删除它
 					p.services[patchEntry.Name] = append(providerURLs[:i], providerURLs[i+1:]...)
+					break
 				}
 			}
 		}
 	}
 }
 
+// containsURL 判断 urls 中是否已经包含 url
+func containsURL(urls []string, url string) bool {
+	for _, u := range urls {
+		if u == url {
+			return true
+		}
+	}
+	return false
+}
+
 // 定义方法get，其中p是一个类型为providers的接收器，name是ServiceName类型的参数
 func (p providers) get(name ServiceName) (string, error) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
 	// 从p中的services字段中获取name对应的slice（值和是否找到）
 	providers, ok := p.services[name]
-	// 若没找到则返回一个包含错误信息的error
-	if !ok {
+	// 若没找到或者提供者已全部下线，则返回一个包含错误信息的error
+	if !ok || len(providers) == 0 {
 		return "", fmt.Errorf("no providers available for service %v", name)
 	}
 	// 随机获取providers中的一个元素的索引
@@ -137,12 +189,34 @@ func (p providers) get(name ServiceName) (string, error) {
 	return providers[idx], nil
 }
 
+// all 返回 name 对应的所有提供者 URL 的副本
+func (p providers) all(name ServiceName) []string {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return append([]string(nil), p.services[name]...)
+}
+
 // 定义GetProvider函数，其中name是ServiceName类型的参数，返回一个string和error
 func GetProvider(name ServiceName) (string, error) {
 	// 返回prov调用get方法后的结果
 	return prov.get(name)
 }
 
+// GetProvider 从客户端发现的提供者中随机返回一个 name 服务的 URL
+func (c *Client) GetProvider(name ServiceName) (string, error) {
+	return c.prov.get(name)
+}
+
+// GetProviders 返回默认客户端发现的 name 服务的全部提供者 URL
+func GetProviders(name ServiceName) []string {
+	return prov.all(name)
+}
+
+// GetProviders 返回客户端发现的 name 服务的全部提供者 URL
+func (c *Client) GetProviders(name ServiceName) []string {
+	return c.prov.all(name)
+}
+
 // 定义变量prov，值为一个providers类型的struct
 var prov = providers{
 	// 初始化services字段为一个空的map
@@ -2,6 +2,7 @@ package registry
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -24,8 +25,16 @@ type registry struct {
 }
 
 // 定义 registry 的 add 方法，向 registrations 切片中添加 Registration，加锁确保并发安全
+// 同一 URL 的服务重复注册（例如实例重启）时只替换原有记录，不再重复通知
 func (r *registry) add(reg Registration) error {
 	r.mutex.Lock()
+	for i := range r.registrations {
+		if r.registrations[i].ServiceUrl == reg.ServiceUrl {
+			r.registrations[i] = reg
+			r.mutex.Unlock()
+			return r.sendRequiredServices(reg)
+		}
+	}
 	r.registrations = append(r.registrations, reg)
 	r.mutex.Unlock()
 	err := r.sendRequiredServices(reg)
@@ -127,47 +136,53 @@ func (r registry) sendPatch(p patch, url string) error {
 		return err // 若出现错误，则返回该错误
 	}
 	// 使用 http.Post 方法发送 POST 请求，请求体为 JSON 数据
-	_, err = http.Post(url, "application/json", bytes.NewBuffer(d))
+	res, err := http.Post(url, "application/json", bytes.NewBuffer(d))
 	if err != nil {
 		return err // 若出现错误，则返回该错误
 	}
+	res.Body.Close()
 	return nil // 返回 nil 表示无错误
 }
 
 // 定义了一个方法 remove，参数为 url，返回值为 error 类型
 func (r *registry) remove(url string) error {
-	// 遍历 registrations 数组中的所有元素
-	for i := range reg.registrations {
+	// 获取互斥锁，在 registrations 数组中查找并删除指定 url 的服务
+	r.mutex.Lock()
+	for i := range r.registrations {
 		// 如果当前元素的 ServiceUrl 字段等于指定 url
-		if reg.registrations[i].ServiceUrl == url {
+		if r.registrations[i].ServiceUrl == url {
+			removed := r.registrations[i]
+			r.registrations = append(r.registrations[:i], r.registrations[i+1:]...)
+			r.mutex.Unlock()
 			// 调用 notify 方法，将包含要删除的服务信息的 patch 对象作为参数传入
 			r.notify(patch{
 				Removed: []patchEntry{
 					{
-						Name: r.registrations[i].ServiceName,
-						URL:  r.registrations[i].ServiceUrl,
+						Name: removed.ServiceName,
+						URL:  removed.ServiceUrl,
 					},
 				},
 			})
-			// 获取互斥锁，修改 registrations 数组，然后释放互斥锁
-			r.mutex.Lock()
-			reg.registrations = append(reg.registrations[:i], reg.registrations[i+1:]...)
-			r.mutex.Unlock()
 			return nil // 返回 nil 表示删除成功
 		}
 	}
+	r.mutex.Unlock()
 	// 如果未找到要删除的服务，则返回一个错误
 	return fmt.Errorf("service at URL %s not found", url)
 }
 
-// 定义了 `registry` 结构体的 `heartbeat` 方法
-func (r *registry) heartbeat(freq time.Duration) {
-	// 无限循环
+// 定义了 `registry` 结构体的 `heartbeat` 方法，ctx 结束时停止检测
+func (r *registry) heartbeat(ctx context.Context, freq time.Duration) {
+	// 循环直到 ctx 结束
 	for {
+		// 复制一份当前的注册信息，避免检测过程中与 add/remove 产生竞争
+		r.mutex.RLock()
+		regs := append([]Registration(nil), r.registrations...)
+		r.mutex.RUnlock()
 		// 创建 waitgroup
 		var wg sync.WaitGroup
-		// 遍历 Registration 的切片 r.registrations
-		for _, reg := range r.registrations {
+		// 遍历 Registration 的切片 regs
+		for _, reg := range regs {
 			// 增加 waitgroup 的计数器
 			wg.Add(1)
 			// 启动协程
@@ -189,15 +204,18 @@ func (r *registry) heartbeat(freq time.Duration) {
 						log.Println(err)
 
 						// 如果请求成功，且状态码为 200
-					} else if res.StatusCode == http.StatusOK {
-						// 打印成功信息
-						log.Printf("heartbeat check passed for %v", reg.ServiceName)
-						// 如果之前的检测失败了，则重新添加注册信息
-						if !success {
-							r.add(reg)
+					} else {
+						res.Body.Close()
+						if res.StatusCode == http.StatusOK {
+							// 打印成功信息
+							log.Printf("heartbeat check passed for %v", reg.ServiceName)
+							// 如果之前的检测失败了，则重新添加注册信息
+							if !success {
+								r.add(reg)
+							}
+							// 跳出循环
+							break
 						}
-						// 跳出循环
-						break
 					}
 
 					// 如果请求不成功
@@ -212,13 +230,21 @@ func (r *registry) heartbeat(freq time.Duration) {
 					}
 
 					// 等待 1 秒钟再进行下一次心跳检测
-					time.Sleep(1 * time.Second)
+					select {
+					case <-ctx.Done():
+						return
+					case <-time.After(1 * time.Second):
+					}
 				}
 			}(reg)
-			// 等待所有协程执行完毕
-			wg.Wait()
-			// 等待指定时间再进入下一轮循环
-			time.Sleep(freq)
+		}
+		// 等待所有协程执行完毕
+		wg.Wait()
+		// 等待指定时间再进入下一轮循环
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(freq):
 		}
 	}
 }
@@ -227,7 +253,7 @@ var once sync.Once
 
 func SetupRegistryService() {
 	once.Do(func() {
-		go reg.heartbeat(3 * time.Second)
+		go reg.heartbeat(context.Background(), 3*time.Second)
 	})
 }
 
@@ -237,8 +263,40 @@ var reg = registry{
 	mutex:         new(sync.RWMutex),       // 初始化 mutex 为空互斥锁
 }
 
-// 声明 RegistryService 类型
+// 声明 RegistryService 类型，零值使用包级别的 reg
 type RegistryService struct {
+	reg *registry
+}
+
+// NewRegistryService 创建一个拥有独立注册信息的注册中心服务，用于在同一进程中运行多个注册中心
+func NewRegistryService() *RegistryService {
+	return &RegistryService{
+		reg: &registry{
+			registrations: make([]Registration, 0),
+			mutex:         new(sync.RWMutex),
+		},
+	}
+}
+
+// registry 返回该服务使用的注册信息
+func (s RegistryService) registry() *registry {
+	if s.reg == nil {
+		return &reg
+	}
+	return s.reg
+}
+
+// StartHeartbeat 以 freq 为周期对已注册的服务进行心跳检测，直到 ctx 结束
+func (s RegistryService) StartHeartbeat(ctx context.Context, freq time.Duration) {
+	go s.registry().heartbeat(ctx, freq)
+}
+
+// Registrations 返回当前已注册服务的副本
+func (s RegistryService) Registrations() []Registration {
+	r := s.registry()
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return append([]Registration(nil), r.registrations...)
 }
 
 // 实现 ServeHTTP 方法，当接收到 POST 请求时将请求体解码成 Registration 类型，然后调用 add 方法将其加入 registrations 切片中
@@ -255,7 +313,7 @@ func (s RegistryService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		log.Printf("Adding service: %v with url : %s\n", r.ServiceName, r.ServiceUrl) // 输出日志记录服务注册信息
-		err = reg.add(r)                                                              // 调用 registry 的 add 方法将新注册的服务信息加入 registrations 中
+		err = s.registry().add(r)                                                     // 调用 registry 的 add 方法将新注册的服务信息加入 registrations 中
 		if err != nil {                                                               // 如果 add 方法返回了错误
 			log.Println(err) // 输出错误日志
 			w.WriteHeader(http.StatusBadRequest)
@@ -270,7 +328,7 @@ func (s RegistryService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 		url := string(payload)
 		log.Printf("removing service at URL : %s", url)
-		err = reg.remove(url)
+		err = s.registry().remove(url)
 		if err != nil {
 			log.Println(err)
 			w.WriteHeader(http.StatusInternalServerError)
@@ -0,0 +1,418 @@
+// Package testcluster 在同一进程中启动注册中心、日志服务和成绩服务，
+// 每个实例监听随机端口并拥有独立的状态，便于编写故障转移和服务发现的测试，而无需启动二进制程序。
+package testcluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-distributed/grades"
+	"go-distributed/log"
+	"go-distributed/registry"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Options 用于配置集群，零值即可使用
+type Options struct {
+	// HeartbeatInterval 是注册中心两轮心跳检测之间的间隔，默认 100ms
+	HeartbeatInterval time.Duration
+	// LogInstances 是启动时日志服务的实例数量，默认 1
+	LogInstances int
+	// GradingInstances 是启动时成绩服务的实例数量，默认 1
+	GradingInstances int
+	// Students 是成绩服务的初始数据，每个实例持有一份副本，默认使用 grades.MockStudents
+	Students grades.Students
+	// NewGradingServer 创建成绩服务实例，例如按二进制程序的配置创建；默认使用持有 Students 副本的内存存储
+	NewGradingServer func() (*grades.Server, error)
+	// Dir 是日志文件所在的目录，默认创建一个临时目录并在 Close 时删除
+	Dir string
+}
+
+// Cluster 是一组在同一进程中运行的服务
+type Cluster struct {
+	// RegistryURL 是注册中心 /services 端点的地址
+	RegistryURL string
+
+	opts       Options
+	registry   *registry.RegistryService
+	regServer  *http.Server
+	cancel     context.CancelFunc
+	removeDir  bool
+	mutex      sync.Mutex
+	instances  []*Instance
+	closedOnce sync.Once
+}
+
+// Instance 是集群中的一个服务实例
+type Instance struct {
+	Name registry.ServiceName
+	URL  string
+	// Client 是该实例使用的注册中心客户端，记录了它发现的依赖服务
+	Client *registry.Client
+
+	addr     string
+	required []registry.ServiceName
+	register func(mux *http.ServeMux) error
+	logFile  string
+	closer   io.Closer
+	mutex    sync.Mutex
+	server   *http.Server
+}
+
+// Start 启动注册中心以及 opts 中指定数量的日志服务和成绩服务实例
+func Start(opts Options) (*Cluster, error) {
+	if opts.HeartbeatInterval <= 0 {
+		opts.HeartbeatInterval = 100 * time.Millisecond
+	}
+	if opts.LogInstances == 0 {
+		opts.LogInstances = 1
+	}
+	if opts.GradingInstances == 0 {
+		opts.GradingInstances = 1
+	}
+	if opts.Students == nil {
+		opts.Students = grades.MockStudents()
+	}
+	c := &Cluster{opts: opts}
+	if c.opts.Dir == "" {
+		dir, err := os.MkdirTemp("", "testcluster")
+		if err != nil {
+			return nil, err
+		}
+		c.opts.Dir = dir
+		c.removeDir = true
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	c.registry = registry.NewRegistryService()
+	mux := http.NewServeMux()
+	mux.Handle("/services", c.registry)
+	c.regServer = &http.Server{Handler: mux}
+	go c.regServer.Serve(ln)
+	c.RegistryURL = "http://" + ln.Addr().String() + "/services"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	c.registry.StartHeartbeat(ctx, opts.HeartbeatInterval)
+
+	for i := 0; i < opts.LogInstances; i++ {
+		if _, err := c.StartLogService(); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	for i := 0; i < opts.GradingInstances; i++ {
+		if _, err := c.StartGradingService(); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// StartLogService 启动一个新的日志服务实例，日志写入集群目录下以实例端口命名的文件
+func (c *Cluster) StartLogService() (*Instance, error) {
+	inst := &Instance{Name: registry.LogService}
+	var srv *log.Server
+	inst.register = func(mux *http.ServeMux) error {
+		if srv == nil {
+			_, port, _ := net.SplitHostPort(inst.addr)
+			inst.logFile = filepath.Join(c.opts.Dir, "log-"+port+".log")
+			var err error
+			if srv, err = log.NewServer(inst.logFile); err != nil {
+				return err
+			}
+			inst.closer = srv
+		}
+		srv.RegisterHandlers(mux)
+		return nil
+	}
+	return inst, c.startInstance(inst)
+}
+
+// StartGradingService 启动一个新的成绩服务实例，它依赖日志服务。
+// 实例的数据在重启之间保留。
+func (c *Cluster) StartGradingService() (*Instance, error) {
+	inst := &Instance{
+		Name:     registry.GradingService,
+		required: []registry.ServiceName{registry.LogService},
+	}
+	var srv *grades.Server
+	if c.opts.NewGradingServer != nil {
+		var err error
+		if srv, err = c.opts.NewGradingServer(); err != nil {
+			return nil, err
+		}
+	} else {
+		srv = grades.NewServer(grades.NewMemoryStore(c.opts.Students))
+	}
+	inst.register = func(mux *http.ServeMux) error {
+		srv.RegisterHandlers(mux)
+		return nil
+	}
+	return inst, c.startInstance(inst)
+}
+
+// startInstance 为实例分配随机端口并启动
+func (c *Cluster) startInstance(inst *Instance) error {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	inst.addr = ln.Addr().String()
+	inst.URL = "http://" + inst.addr
+	c.mutex.Lock()
+	c.instances = append(c.instances, inst)
+	c.mutex.Unlock()
+	return c.serve(inst, ln)
+}
+
+// serve 在 ln 上启动实例的 HTTP 服务并向注册中心注册
+func (c *Cluster) serve(inst *Instance, ln net.Listener) error {
+	inst.mutex.Lock()
+	defer inst.mutex.Unlock()
+	mux := http.NewServeMux()
+	if err := inst.register(mux); err != nil {
+		ln.Close()
+		return err
+	}
+	inst.Client = registry.NewClient(c.RegistryURL)
+	inst.server = &http.Server{Handler: mux}
+	go inst.server.Serve(ln)
+	r := registry.Registration{
+		ServiceName:      inst.Name,
+		ServiceUrl:       inst.URL,
+		RequiredServices: inst.required,
+		ServiceUpdateURL: inst.URL + "/services",
+		HeartBeatURL:     inst.URL + "/heartbeat",
+	}
+	if r.RequiredServices == nil {
+		r.RequiredServices = make([]registry.ServiceName, 0)
+	}
+	return inst.Client.RegisterService(mux, r)
+}
+
+// Instances 返回服务 name 的所有实例，包括已被停止的实例
+func (c *Cluster) Instances(name registry.ServiceName) []*Instance {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var result []*Instance
+	for _, inst := range c.instances {
+		if inst.Name == name {
+			result = append(result, inst)
+		}
+	}
+	return result
+}
+
+// Kill 模拟实例崩溃：关闭 HTTP 服务但不向注册中心注销，由心跳检测发现其下线
+func (c *Cluster) Kill(inst *Instance) error {
+	inst.mutex.Lock()
+	defer inst.mutex.Unlock()
+	if inst.server == nil {
+		return fmt.Errorf("instance %v at %s is not running", inst.Name, inst.URL)
+	}
+	err := inst.server.Close()
+	inst.server = nil
+	return err
+}
+
+// Stop 正常关闭实例：先向注册中心注销，再关闭 HTTP 服务
+func (c *Cluster) Stop(inst *Instance) error {
+	inst.mutex.Lock()
+	defer inst.mutex.Unlock()
+	if inst.server == nil {
+		return fmt.Errorf("instance %v at %s is not running", inst.Name, inst.URL)
+	}
+	err := inst.Client.ShutDownService(inst.URL)
+	if closeErr := inst.server.Close(); err == nil {
+		err = closeErr
+	}
+	inst.server = nil
+	return err
+}
+
+// Restart 在原来的地址上重新启动一个被 Kill 或 Stop 的实例，并重新注册
+func (c *Cluster) Restart(inst *Instance) error {
+	if inst.Running() {
+		return fmt.Errorf("instance %v at %s is already running", inst.Name, inst.URL)
+	}
+	ln, err := net.Listen("tcp", inst.addr)
+	if err != nil {
+		return err
+	}
+	// 所有服务共用 http.DefaultTransport，到原来的实例的空闲连接已经失效，
+	// 不丢弃的话注册中心可能在这些连接上向新实例发送 POST 并得到 EOF
+	if t, ok := http.DefaultTransport.(*http.Transport); ok {
+		t.CloseIdleConnections()
+	}
+	return c.serve(inst, ln)
+}
+
+// Running 判断实例当前是否在运行
+func (inst *Instance) Running() bool {
+	inst.mutex.Lock()
+	defer inst.mutex.Unlock()
+	return inst.server != nil
+}
+
+// LogFile 返回日志服务实例写入的文件路径，其他服务返回空字符串
+func (inst *Instance) LogFile() string {
+	return inst.logFile
+}
+
+// Registered 返回注册中心当前记录的 name 服务的全部 URL
+func (c *Cluster) Registered(name registry.ServiceName) []string {
+	var urls []string
+	for _, r := range c.registry.Registrations() {
+		if r.ServiceName == name {
+			urls = append(urls, r.ServiceUrl)
+		}
+	}
+	return urls
+}
+
+// ErrTimeout 表示在等待时间内集群没有达到期望的状态
+var ErrTimeout = errors.New("testcluster: timed out waiting for condition")
+
+// WaitFor 每隔一小段时间检查 cond，直到它返回 true 或超过 timeout
+func (c *Cluster) WaitFor(timeout time.Duration, cond func() bool) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		if cond() {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return ErrTimeout
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// WaitForProviders 等待 consumer 发现的 name 服务提供者恰好为 want（不考虑顺序）
+func (c *Cluster) WaitForProviders(consumer *Instance, name registry.ServiceName, want []string, timeout time.Duration) error {
+	err := c.WaitFor(timeout, func() bool {
+		return sameURLs(consumer.Client.GetProviders(name), want)
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %v providers seen by %s are %v, want %v",
+			err, name, consumer.URL, consumer.Client.GetProviders(name), want)
+	}
+	return nil
+}
+
+// WaitForConvergence 等待注册中心只保留正在运行的实例，
+// 并且每个正在运行的实例都发现了其依赖服务的全部正在运行的实例
+func (c *Cluster) WaitForConvergence(timeout time.Duration) error {
+	err := c.WaitFor(timeout, c.converged)
+	if err != nil {
+		return fmt.Errorf("%w: cluster did not converge", err)
+	}
+	return nil
+}
+
+// converged 判断集群是否已收敛
+func (c *Cluster) converged() bool {
+	c.mutex.Lock()
+	instances := append([]*Instance(nil), c.instances...)
+	c.mutex.Unlock()
+
+	running := make(map[registry.ServiceName][]string)
+	for _, inst := range instances {
+		if inst.Running() {
+			running[inst.Name] = append(running[inst.Name], inst.URL)
+		}
+	}
+	registered := make(map[registry.ServiceName][]string)
+	for _, r := range c.registry.Registrations() {
+		registered[r.ServiceName] = append(registered[r.ServiceName], r.ServiceUrl)
+	}
+	for _, name := range []registry.ServiceName{registry.LogService, registry.GradingService} {
+		if !sameURLs(running[name], registered[name]) {
+			return false
+		}
+	}
+	for _, inst := range instances {
+		if !inst.Running() {
+			continue
+		}
+		for _, req := range inst.required {
+			if !sameURLs(inst.Client.GetProviders(req), running[req]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// TB 是 testing.TB 中 testcluster 需要的部分
+type TB interface {
+	Helper()
+	Fatalf(format string, args ...interface{})
+}
+
+// AssertProviders 断言 consumer 发现的 name 服务提供者恰好为 want
+func (c *Cluster) AssertProviders(t TB, consumer *Instance, name registry.ServiceName, want ...string) {
+	t.Helper()
+	if got := consumer.Client.GetProviders(name); !sameURLs(got, want) {
+		t.Fatalf("%v providers seen by %s are %v, want %v", name, consumer.URL, got, want)
+	}
+}
+
+// Close 停止集群中的所有服务，并删除自动创建的临时目录
+func (c *Cluster) Close() error {
+	var err error
+	c.closedOnce.Do(func() {
+		if c.cancel != nil {
+			c.cancel()
+		}
+		c.mutex.Lock()
+		instances := append([]*Instance(nil), c.instances...)
+		c.mutex.Unlock()
+		for _, inst := range instances {
+			if inst.Running() {
+				c.Kill(inst)
+			}
+			if inst.closer != nil {
+				inst.closer.Close()
+			}
+		}
+		if c.regServer != nil {
+			err = c.regServer.Close()
+		}
+		if c.removeDir {
+			if rmErr := os.RemoveAll(c.opts.Dir); err == nil {
+				err = rmErr
+			}
+		}
+	})
+	return err
+}
+
+// sameURLs 判断两个 URL 列表是否包含相同的元素（不考虑顺序）
+func sameURLs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string(nil), a...)
+	b = append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,123 @@
+package testcluster_test
+
+import (
+	"go-distributed/registry"
+	"go-distributed/testcluster"
+	"testing"
+	"time"
+)
+
+// waitTimeout 是等待集群状态变化的上限，心跳检测的间隔为默认的 100ms
+const waitTimeout = 5 * time.Second
+
+// start 启动集群并在测试结束时关闭
+func start(t *testing.T, opts testcluster.Options) *testcluster.Cluster {
+	t.Helper()
+	c, err := testcluster.Start(opts)
+	if err != nil {
+		t.Fatalf("start cluster: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	if err := c.WaitForConvergence(waitTimeout); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestDiscovery(t *testing.T) {
+	c := start(t, testcluster.Options{LogInstances: 2})
+	logs := c.Instances(registry.LogService)
+	grading := c.Instances(registry.GradingService)[0]
+	c.AssertProviders(t, grading, registry.LogService, logs[0].URL, logs[1].URL)
+
+	// 之后启动的日志服务实例会通知已经在运行的依赖方
+	added, err := c.StartLogService()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WaitForProviders(grading, registry.LogService, []string{logs[0].URL, logs[1].URL, added.URL}, waitTimeout); err != nil {
+		t.Fatal(err)
+	}
+
+	// 之后启动的成绩服务实例在注册时就得到已有的日志服务
+	second, err := c.StartGradingService()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.AssertProviders(t, second, registry.LogService, logs[0].URL, logs[1].URL, added.URL)
+	if got := c.Registered(registry.GradingService); len(got) != 2 {
+		t.Fatalf("registered grading services are %v, want %s and %s", got, grading.URL, second.URL)
+	}
+}
+
+func TestDeregistration(t *testing.T) {
+	c := start(t, testcluster.Options{LogInstances: 2})
+	logs := c.Instances(registry.LogService)
+	grading := c.Instances(registry.GradingService)[0]
+
+	if err := c.Stop(logs[0]); err != nil {
+		t.Fatal(err)
+	}
+	// 正常关闭的实例立即从注册中心注销，不需要等待心跳检测
+	if got := c.Registered(registry.LogService); len(got) != 1 || got[0] != logs[1].URL {
+		t.Fatalf("registered log services after stop are %v, want [%s]", got, logs[1].URL)
+	}
+	if err := c.WaitForProviders(grading, registry.LogService, []string{logs[1].URL}, waitTimeout); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Stop(logs[0]); err == nil {
+		t.Fatal("stopping a stopped instance succeeded")
+	}
+
+	if err := c.Restart(logs[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WaitForConvergence(waitTimeout); err != nil {
+		t.Fatal(err)
+	}
+	c.AssertProviders(t, grading, registry.LogService, logs[0].URL, logs[1].URL)
+}
+
+func TestFailover(t *testing.T) {
+	c := start(t, testcluster.Options{LogInstances: 2})
+	logs := c.Instances(registry.LogService)
+	grading := c.Instances(registry.GradingService)[0]
+
+	// 崩溃的实例没有注销，由心跳检测发现并通知依赖方
+	if err := c.Kill(logs[0]); err != nil {
+		t.Fatal(err)
+	}
+	if logs[0].Running() {
+		t.Fatal("killed instance is still running")
+	}
+	if err := c.WaitForConvergence(waitTimeout); err != nil {
+		t.Fatal(err)
+	}
+	c.AssertProviders(t, grading, registry.LogService, logs[1].URL)
+	for i := 0; i < 10; i++ {
+		provider, err := grading.Client.GetProvider(registry.LogService)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if provider != logs[1].URL {
+			t.Fatalf("provider after failover is %s, want %s", provider, logs[1].URL)
+		}
+	}
+
+	// 所有提供者都下线后依赖方找不到服务，恢复后又能重新发现
+	if err := c.Kill(logs[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WaitForProviders(grading, registry.LogService, nil, waitTimeout); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := grading.Client.GetProvider(registry.LogService); err == nil {
+		t.Fatal("found a log service provider with every instance down")
+	}
+	if err := c.Restart(logs[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WaitForProviders(grading, registry.LogService, []string{logs[0].URL}, waitTimeout); err != nil {
+		t.Fatal(err)
+	}
+}